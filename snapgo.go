@@ -4,7 +4,11 @@ package main
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -14,36 +18,176 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // Estructuras de datos
 type SnapshotMeta struct {
+	ID           string   `json:"id"`
+	Timestamp    string   `json:"timestamp"`
+	Message      string   `json:"message"`
+	Hash         string   `json:"hash"`
+	FileCount    int      `json:"file_count"`
+	Files        []string `json:"files,omitempty"` // En <id>.meta.json; ver loadSnapshotFiles
+	Branch       string   `json:"branch,omitempty"`
+	Parent       string   `json:"parent,omitempty"`
+	SignatureKey string   `json:"signature_key,omitempty"`
+	ExplicitList bool     `json:"explicit_list,omitempty"` // Creado con --from-list
+	Annotations  []string `json:"annotations,omitempty"`   // Notas añadidas con 'annotate'; no afectan al hash
+	Author       string   `json:"author,omitempty"`        // SNAPGO_AUTHOR o el usuario del sistema
+	Protected    bool     `json:"protected,omitempty"`     // Nunca se borra al limpiar (--retain, 'protect')
+	Base         string   `json:"base,omitempty"`          // Incremental: el archivo solo guarda lo cambiado desde Base
+	
+	FollowSymlinks bool `json:"follow_symlinks,omitempty"` // Se guardó el contenido enlazado en vez de los enlaces
+}
+
+// Lista de archivos de un snapshot, guardada fuera de index.json para que
+// el índice siga siendo pequeño y rápido de leer
+type SnapshotFiles struct {
 	ID        string   `json:"id"`
-	Timestamp string   `json:"timestamp"`
-	Message   string   `json:"message"`
-	Hash      string   `json:"hash"`
-	FileCount int      `json:"file_count"`
 	Files     []string `json:"files"`
+	Inherited []string `json:"inherited,omitempty"` // Incremental: sin cambios, se toman de la base
 }
 
 type Index struct {
-	Snapshots []SnapshotMeta `json:"snapshots"`
-	Current   string         `json:"current"`
+	Snapshots []SnapshotMeta    `json:"snapshots"`
+	Current   string            `json:"current"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Branches  map[string]string `json:"branches,omitempty"` // rama → último snapshot
+	
+	LastBranch string `json:"last_branch,omitempty"` // Rama anterior, para 'switch -'
 }
 
 type Config struct {
-	Version        string   `json:"version"`
-	AutoIgnore     []string `json:"auto_ignore"`
-	Compression    int      `json:"compression_level"`
-	MaxSnapshots   int      `json:"max_snapshots"`
-	ChunkSizeMB    int      `json:"chunk_size_mb"`
-	UseDelta       bool     `json:"use_delta"`
-	Aliases        bool     `json:"enable_aliases"`
-	EnableTrash    bool     `json:"enable_trash"`
-	GitMode        bool     `json:"git_mode"`
+	Version             string   `json:"version"`
+	AutoIgnore          []string `json:"auto_ignore"`
+	Compression         int      `json:"compression_level"`
+	MaxSnapshots        int      `json:"max_snapshots"` // 0 = sin límite
+	ChunkSizeMB         int      `json:"chunk_size_mb"`
+	UseDelta            bool     `json:"use_delta"`
+	Aliases             bool     `json:"enable_aliases"`
+	EnableTrash         bool     `json:"enable_trash"`
+	GitMode             bool     `json:"git_mode"`
+	IOThrottleMBps      int      `json:"io_throttle_mbps"`
+	SignSnapshots       bool     `json:"sign_snapshots"`
+	MaxFileCount        int      `json:"max_file_count"`
+	WarnFileCount       int      `json:"warn_file_count"`
+	BranchMessagePrefix bool     `json:"branch_message_prefix"`
+	PrefixSkipMain      bool     `json:"branch_prefix_skip_main"`
+	
+	Retention     *RetentionConfig `json:"retention,omitempty"`
+	ArchiveLayout string           `json:"archive_layout,omitempty"` // "flat" (por defecto) o "sharded"
+	
+	PreserveOwnership bool `json:"preserve_ownership"` // Restaurar uid/gid (requiere permisos)
+	FollowSymlinks    bool `json:"follow_symlinks"`    // Copiar el destino de los enlaces simbólicos
+	
+	// "ignored": excluir lo que git ignora; "tracked": solo archivos versionados
+	RespectGitStatus string `json:"respect_git_status"`
+	
+	// Extensiones ya comprimidas (.jpg, .zip...) que se guardan sin volver a comprimir
+	SkipCompressedExtensions []string `json:"skip_compressed_extensions"`
+	
+	// 'clean' mueve los snapshots descartados a la papelera en vez de borrarlos
+	CleanToTrash bool `json:"clean_to_trash"`
+	
+	// Archivos que se leen a la vez al crear un snapshot (0 o 1 = de uno en uno)
+	ReadConcurrency int `json:"read_concurrency"`
+	
+	// Remoto y rama de git-sync/git-share (vacío = origin y main)
+	GitRemote string `json:"git_remote"`
+	GitBranch string `json:"git_branch"`
+	
+	// Repositorio solo de copias ('init --bare'): sin directorio de trabajo
+	Bare bool `json:"bare,omitempty"`
+	
+	// Tiempo máximo de un snapshot, como "10m" (vacío o "0" = sin límite)
+	SnapshotTimeout string `json:"snapshot_timeout"`
+	
+	// Llevar los valores numéricos fuera de rango al límite más cercano en
+	// vez de rechazarlos
+	ClampInvalidConfig bool `json:"clamp_invalid_config"`
+	
+	// Autor de los snapshots nuevos (user.name); SNAPGO_AUTHOR tiene
+	// prioridad y, si los dos están vacíos, se usa el usuario del sistema
+	UserName string `json:"user_name"`
+}
+
+// Distribución de los archivos de snapshot dentro de snapshots/
+const (
+	layoutFlat    = "flat"    // snapshots/<id>.tar.gz
+	layoutSharded = "sharded" // snapshots/<yyyy>/<mm>/<id>.tar.gz
+)
+
+// Política de retención "abuelo-padre-hijo" para 'clean --policy gfs'
+type RetentionConfig struct {
+	KeepAllHours  int `json:"keep_all_hours"`      // Conservar todo lo reciente
+	DailyDays     int `json:"keep_daily_days"`     // Uno por día durante N días
+	WeeklyWeeks   int `json:"keep_weekly_weeks"`   // Uno por semana durante N semanas
+	MonthlyMonths int `json:"keep_monthly_months"` // Uno por mes (0 = sin límite)
+}
+
+func defaultRetention() RetentionConfig {
+	return RetentionConfig{KeepAllHours: 24, DailyDays: 7, WeeklyWeeks: 4}
+}
+
+// Metadatos de una entrada de la papelera
+type TrashMeta struct {
+	Reason     string `json:"reason"`
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	FileCount  int    `json:"file_count"`
+	Timestamp  string `json:"timestamp"`
+	
+	// Snapshots retirados del índice; sus archivos están en la entrada de
+	// la papelera bajo .snapgo/ y 'trash restore' los devuelve al índice
+	Snapshots []SnapshotMeta `json:"snapshots,omitempty"`
+}
+
+// Nombre del archivo de metadatos dentro de cada subdirectorio de la papelera.
+// Es oculto para no chocar con un meta.json del propio proyecto. Se escribe
+// al final, así que una entrada sin él quedó a medias (o es muy antigua).
+const trashMetaFile = ".meta.json"
+
+// Opciones adicionales para crear un snapshot
+type SnapshotOptions struct {
+	ThrottleMBps int    // Límite de E/S en MB/s (0 = usar configuración)
+	Sign         bool   // Firmar con GPG aunque la configuración no lo pida
+	Force        bool   // Ignorar el límite de archivos por snapshot
+	FromList     string // Lista explícita de archivos ("-" = stdin)
+	
+	FollowSymlinks bool // Guardar el contenido de los enlaces en vez del enlace
+	Verbose        bool // Mostrar estadísticas de compresión
+	Empty          bool // Crear un snapshot sin archivos
+	DedupeCheck    bool // Avisar de archivos grandes con contenido idéntico
+	IncludeStaged  bool // Incluir el contenido preparado con 'add-content'
+	Protect        bool // Marcar el snapshot como protegido frente a la limpieza
+	IncludeGitDir  bool // Incluir .git/ aunque esté ignorado (solo este snapshot)
+	SinceSnapshot  string // Incremental: guardar solo lo cambiado desde este snapshot
+	
+	ReadConcurrency int           // Archivos leídos en paralelo (0 = usar configuración)
+	Timeout         time.Duration // Abortar si el snapshot tarda más (0 = usar configuración)
+}
+
+// Comandos que leen o escriben el directorio de trabajo; en un repositorio
+// bare no se pueden usar
+var workingTreeCommands = map[string]bool{
+	"snapshot":     true,
+	"restore":      true,
+	"rollback":     true,
+	"revert":       true,
+	"status":       true,
+	"switch":       true,
+	"check-ignore": true,
+	"add-content":  true,
+	"git-sync":     true,
+	"git-save":     true,
+	"git-back":     true,
+	"git-share":    true,
 }
 
 // Alias para comandos SnapGo
@@ -63,9 +207,12 @@ var commandAliases = map[string]string{
 	"save":  "git-save",
 	"back":  "git-back",
 	"share": "git-share",
+	"head":  "last",
 }
 
 func main() {
+	os.Args = stripGlobalFlags(os.Args)
+	must(checkConfigOverrides())
 	if len(os.Args) < 2 {
 		usage()
 		return
@@ -86,30 +233,50 @@ func main() {
 		rootDir = "." // Usar directorio actual si no se encuentra
 	}
 	
+	if err := migrateIndex(rootDir); err != nil {
+		fmt.Printf("⚠️  No se pudo migrar el índice: %v\n", err)
+	}
+	
 	if alias, ok := commandAliases[cmd]; ok {
 		cmd = alias
 		os.Args[1] = alias
 	}
+	
+	if workingTreeCommands[cmd] {
+		must(requireWorkingTree(rootDir, cmd))
+	}
 
 	switch cmd {
 	case "init":
-		must(initRepo("."))
+		initCmd()
 	case "snapshot":
 		snapshotCmdWithRoot(rootDir)
 	case "list":
-		must(listSnapshots(rootDir))
+		listCmdWithRoot(rootDir)
 	case "show":
-		if len(os.Args) < 3 {
-			fmt.Println("Uso: show <id>")
-			return
-		}
-		must(showSnapshot(rootDir, os.Args[2]))
+		showCmdWithRoot(rootDir)
+	case "last":
+		lastCmdWithRoot(rootDir)
+	case "ls":
+		lsCmdWithRoot(rootDir)
+	case "tree":
+		treeCmdWithRoot(rootDir)
+	case "grep":
+		grepCmdWithRoot(rootDir)
 	case "restore":
 		restoreCmdWithRoot(rootDir)
+	case "rollback":
+		rollbackCmdWithRoot(rootDir)
+	case "revert":
+		must(revertHead(rootDir))
 	case "diff":
 		diffCmdWithRoot(rootDir)
 	case "status":
 		must(statusCmdWithRoot(rootDir))
+	case "check-ignore":
+		checkIgnoreCmdWithRoot(rootDir)
+	case "add-content":
+		addContentCmdWithRoot(rootDir)
 	case "history":
 		must(historyCmdWithRoot(rootDir))
 	case "clean":
@@ -124,17 +291,86 @@ func main() {
 		trashCmdWithRoot(rootDir)
 	case "git-sync", "git-save", "git-back", "git-share":
 		gitModeCmdWithRoot(cmd, rootDir)
+	case "verify":
+		verifyCmdWithRoot(rootDir)
+	case "fsck":
+		fsckCmdWithRoot(rootDir)
+	case "clone":
+		cloneCmd()
+	case "import":
+		importCmdWithRoot(rootDir)
+	case "export":
+		exportCmdWithRoot(rootDir)
+	case "tag":
+		tagCmdWithRoot(rootDir)
+	case "reflog":
+		reflogCmdWithRoot(rootDir)
+	case "annotate":
+		annotateCmdWithRoot(rootDir)
+	case "protect", "unprotect":
+		if len(os.Args) != 3 {
+			fmt.Printf("Uso: %s <id>\n", cmd)
+			return
+		}
+		must(setProtected(rootDir, os.Args[2], cmd == "protect"))
+	case "gc":
+		gcCmdWithRoot(rootDir)
+	case "prune":
+		pruneCmdWithRoot(rootDir)
+	case "purge":
+		purgeCmdWithRoot(rootDir)
+	case "stats":
+		must(statsCmdWithRoot(rootDir))
 	case "debug":
 		// Comando de diagnóstico para debug
 		must(debugRepo(rootDir))
 	case "help", "--help", "-h":
 		usage()
 	default:
+		if runPlugin(rootDir, cmd, os.Args[2:]) {
+			return
+		}
 		fmt.Printf("Comando desconocido: %s\n", cmd)
 		fmt.Println("Usa 'snapgo help' para ver los comandos disponibles")
 	}
 }
 
+// Ejecuta el plugin snapgo-<cmd> del PATH, como hace git. Devuelve false
+// si no existe; si existe, termina el proceso con su código de salida.
+func runPlugin(root, cmd string, args []string) bool {
+	if cmd == "" || strings.ContainsAny(cmd, `/\`) || strings.HasPrefix(cmd, "-") {
+		return false
+	}
+	path, err := lookupTool("snapgo-" + cmd)
+	if err != nil {
+		return false
+	}
+	
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = root
+	}
+	
+	c := exec.Command(path, args...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Env = append(os.Environ(), "SNAPGO_DIR="+absRoot)
+	if quiet {
+		c.Env = append(c.Env, "SNAPGO_QUIET=1")
+	}
+	
+	if err := c.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Printf("❌ Error ejecutando %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+	return true
+}
+
 func usage() {
 	fmt.Println("╔═══════════════════════════════════════════════════════╗")
 	fmt.Println("║                  S N A P G O  v1.0                    ║")
@@ -143,30 +379,141 @@ func usage() {
 	fmt.Println()
 	fmt.Println("📦 Comandos básicos:")
 	fmt.Println("  init                         Inicializar repositorio")
+	fmt.Println("       [--template <dir>]      Copiar config.json y .snapgoignore de una plantilla")
+	fmt.Println("  init --bare <dir>            Repositorio solo de copias (import/export/list/verify)")
 	fmt.Println("  snapshot -m <mensaje>        Crear snapshot (alias: s)")
-	fmt.Println("  list                         Listar snapshots (alias: l)")
-	fmt.Println("  show <id>                    Mostrar detalles (alias: sh)")
+	fmt.Println("           [--nice <MB/s>]     Limitar E/S: más lento, pero el equipo sigue fluido")
+	fmt.Println("           [--sign]            Firmar con GPG (<id>.tar.gz.sig)")
+	fmt.Println("           [--force]           Ignorar el límite max_file_count")
+	fmt.Println("           [--from-list <f|->] Capturar exactamente las rutas listadas")
+	fmt.Println("           [--follow-symlinks] Guardar el contenido enlazado, no el enlace")
+	fmt.Println("           [-v]                Estadísticas de compresión")
+	fmt.Println("           [--empty]           Snapshot vacío (base para comparar)")
+	fmt.Println("           [--dedupe-check]    Avisar de archivos grandes (>1 MB) duplicados")
+	fmt.Println("           [--parallel-read N] Leer N archivos a la vez (discos SSD/NVMe)")
+	fmt.Println("           [--retain]          Proteger de la limpieza (alias: --protect)")
+	fmt.Println("           [--include-gitdir]  Incluir .git/ (ramas locales, stash...) solo esta vez")
+	fmt.Println("           [--timeout <dur>]   Abortar si tarda más, p. ej. 5m (config: snapshot_timeout)")
+	fmt.Println("           [--json]            Imprimir solo los metadatos del snapshot creado")
+	fmt.Println("           [--since-snapshot <id>]  Incremental: solo lo cambiado desde <id> (restore usa la base)")
+	fmt.Println("           [--message-from-git]  Mensaje del último commit de git (-m tiene prioridad)")
+	fmt.Println("  add-content <ruta> < datos   Preparar contenido de stdin para el próximo snapshot")
+	fmt.Println("              [--clear]        Descartar lo preparado")
+	fmt.Println("  list [--size]                Listar snapshots (alias: l); --size: espacio en disco")
+	fmt.Println("       [--branch <rama>|--all] Solo una rama, o todas indicando la rama de cada uno")
+	fmt.Println("  show <id> [--by-type]        Mostrar detalles (alias: sh)")
+	fmt.Println("       [--parent]              Imprimir solo el ID del padre (para scripts)")
+	fmt.Println("  last [--id]                  Último snapshot (alias: head)")
+	fmt.Println("  ls <id> [ruta] [-R]          Explorar un snapshot directorio a directorio")
+	fmt.Println("  tree <id> [--depth N]        Árbol completo de un snapshot")
+	fmt.Println("  grep <regex> [id] [-i]       Buscar en el contenido de un snapshot (HEAD por defecto)")
+	fmt.Println("       [--count]               Solo el número de coincidencias por archivo")
 	fmt.Println("  restore <id> [--force]       Restaurar (alias: r)")
-	fmt.Println("  diff <id1> <id2>             Comparar (alias: d)")
+	fmt.Println("       [--merge[=newer]]       Solo restaurar archivos que faltan (o más antiguos)")
+	fmt.Println("       [--keep-newer]          Por archivo, quedarse con la versión más reciente")
+	fmt.Println("       [--only <patrón>]       Solo archivos que casen (p. ej. 'src/**/*.go')")
+	fmt.Println("       [--out-dir <dir>]       Restaurar en <dir> en vez de _restore_<id>")
+	fmt.Println("       [--verify-after]        Comprobar los hashes tras restaurar")
+	fmt.Println("       [--dry-run]             Vista previa: qué se crearía/sobrescribiría/eliminaría")
+	fmt.Println("       [--strip <n>]           Quitar n directorios iniciales de cada ruta")
+	fmt.Println("       [--force-write]         Reescribir incluso los archivos que no cambiaron")
+	fmt.Println("       [--backup-label <txt>]  Mensaje del backup automático de --force")
+	fmt.Println("       [--no-backup]           Con --force, no crear el backup automático")
+	fmt.Println("       [--no-preserve-times]   Hora actual en vez de la del snapshot (con --keep-newer")
+	fmt.Println("                               esos archivos cuentan luego como más recientes)")
+	fmt.Println("       [--no-atomic-per-file]  Escribir directamente, sin temporal + rename")
+	fmt.Println("  restore --at <fecha>         Restaurar el estado vigente en esa fecha ('2025-12-16 15:00')")
+	fmt.Println("  restore --branch <rama>      Restaurar el último snapshot de otra rama sin cambiar de rama")
+	fmt.Println("  rollback <id>                Volver a un snapshot registrándolo en el historial")
+	fmt.Println("  revert                       Deshacer el último snapshot de la rama (va a la papelera)")
+	fmt.Println("  diff [id]                    Comparar con el directorio de trabajo (por defecto HEAD)")
+	fmt.Println("  diff <id1> <id2>             Comparar dos snapshots (alias: d)")
+	fmt.Println("  diff <id> --dir <ruta>       Comparar con otro directorio")
+	fmt.Println("  diff <id> --working          Comparar con el directorio de trabajo")
+	fmt.Println("       [--summary-only]        Solo resumen; sale con 2 si hay diferencias (CI)")
+	fmt.Println("       [-p|--patch]            Mostrar el contenido cambiado (diff de líneas)")
+	fmt.Println("       [--word-diff]           Resaltar las palabras cambiadas [-antes-]{+después+}")
+	fmt.Println("       [-U|--context <n>]      Líneas de contexto de cada cambio (por defecto 3)")
+	fmt.Println("       [--output <archivo>]    Guardar el diff en un archivo")
+	fmt.Println("       [--no-color]            Sin colores (también con NO_COLOR)")
+	fmt.Println("       [--reverse]             Diff inverso: cómo deshacer el cambio")
+	fmt.Println("       [-w]                    Ignorar cambios solo de espacios en blanco")
+	fmt.Println("       [--only <patrón>]       Limitar a ciertas rutas (repetible, admite **)")
+	fmt.Println("       [--json]                JSON con hashes, tamaños y renombrados")
+	fmt.Println("       [--binary-size]         Tamaño antes/después de los binarios modificados")
 	fmt.Println()
 	fmt.Println("🔧 Comandos avanzados:")
-	fmt.Println("  status                       Ver estado actual (alias: st)")
+	fmt.Println("  status [--short]             Ver estado actual (alias: st)")
+	fmt.Println("         [--ignored]           Listar lo excluido y el patrón responsable")
+	fmt.Println("  check-ignore <ruta>...       ¿Se ignora la ruta? ¿Por qué patrón? [--stdin]")
 	fmt.Println("  history                      Historial con formato (alias: log)")
+	fmt.Println("          [--author <texto>]   Solo los snapshots de ese autor")
+	fmt.Println("          [--reverse]          Del más antiguo al más reciente")
 	fmt.Println("  clean                        Limpiar snapshots viejos (alias: c)")
+	fmt.Println("        [--keep N]             Conservar los N más recientes (por defecto max_snapshots)")
+	fmt.Println("        [--policy gfs]         Retención diaria/semanal/mensual (config: retention)")
+	fmt.Println("        [--dry-run]            Mostrar qué se conservaría sin borrar")
+	fmt.Println("        [--trash|--permanent]  Papelera o borrado definitivo (config: clean_to_trash)")
+	fmt.Println("  prune --unreachable          Eliminar snapshots fuera de toda rama/etiqueta")
+	fmt.Println("  gc --compact [--level N]     Recomprimir los archivos con el nivel actual")
+	fmt.Println("  gc --orphan-trash            Eliminar entradas de la papelera que quedaron a medias")
+	fmt.Println("  purge <id> [-y]              Borrar un snapshot para siempre (no va a la papelera)")
 	fmt.Println("  branch [nombre]              Listar/crear ramas (alias: b)")
-	fmt.Println("  switch <nombre>              Cambiar rama (alias: sw)")
+	fmt.Println("         [--verbose] [--json]  Cabeza, snapshots y última actividad de cada rama")
+	fmt.Println("  switch <nombre>|-            Cambiar rama; '-' vuelve a la anterior (alias: sw)")
+	fmt.Println("  tag [nombre [id]] [-d]       Listar/crear/eliminar etiquetas")
+	fmt.Println("  reflog [-n N]                Movimientos de HEAD (snapshot, switch, restore...)")
+	fmt.Println("  annotate <id> [-m|-F]        Añadir una nota a un snapshot (sin -m: $EDITOR)")
+	fmt.Println("  protect|unprotect <id>       Proteger (o no) un snapshot frente a la limpieza")
 	fmt.Println("  config                       Mostrar configuración")
+	fmt.Println("  config set <clave> <valor>   Cambiar configuración (p. ej. archive_layout sharded)")
+	fmt.Println("  config set autoignore add|remove <patrón>  Gestionar la lista auto_ignore")
+	fmt.Println("  config get <clave>           Valor efectivo de una clave")
+	fmt.Println("  config reset [clave]         Volver a los valores por defecto")
+	fmt.Println("         [--global]            set/get/reset sobre la configuración del usuario")
+	fmt.Println("  config list [--json]         Todas las claves con tipo, valor y descripción")
+	fmt.Println("  config --show-effective      Configuración combinada y origen de cada valor [--json]")
 	fmt.Println("  trash [list|empty|restore]   Gestionar papelera (alias: t)")
+	fmt.Println("  stats                        Estadísticas del repositorio por tipo de archivo")
+	fmt.Println("        [--churn]              Archivos que más cambian [--limit N] [--json]")
+	fmt.Println("  verify [--signatures]        Verificar integridad de los snapshots")
+	fmt.Println("         [--deep]              Recalcular hashes de contenido (lento)")
+	fmt.Println("         [--repair]            Quitar entradas sin archivo / registrar archivos huérfanos")
+	fmt.Println("  fsck [--deep] [--fix]        Revisión completa del repositorio (y reparar lo seguro)")
+	fmt.Println("  clone <origen> <destino>     Copiar un repositorio completo [--bare] [--trash]")
+	fmt.Println("  import <repo>                Traer snapshots de otro repositorio SnapGo")
+	fmt.Println("         [--ours|--theirs|--rename]  Cómo resolver IDs iguales con contenido distinto")
+	fmt.Println("  import --bundle <f> [dir]    Crear un repositorio a partir de un bundle (--force)")
+	fmt.Println("  export <id> <archivo>        Copiar el archivo .tar.gz de un snapshot")
+	fmt.Println("  export --all <bundle>        Empaquetar todo el repositorio en un bundle portable")
+	fmt.Println("         [--format zip]        Exportar el contenido como .zip (con --all, una carpeta por snapshot)")
 	fmt.Println()
 	fmt.Println("🎯 Nombres especiales:")
 	fmt.Println("  HEAD     Último snapshot")
 	fmt.Println("  PREV     Anterior al último")
+	fmt.Println("  HEAD~N   N snapshots antes del último")
+	fmt.Println("  @{N}     Cabeza tras el N-ésimo movimiento más reciente (ver reflog)")
+	fmt.Println("  <tag>    Snapshot con esa etiqueta")
+	fmt.Println("  <prefijo> Prefijo único del ID o del hash")
 	fmt.Println()
 	fmt.Println("ℹ️  Otros comandos:")
+	fmt.Println("  -q, --quiet                  Solo mostrar errores (útil en cron)")
+	fmt.Println("  -c <clave>=<valor>           Cambiar una clave de config solo para esta ejecución")
 	fmt.Println("  debug                        Diagnóstico del repositorio")
 	fmt.Println("  version                      Mostrar versión")
 	fmt.Println("  help                         Mostrar esta ayuda")
 	fmt.Println()
+	fmt.Println("🏷️  Atributos (.snapgoattributes, una regla por línea: <patrón> <atributo>...):")
+	fmt.Println("  binary      El diff no muestra su contenido")
+	fmt.Println("  text        Se compara como texto aunque tenga bytes nulos")
+	fmt.Println("  nocompress  Se guarda sin comprimir (como skip_compressed_extensions)")
+	fmt.Println("  lfs-skip    No se guarda en los snapshots")
+	fmt.Println()
+	fmt.Println("🔌 Extensiones:")
+	fmt.Println("  snapgo <cmd> ejecuta 'snapgo-<cmd>' del PATH si <cmd> no es un comando propio.")
+	fmt.Println("  Recibe los argumentos restantes, SNAPGO_DIR (raíz del repositorio) y")
+	fmt.Println("  SNAPGO_QUIET=1 con -q; snapgo termina con el mismo código de salida.")
+	fmt.Println()
 	fmt.Println("📚 Ejemplos:")
 	fmt.Println("  snapgo init")
 	fmt.Println("  snapgo snapshot -m \"Mi primer snapshot\"")
@@ -177,11 +524,113 @@ func usage() {
 
 func must(err error) {
 	if err != nil {
-		fmt.Println("❌ Error:", err)
+		fmt.Fprintln(os.Stderr, "❌ Error:", err)
 		os.Exit(1)
 	}
 }
 
+// Modo silencioso (-q/--quiet): suprime la salida informativa de los
+// comandos; los errores siguen saliendo por stderr con su código de salida
+var quiet bool
+
+func logf(format string, a ...any) {
+	if !quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+func logln(a ...any) {
+	if !quiet {
+		fmt.Println(a...)
+	}
+}
+
+// Claves cambiadas solo para esta ejecución con -c clave=valor
+// (capa "flag" de effectiveConfig)
+var configOverrides []string
+
+// Quita -q/--quiet y -c clave=valor de delante del subcomando
+// ("snapgo -q -c compression_level=9 snapshot ..."). Lo que va detrás es
+// del subcomando: un valor de flag (-m -q) o argumentos de un plugin; ahí
+// -q lo reconoce cada FlagSet (addQuietFlag).
+func stripGlobalFlags(args []string) []string {
+	kept := []string{args[0]}
+	i := 1
+	for ; i < len(args); i++ {
+		switch {
+		case args[i] == "-q" || args[i] == "--quiet" || args[i] == "-quiet":
+			quiet = true
+		case args[i] == "-c" && i+1 < len(args):
+			i++
+			configOverrides = append(configOverrides, args[i])
+		default:
+			return append(kept, args[i:]...)
+		}
+	}
+	return kept
+}
+
+// Valida las claves y valores de -c antes de ejecutar nada: muchos
+// comandos siguen con la configuración por defecto si loadConfig falla
+func checkConfigOverrides() error {
+	if len(configOverrides) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(defaultConfig())
+	if err != nil {
+		return err
+	}
+	defaults := map[string]any{}
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return err
+	}
+	for _, override := range configOverrides {
+		key, raw, ok := strings.Cut(override, "=")
+		if !ok {
+			return fmt.Errorf("-c %s: se esperaba clave=valor", override)
+		}
+		current, ok := defaults[canonicalConfigKey(key)]
+		if !ok {
+			return fmt.Errorf("-c %s: clave de configuración desconocida '%s'", override, key)
+		}
+		if _, err := parseConfigValue(current, raw); err != nil {
+			return fmt.Errorf("-c %s: %v", override, err)
+		}
+	}
+	return nil
+}
+
+// Acepta -q/--quiet también después del subcomando ("snapshot -m x -q")
+func addQuietFlag(fs *flag.FlagSet) {
+	if fs.Lookup("q") == nil {
+		fs.BoolVar(&quiet, "q", quiet, "solo mostrar errores")
+		fs.BoolVar(&quiet, "quiet", quiet, "solo mostrar errores")
+	}
+}
+
+// fs.Parse con -q/--quiet
+func parseFlags(fs *flag.FlagSet, args []string) {
+	addQuietFlag(fs)
+	fs.Parse(args)
+}
+
+// parseArgs permite mezclar flags y argumentos posicionales
+// (p. ej. "diff HEAD --dir ../copia"), devolviendo los posicionales.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	addQuietFlag(fs)
+	positional := []string{}
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	return positional
+}
+
 func repoPaths(root string) (snapgoDir, snapsDir, indexPath, configPath, ignorePath, trashDir string) {
 	// Usar rutas absolutas para evitar confusiones
 	absRoot, err := filepath.Abs(root)
@@ -270,30 +719,228 @@ func findRepoRecursive(dir string, depth, maxDepth int) string {
 	return ""
 }
 
+func initCmd() {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	template := fs.String("template", "", "copiar config.json y .snapgoignore de un directorio plantilla")
+	bare := fs.Bool("bare", false, "repositorio solo de copias, sin directorio de trabajo")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if *bare {
+		if len(args) != 1 || *template != "" {
+			fmt.Println("Uso: init --bare <dir>")
+			return
+		}
+		must(initBareRepo(args[0]))
+		return
+	}
+	if *template != "" {
+		must(initFromTemplate(".", *template))
+		return
+	}
+	must(initRepo("."))
+}
+
+// Comprobaciones básicas de una configuración
+func validateConfig(c Config) error {
+	if c.Compression < 0 || c.Compression > 9 {
+		return fmt.Errorf("compression_level debe estar entre 0 y 9 (es %d)", c.Compression)
+	}
+	if c.MaxSnapshots < 0 || c.ChunkSizeMB < 0 || c.MaxFileCount < 0 || c.WarnFileCount < 0 || c.ReadConcurrency < 0 {
+		return fmt.Errorf("max_snapshots, chunk_size_mb, max_file_count, warn_file_count y read_concurrency no pueden ser negativos")
+	}
+	if c.ArchiveLayout != "" && c.ArchiveLayout != layoutFlat && c.ArchiveLayout != layoutSharded {
+		return fmt.Errorf("archive_layout desconocido '%s' (usa %s o %s)", c.ArchiveLayout, layoutFlat, layoutSharded)
+	}
+	if c.RespectGitStatus != "" && c.RespectGitStatus != gitRespectIgnored && c.RespectGitStatus != gitRespectTracked {
+		return fmt.Errorf("respect_git_status desconocido '%s' (usa %s o %s)", c.RespectGitStatus, gitRespectIgnored, gitRespectTracked)
+	}
+	if c.SnapshotTimeout != "" {
+		if d, err := time.ParseDuration(c.SnapshotTimeout); err != nil || d < 0 {
+			return fmt.Errorf("snapshot_timeout inválido '%s' (usa una duración como 30s o 10m)", c.SnapshotTimeout)
+		}
+	}
+	return nil
+}
+
+// Avisos de ajuste ya mostrados: la configuración se carga varias veces en
+// un mismo comando
+var clampWarnings = map[string]bool{}
+
+// Lleva a su rango los valores numéricos que validateConfig rechazaría y
+// devuelve una descripción de cada ajuste
+func clampConfig(c *Config) []string {
+	var clamped []string
+	clamp := func(key string, v *int, min, max int) {
+		old := *v
+		if *v < min {
+			*v = min
+		} else if max >= min && *v > max {
+			*v = max
+		}
+		if *v != old {
+			clamped = append(clamped, fmt.Sprintf("%s ajustado de %d a %d", key, old, *v))
+		}
+	}
+	clamp("compression_level", &c.Compression, 0, 9)
+	clamp("max_snapshots", &c.MaxSnapshots, 0, -1)
+	clamp("chunk_size_mb", &c.ChunkSizeMB, 0, -1)
+	clamp("max_file_count", &c.MaxFileCount, 0, -1)
+	clamp("warn_file_count", &c.WarnFileCount, 0, -1)
+	clamp("read_concurrency", &c.ReadConcurrency, 0, -1)
+	return clamped
+}
+
+// Con clamp_invalid_config ajusta los valores fuera de rango (avisando una
+// vez de cada uno); sin ella, los rechaza
+func checkConfig(c *Config) error {
+	if c.ClampInvalidConfig {
+		for _, warning := range clampConfig(c) {
+			if !clampWarnings[warning] {
+				clampWarnings[warning] = true
+				fmt.Fprintf(os.Stderr, "⚠️  %s (clamp_invalid_config)\n", warning)
+			}
+		}
+	}
+	return validateConfig(*c)
+}
+
+// Inicializa un repositorio con la config.json y el .snapgoignore de una
+// plantilla. La plantilla se valida antes de crear nada; las claves que no
+// defina siguen a la configuración global y a los valores por defecto.
+func initFromTemplate(root, template string) error {
+	_, _, indexPath, configPath, ignorePath, _ := repoPaths(root)
+	if fileExists(indexPath) {
+		return fmt.Errorf("ya existe un repositorio SnapGo en '%s'", root)
+	}
+	
+	info, err := os.Stat(template)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("la plantilla '%s' no es un directorio", template)
+	}
+	
+	tplConfig := filepath.Join(template, "config.json")
+	tplIgnore := filepath.Join(template, ".snapgoignore")
+	if !fileExists(tplConfig) && !fileExists(tplIgnore) {
+		return fmt.Errorf("la plantilla '%s' no contiene config.json ni .snapgoignore", template)
+	}
+	
+	config := map[string]any{}
+	if fileExists(tplConfig) {
+		data, err := os.ReadFile(tplConfig)
+		if err != nil {
+			return err
+		}
+		strict := defaultConfig()
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&strict); err != nil {
+			return fmt.Errorf("config.json de la plantilla inválido: %v", err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("config.json de la plantilla inválido: %v", err)
+		}
+		merged, err := repoConfigWith(config)
+		if err != nil {
+			return err
+		}
+		if err := checkConfig(&merged); err != nil {
+			return fmt.Errorf("config.json de la plantilla inválido: %v", err)
+		}
+		// Con clamp_invalid_config se copian los valores ya ajustados
+		values, err := configValues(merged)
+		if err != nil {
+			return err
+		}
+		for k := range config {
+			var v any
+			if raw, ok := values[k]; ok && json.Unmarshal(raw, &v) == nil {
+				config[k] = v
+			}
+		}
+		if _, ok := config["version"]; !ok {
+			config["version"] = defaultConfig().Version
+		}
+	}
+	
+	// Un .snapgoignore propio del directorio tiene prioridad sobre la plantilla
+	keepIgnore := fileExists(ignorePath)
+	
+	if err := initRepo(root); err != nil {
+		return err
+	}
+	
+	if fileExists(tplConfig) {
+		if err := writeJSON(configPath, config); err != nil {
+			return err
+		}
+		logf("📋 config.json copiado de la plantilla %s\n", template)
+	}
+	if fileExists(tplIgnore) {
+		if keepIgnore {
+			fmt.Printf("⚠️  Ya existe un .snapgoignore; no se copia el de la plantilla\n")
+		} else if _, err := copyFileVerified(tplIgnore, ignorePath); err != nil {
+			return err
+		} else {
+			logf("📋 .snapgoignore copiado de la plantilla %s\n", template)
+		}
+	}
+	return nil
+}
+
 func initRepo(root string) error {
-	snapgoDir, snapsDir, indexPath, configPath, ignorePath, trashDir := repoPaths(root)
+	snapgoDir, _, _, _, _, _ := repoPaths(root)
+	created, err := createRepo(root, false)
+	if err != nil || !created {
+		return err
+	}
+	
+	logln("✅ Repositorio SnapGo inicializado en", snapgoDir)
+	logln("💡 Usa 'snapgo snapshot -m \"mensaje\"' para crear tu primer snapshot")
+	return nil
+}
+
+// Crea un repositorio bare: solo guarda snapshots que llegan con 'import'
+// y salen con 'export'. No tiene .snapgoignore ni directorio de trabajo.
+func initBareRepo(root string) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	if fileExists(indexPath) {
+		return fmt.Errorf("ya existe un repositorio SnapGo en '%s'", root)
+	}
+	if _, err := createRepo(root, true); err != nil {
+		return err
+	}
+	
+	logln("✅ Repositorio bare inicializado en", root)
+	logln("💡 Solo admite import, export, list, verify y similares; no hay directorio de trabajo")
+	return nil
+}
+
+// Crea la estructura de .snapgo. Si ya existe un repositorio muestra su
+// estado y devuelve created = false.
+func createRepo(root string, bare bool) (created bool, err error) {
+	_, snapsDir, indexPath, configPath, ignorePath, trashDir := repoPaths(root)
 	
 	// Verificar si ya existe
 	if _, err := os.Stat(indexPath); err == nil {
 		// Ya existe, mostrar información
 		var idx Index
 		if err := readJSON(indexPath, &idx); err == nil {
-			fmt.Printf("📦 Repositorio SnapGo ya existe aquí\n")
-			fmt.Printf("📊 Snapshots existentes: %d\n", len(idx.Snapshots))
+			logf("📦 Repositorio SnapGo ya existe aquí\n")
+			logf("📊 Snapshots existentes: %d\n", len(idx.Snapshots))
 			if len(idx.Snapshots) > 0 {
 				last := idx.Snapshots[len(idx.Snapshots)-1]
-				fmt.Printf("🕒 Último snapshot: %s - %s\n", last.ID, last.Message)
+				logf("🕒 Último snapshot: %s - %s\n", last.ID, last.Message)
 			}
 		}
-		return nil
+		return false, nil
 	}
 	
 	if err := os.MkdirAll(snapsDir, 0o755); err != nil {
-		return err
+		return false, err
 	}
 	
 	if err := os.MkdirAll(trashDir, 0o755); err != nil {
-		return err
+		return false, err
 	}
 	
 	idx := Index{
@@ -301,25 +948,23 @@ func initRepo(root string) error {
 		Current:   "main",
 	}
 	if err := writeJSON(indexPath, idx); err != nil {
-		return err
+		return false, err
 	}
 	
-	config := Config{
-		Version:      "1.0",
-		AutoIgnore:   []string{"node_modules/", ".git/", "__pycache__/", ".snapgo/", "*.exe", "*.dll", "*.so", "*.dylib"},
-		Compression:  6,
-		MaxSnapshots: 100,
-		ChunkSizeMB:  10,
-		UseDelta:     false,
-		Aliases:      true,
-		EnableTrash:  true,
-		GitMode:      false,
+	// Solo las claves propias del repositorio: el resto sigue a la
+	// configuración global y a los valores por defecto
+	config := map[string]any{
+		"version":     defaultConfig().Version,
+		"auto_ignore": []string{"node_modules/", ".git/", "__pycache__/", ".snapgo/", "*.exe", "*.dll", "*.so", "*.dylib"},
+	}
+	if bare {
+		config["bare"] = true
 	}
 	if err := writeJSON(configPath, config); err != nil {
-		return err
+		return false, err
 	}
 	
-	if _, err := os.Stat(ignorePath); os.IsNotExist(err) {
+	if _, err := os.Stat(ignorePath); os.IsNotExist(err) && !bare {
 		def := `# Archivos ignorados por SnapGo
 # Directorios comunes
 node_modules/
@@ -358,14 +1003,24 @@ desktop.ini
 *.bak
 *.backup
 *~
+
+# Por tamaño (opcionalmente limitado a un patrón):
+# size:>50MB
+# size:>10MB assets/
 `
 		if err := os.WriteFile(ignorePath, []byte(def), 0o644); err != nil {
-			return err
+			return false, err
 		}
 	}
-	
-	fmt.Println("✅ Repositorio SnapGo inicializado en", snapgoDir)
-	fmt.Println("💡 Usa 'snapgo snapshot -m \"mensaje\"' para crear tu primer snapshot")
+	return true, nil
+}
+
+// Error si el repositorio es bare y, por tanto, no tiene directorio de trabajo
+func requireWorkingTree(root, cmd string) error {
+	config, err := loadConfig(root)
+	if err == nil && config.Bare {
+		return fmt.Errorf("'%s' necesita un directorio de trabajo y '%s' es un repositorio bare", cmd, root)
+	}
 	return nil
 }
 
@@ -389,34 +1044,225 @@ func writeJSON(path string, v any) error {
 	return enc.Encode(v)
 }
 
+// Configuración efectiva: valores por defecto, configuración global,
+// la del repositorio y variables de entorno SNAPGO_<CLAVE>, en ese orden
 func loadConfig(root string) (Config, error) {
-	_, _, _, configPath, _, _ := repoPaths(root)
+	config, _, err := effectiveConfig(root)
+	return config, err
+}
+
+func defaultConfig() Config {
+	return Config{
+		Version:        "1.0",
+		AutoIgnore:     []string{"node_modules/", ".git/", "__pycache__/", ".snapgo/", "*.exe", "*.dll"},
+		Compression:    6,
+		MaxSnapshots:   100,
+		ChunkSizeMB:    10,
+		UseDelta:       false,
+		Aliases:        true,
+		EnableTrash:    true,
+		GitMode:        false,
+		MaxFileCount:   defaultMaxFileCount,
+		WarnFileCount:  defaultWarnFileCount,
+		PrefixSkipMain: true,
+		CleanToTrash:   true,
+		GitRemote:      "origin",
+		GitBranch:      "main",
+		
+		SkipCompressedExtensions: []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".zip", ".gz", ".tgz", ".bz2", ".xz", ".7z", ".rar", ".mp3", ".mp4", ".mkv", ".pdf"},
+	}
+}
+
+// Autor de los snapshots nuevos: SNAPGO_AUTHOR, user_name o, si no, el
+// usuario del sistema
+func snapshotAuthor(config Config) string {
+	if v := strings.TrimSpace(os.Getenv("SNAPGO_AUTHOR")); v != "" {
+		return v
+	}
+	if v := strings.TrimSpace(config.UserName); v != "" {
+		return v
+	}
+	for _, key := range []string{"USER", "USERNAME"} {
+		if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Ruta de la configuración global (SNAPGO_GLOBAL_CONFIG o
+// ~/.config/snapgo/config.json)
+func globalConfigPath() string {
+	if p := os.Getenv("SNAPGO_GLOBAL_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "snapgo", "config.json")
+}
+
+// Combina las capas de configuración y devuelve, para cada clave, de dónde
+// salió su valor: default, global, repo, env o flag (-c clave=valor)
+func effectiveConfig(root string) (Config, map[string]string, error) {
+	repo, err := loadRepoConfig(root)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	fields, sources, err := layeredConfigFields(repo)
+	if err != nil {
+		return Config{}, nil, err
+	}
 	
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		config := Config{
-			Version:      "1.0",
-			AutoIgnore:   []string{"node_modules/", ".git/", "__pycache__/", ".snapgo/", "*.exe", "*.dll"},
-			Compression:  6,
-			MaxSnapshots: 100,
-			ChunkSizeMB:  10,
-			UseDelta:     false,
-			Aliases:      true,
-			EnableTrash:  true,
-			GitMode:      false,
+	for key, current := range fields {
+		raw, ok := os.LookupEnv("SNAPGO_" + strings.ToUpper(key))
+		if !ok {
+			continue
 		}
-		if err := writeJSON(configPath, config); err != nil {
-			return Config{}, err
+		v, err := parseConfigValue(current, raw)
+		if err != nil {
+			return Config{}, nil, fmt.Errorf("SNAPGO_%s: %v", strings.ToUpper(key), err)
+		}
+		fields[key] = v
+		sources[key] = "env"
+	}
+	
+	for _, override := range configOverrides {
+		key, raw, ok := strings.Cut(override, "=")
+		if !ok {
+			return Config{}, nil, fmt.Errorf("-c %s: se esperaba clave=valor", override)
+		}
+		key = canonicalConfigKey(key)
+		current, ok := fields[key]
+		if !ok {
+			return Config{}, nil, fmt.Errorf("-c %s: clave de configuración desconocida '%s'", override, key)
+		}
+		v, err := parseConfigValue(current, raw)
+		if err != nil {
+			return Config{}, nil, fmt.Errorf("-c %s: %v", override, err)
+		}
+		fields[key] = v
+		sources[key] = "flag"
+	}
+	
+	config, err := decodeConfigFields(fields)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	// Sin clamp_invalid_config los valores fuera de rango solo se rechazan
+	// al guardarlos, como siempre
+	if config.ClampInvalidConfig {
+		if err := checkConfig(&config); err != nil {
+			return Config{}, nil, err
+		}
+	}
+	return config, sources, nil
+}
+
+// Valores por defecto con la configuración global y la del repositorio
+// encima, y de qué capa sale cada clave
+func layeredConfigFields(repo map[string]any) (map[string]any, map[string]string, error) {
+	fields := map[string]any{}
+	sources := map[string]string{}
+	overlay := func(layer map[string]any, source string) {
+		for k, v := range layer {
+			fields[k] = v
+			sources[k] = source
+		}
+	}
+	
+	data, err := json.Marshal(defaultConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+	defaults := map[string]any{}
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return nil, nil, err
+	}
+	overlay(defaults, "default")
+	
+	if p := globalConfigPath(); p != "" && fileExists(p) {
+		global := map[string]any{}
+		if err := readJSON(p, &global); err != nil {
+			return nil, nil, fmt.Errorf("configuración global %s: %v", p, err)
 		}
-		return config, nil
+		overlay(global, "global")
 	}
 	
+	overlay(repo, "repo")
+	return fields, sources, nil
+}
+
+// Configuración que resulta de las capas default, global y repo, sin
+// variables de entorno ni -c. Es la que se valida al cambiar config.json.
+func repoConfigWith(repo map[string]any) (Config, error) {
+	fields, _, err := layeredConfigFields(repo)
+	if err != nil {
+		return Config{}, err
+	}
+	return decodeConfigFields(fields)
+}
+
+func decodeConfigFields(fields map[string]any) (Config, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return Config{}, err
+	}
 	var config Config
-	if err := readJSON(configPath, &config); err != nil {
+	if err := json.Unmarshal(data, &config); err != nil {
 		return Config{}, err
 	}
 	return config, nil
 }
 
+// Interpreta un valor de texto según el tipo JSON del valor actual
+func parseConfigValue(current any, raw string) (any, error) {
+	switch current.(type) {
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("se esperaba true o false")
+		}
+		return b, nil
+	case float64:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("se esperaba un número entero")
+		}
+		return n, nil
+	case string:
+		return raw, nil
+	case []any:
+		items := []any{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("tipo no admitido")
+}
+
+// Claves de .snapgo/config.json. Solo guarda las que se han fijado en
+// este repositorio; las demás salen de la configuración global o de los
+// valores por defecto. Se crea si no existe.
+func loadRepoConfig(root string) (map[string]any, error) {
+	_, _, _, configPath, _, _ := repoPaths(root)
+	
+	fields := map[string]any{}
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		fields["version"] = defaultConfig().Version
+		return fields, writeJSON(configPath, fields)
+	}
+	
+	if err := readJSON(configPath, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
 func loadIgnore(root string) ([]string, error) {
 	_, _, _, _, ignorePath, _ := repoPaths(root)
 	
@@ -432,6 +1278,12 @@ func loadIgnore(root string) ([]string, error) {
 			if l == "" || strings.HasPrefix(l, "#") {
 				continue
 			}
+			if strings.HasPrefix(l, sizeRulePrefix) {
+				if _, err := parseSizeRule(l); err != nil {
+					fmt.Fprintf(os.Stderr, "⚠️  .snapgoignore: %v\n", err)
+					continue
+				}
+			}
 			lines = append(lines, l)
 		}
 	}
@@ -441,19 +1293,182 @@ func loadIgnore(root string) ([]string, error) {
 		lines = append(lines, config.AutoIgnore...)
 	}
 	
+	attrs, err := loadAttributes(root)
+	if err != nil {
+		return nil, err
+	}
+	lines = append(lines, attrs.skipPatterns()...)
+	
 	// Asegurar que .snapgo/ siempre esté ignorado
 	lines = append(lines, ".snapgo/")
 	
 	return lines, nil
 }
 
+// Atributos por patrón de .snapgoattributes, una regla por línea:
+//
+//	*.jpg    nocompress
+//	*.csv    text
+//	data/**  binary
+//	*.iso    lfs-skip
+//
+// binary: el diff nunca muestra su contenido; text: se compara como texto
+// aunque tenga bytes nulos; nocompress: se guarda sin comprimir;
+// lfs-skip: no se guarda en los snapshots (como si estuviera ignorado).
+// Si varias reglas casan con un archivo, la última decide entre binary y text.
+const attributesFile = ".snapgoattributes"
+
+var knownAttributes = map[string]bool{"binary": true, "text": true, "nocompress": true, "lfs-skip": true}
+
+// Avisos ya mostrados: el archivo se lee varias veces en un mismo comando
+var attributeWarnings = map[string]bool{}
+
+type attrRule struct {
+	pattern string
+	attrs   []string
+}
+
+type fileAttrs []attrRule
+
+// Atributos que resultan para una ruta tras aplicar todas las reglas
+type attrSet struct {
+	Binary     bool
+	Text       bool
+	NoCompress bool
+	LFSSkip    bool
+}
+
+func loadAttributes(root string) (fileAttrs, error) {
+	data, err := os.ReadFile(filepath.Join(root, attributesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	var rules fileAttrs
+	for n, l := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule := attrRule{pattern: fields[0]}
+		for _, a := range fields[1:] {
+			if !knownAttributes[a] {
+				warning := fmt.Sprintf("%s:%d: atributo desconocido '%s'", attributesFile, n+1, a)
+				if !attributeWarnings[warning] {
+					attributeWarnings[warning] = true
+					fmt.Fprintf(os.Stderr, "⚠️  %s\n", warning)
+				}
+				continue
+			}
+			rule.attrs = append(rule.attrs, a)
+		}
+		if len(rule.attrs) > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (fa fileAttrs) lookup(path string) attrSet {
+	var set attrSet
+	for _, r := range fa {
+		if matched, _ := matchIgnore(path, []string{r.pattern}); !matched {
+			continue
+		}
+		for _, a := range r.attrs {
+			switch a {
+			case "binary":
+				set.Binary, set.Text = true, false
+			case "text":
+				set.Text, set.Binary = true, false
+			case "nocompress":
+				set.NoCompress = true
+			case "lfs-skip":
+				set.LFSSkip = true
+			}
+		}
+	}
+	return set
+}
+
+// Patrones con lfs-skip, que se tratan como reglas de .snapgoignore
+func (fa fileAttrs) skipPatterns() []string {
+	var out []string
+	for _, r := range fa {
+		if slices.Contains(r.attrs, "lfs-skip") {
+			out = append(out, r.pattern)
+		}
+	}
+	return out
+}
+
+// Si un archivo se trata como binario: lo dicen sus atributos o, si no
+// tiene binary ni text, su contenido
+func (fa fileAttrs) binary(path string, contents ...[]byte) bool {
+	set := fa.lookup(path)
+	if set.Binary || set.Text {
+		return set.Binary
+	}
+	for _, data := range contents {
+		if isBinary(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Quita los patrones que ignoran el directorio .git/ de la raíz. .snapgo/
+// no se toca: sigue ignorado siempre.
+func withoutGitDir(patterns []string) []string {
+	out := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		name := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(p, "/"), "**"), "/")
+		if name == ".git" {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// Avisa de cuánto ocupa lo que aporta .git/ al snapshot (con --include-gitdir)
+func warnGitDirSize(root string, files []string) {
+	count := 0
+	var total int64
+	for _, rel := range files {
+		if !strings.HasPrefix(rel, ".git/") {
+			continue
+		}
+		count++
+		if info, err := os.Lstat(filepath.Join(root, rel)); err == nil {
+			total += info.Size()
+		}
+	}
+	if count == 0 {
+		fmt.Fprintln(os.Stderr, "ℹ️  --include-gitdir: no hay directorio .git/ que incluir")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "⚠️  --include-gitdir: .git/ añade %d archivo%s (%s) al snapshot\n", count, plural(count), formatSize(total))
+}
+
 // Mejorar función isIgnored
 func isIgnored(path string, patterns []string) bool {
+	matched, _ := matchIgnore(path, patterns)
+	return matched
+}
+
+// Como isIgnored, pero devuelve también el patrón del ignore que excluye la
+// ruta, para diagnósticos como 'status --ignored'
+func matchIgnore(path string, patterns []string) (matched bool, pattern string) {
 	path = filepath.ToSlash(path)
 	
-	for _, p := range patterns {
-		p = strings.TrimSpace(p)
-		if p == "" {
+	for _, raw := range patterns {
+		p := strings.TrimSpace(raw)
+		pattern = p
+		if p == "" || strings.HasPrefix(p, sizeRulePrefix) {
 			continue
 		}
 		
@@ -463,13 +1478,13 @@ func isIgnored(path string, patterns []string) bool {
 		if strings.HasSuffix(p, "/") {
 			// Para directorios, verificar si el path comienza con el patrón
 			if strings.HasPrefix(path, p) {
-				return true
+				return true, pattern
 			}
 			// También verificar si algún componente del path coincide
 			pathParts := strings.Split(path, "/")
 			for _, part := range pathParts {
 				if part+"/" == p {
-					return true
+					return true, pattern
 				}
 			}
 			continue
@@ -480,499 +1495,3541 @@ func isIgnored(path string, patterns []string) bool {
 			// Intentar coincidencia con el nombre del archivo
 			matched, _ := filepath.Match(p, filepath.Base(path))
 			if matched {
-				return true
+				return true, pattern
 			}
 			// Intentar coincidencia con todo el path
 			matched, _ = filepath.Match(p, path)
 			if matched {
-				return true
+				return true, pattern
 			}
 			continue
 		}
 		
 		// Coincidencia exacta del nombre del archivo
 		if filepath.Base(path) == p {
-			return true
+			return true, pattern
 		}
 		
 		// Coincidencia de sufijo (como .exe)
 		if strings.HasPrefix(p, "*") {
 			if strings.HasSuffix(path, p[1:]) {
-				return true
+				return true, pattern
 			}
 		}
 		
 		// Verificar si el path termina con el patrón
 		if strings.HasSuffix(path, p) {
-			return true
+			return true, pattern
 		}
 	}
 	
-	return false
+	return false, ""
 }
 
-func collectFiles(root string, ignores []string) ([]string, error) {
-	files := []string{}
-	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		
-		rel, _ := filepath.Rel(root, path)
-		if rel == "." {
-			return nil
+// Directiva de .snapgoignore que ignora por tamaño, p. ej. "size:>50MB" o
+// "size:>50MB assets/" para aplicarla solo a las rutas que casen con el patrón
+const sizeRulePrefix = "size:"
+
+type sizeRule struct {
+	Greater bool
+	Limit   int64
+	Scope   string
+}
+
+func parseSizeRule(line string) (sizeRule, error) {
+	fields := strings.Fields(strings.TrimPrefix(line, sizeRulePrefix))
+	if len(fields) == 0 || len(fields) > 2 {
+		return sizeRule{}, fmt.Errorf("directiva de tamaño inválida: %q", line)
+	}
+	
+	rule := sizeRule{}
+	expr := fields[0]
+	switch {
+	case strings.HasPrefix(expr, ">"):
+		rule.Greater = true
+	case strings.HasPrefix(expr, "<"):
+	default:
+		return sizeRule{}, fmt.Errorf("directiva de tamaño inválida: %q (usa > o <)", line)
+	}
+	
+	limit, err := parseSize(expr[1:])
+	if err != nil {
+		return sizeRule{}, fmt.Errorf("directiva de tamaño inválida: %q: %v", line, err)
+	}
+	rule.Limit = limit
+	if len(fields) == 2 {
+		rule.Scope = fields[1]
+	}
+	return rule, nil
+}
+
+// Convierte "50MB", "512KB", "1GB" o "100" (bytes) en bytes
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			mult = u.mult
+			s = strings.TrimSuffix(s, u.suffix)
+			break
 		}
-		
-		relUnix := filepath.ToSlash(rel)
-		
-		// Ignorar .snapgo/ explícitamente
-		if strings.HasPrefix(relUnix, ".snapgo/") || relUnix == ".snapgo" {
-			if d.IsDir() {
-				return filepath.SkipDir
+	}
+	
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("tamaño inválido")
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// matchGlob compara una ruta con barras '/' contra un patrón donde '**'
+// equivale a cualquier número de directorios (incluido ninguno). Un patrón
+// sin '/' se compara solo con el nombre del archivo, como en .snapgoignore.
+func matchGlob(pattern, path string) bool {
+	pattern = filepath.ToSlash(pattern)
+	path = filepath.ToSlash(path)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := filepath.Match(pattern, filepath.Base(path))
+		return ok
+	}
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func matchGlobParts(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchGlobParts(pattern[1:], parts[i:]) {
+					return true
+				}
 			}
-			return nil
+			return false
 		}
-		
-		if isIgnored(relUnix, ignores) {
-			if d.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
+		if len(parts) == 0 {
+			return false
 		}
-		
-		if !d.IsDir() {
-			files = append(files, relUnix)
+		if ok, _ := filepath.Match(pattern[0], parts[0]); !ok {
+			return false
 		}
-		return nil
-	})
-	
-	sort.Strings(files)
-	return files, err
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
 }
 
-// Nueva versión de snapshotCmd que acepta directorio raíz
-func snapshotCmdWithRoot(rootDir string) {
-	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
-	msg := fs.String("m", "", "mensaje del snapshot")
-	fs.Parse(os.Args[2:])
-	
-	if *msg == "" {
-		fmt.Println("Uso: snapshot -m \"mensaje descriptivo\"")
-		return
+// Indica si el archivo queda excluido por alguna directiva size: del ignore
+func isIgnoredBySize(path string, size int64, patterns []string) bool {
+	matched, _ := matchSizeRule(path, size, patterns)
+	return matched
+}
+
+// Como isIgnoredBySize, devolviendo también la directiva size: responsable
+func matchSizeRule(path string, size int64, patterns []string) (matched bool, rule string) {
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, sizeRulePrefix) {
+			continue
+		}
+		rule, err := parseSizeRule(p)
+		if err != nil {
+			continue
+		}
+		if rule.Scope != "" && !isIgnored(path, []string{rule.Scope}) {
+			continue
+		}
+		if (rule.Greater && size > rule.Limit) || (!rule.Greater && size < rule.Limit) {
+			return true, p
+		}
 	}
-	
-	must(snapshot(rootDir, *msg))
+	return false, ""
 }
 
-func snapshot(root, message string) error {
-	snapgoDir, snapsDir, indexPath, _, _, _ := repoPaths(root)
-	if _, err := os.Stat(snapgoDir); os.IsNotExist(err) {
-		if err := initRepo(root); err != nil {
-			return err
+func hasSizeRules(patterns []string) bool {
+	for _, p := range patterns {
+		if strings.HasPrefix(p, sizeRulePrefix) {
+			return true
 		}
 	}
+	return false
+}
+
+// Límites por defecto de archivos por snapshot. Protegen de un 'snapgo init'
+// accidental en $HOME o en la raíz del disco. 0 significa sin límite.
+const (
+	defaultMaxFileCount  = 100000
+	defaultWarnFileCount = 20000
+)
+
+func collectFiles(root string, ignores []string) ([]string, error) {
+	return collectFilesLimited(root, ignores, 0)
+}
+
+// Igual que collectFiles, pero aborta el recorrido en cuanto se superan
+// maxFiles archivos (0 = sin límite)
+func collectFilesLimited(root string, ignores []string, maxFiles int) ([]string, error) {
+	return collectFilesWith(context.Background(), root, ignores, maxFiles, false)
+}
+
+// Repositorios anidados ya avisados: un mismo comando puede recorrer el
+// árbol varias veces
+var nestedRepoWarnings = map[string]bool{}
+
+// Recorrido común de collectFiles. Por defecto los enlaces simbólicos se
+// recogen como archivos (y se guardan como enlaces); con follow se entra en
+// los directorios enlazados, una sola vez por directorio real para evitar
+// bucles.
+func collectFilesWith(ctx context.Context, root string, ignores []string, maxFiles int, follow bool) ([]string, error) {
+	files := []string{}
+	checkSize := hasSizeRules(ignores)
+	visited := map[string]bool{}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		visited[real] = true
+	}
 	
-	ignores, err := loadIgnore(root)
-	if err != nil {
-		return err
+	var walk func(base, prefix string) error
+	walkFn := func(base, prefix string) func(string, os.DirEntry, error) error {
+		return func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := interrupted(ctx, "recorrido detenido tras encontrar %d archivos", len(files)); err != nil {
+				return err
+			}
+			
+			rel, _ := filepath.Rel(base, path)
+			if rel == "." {
+				return nil
+			}
+			rel = filepath.Join(prefix, rel)
+			
+			relUnix := filepath.ToSlash(rel)
+			
+			// Ignorar .snapgo/ explícitamente, también el de repos anidados
+			if d.Name() == ".snapgo" {
+				if nested := filepath.ToSlash(filepath.Dir(rel)); relUnix != ".snapgo" && !nestedRepoWarnings[nested] {
+					nestedRepoWarnings[nested] = true
+					fmt.Fprintf(os.Stderr, "⚠️  Se omite el repositorio SnapGo anidado en %s/\n", nested)
+				}
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			
+			if isIgnored(relUnix, ignores) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			
+			if follow && d.Type()&os.ModeSymlink != 0 {
+				if info, err := os.Stat(path); err == nil && info.IsDir() {
+					real, err := filepath.EvalSymlinks(path)
+					if err != nil {
+						return err
+					}
+					if visited[real] {
+						fmt.Fprintf(os.Stderr, "⚠️  Se omite el enlace %s: apunta a un directorio ya recorrido (posible bucle)\n", relUnix)
+						return nil
+					}
+					visited[real] = true
+					// La barra final hace que WalkDir entre en el enlace
+					return walk(path+string(filepath.Separator), rel)
+				}
+			}
+			
+			if d.IsDir() && follow {
+				if real, err := filepath.EvalSymlinks(path); err == nil {
+					visited[real] = true
+				}
+			}
+			
+			if !d.IsDir() {
+				if checkSize {
+					info, err := os.Stat(path)
+					if err != nil {
+						info, err = d.Info()
+					}
+					if err != nil {
+						return err
+					}
+					if isIgnoredBySize(relUnix, info.Size(), ignores) {
+						return nil
+					}
+				}
+				files = append(files, relUnix)
+				if maxFiles > 0 && len(files) > maxFiles {
+					return fmt.Errorf("demasiados archivos (más de %d): ¿inicializaste SnapGo en el directorio equivocado?\n"+
+						"   Añade los directorios grandes a .snapgoignore, sube max_file_count o usa --force", maxFiles)
+				}
+			}
+			return nil
+		}
+	}
+	walk = func(base, prefix string) error {
+		return filepath.WalkDir(base, walkFn(base, prefix))
 	}
 	
-	files, err := collectFiles(root, ignores)
-	if err != nil {
-		return err
+	if err := walk(root, ""); err != nil {
+		return nil, err
 	}
 	
-	if len(files) == 0 {
-		return fmt.Errorf("no hay archivos para snapshot")
+	if config, err := loadConfig(root); err == nil && config.RespectGitStatus != "" {
+		files = filterByGit(root, files, config.RespectGitStatus)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Modos de respect_git_status
+const (
+	gitRespectIgnored = "ignored" // Excluir lo que git ignora
+	gitRespectTracked = "tracked" // Solo archivos versionados en git
+)
+
+// Filtra files según git. Si git no está instalado o root no es un
+// repositorio git, devuelve la lista sin cambios.
+func filterByGit(root string, files []string, mode string) []string {
+	if _, err := lookupTool("git"); err != nil {
+		return files
+	}
+	if err := exec.Command("git", "-C", root, "rev-parse", "--is-inside-work-tree").Run(); err != nil {
+		return files
+	}
+	
+	var out []byte
+	var err error
+	switch mode {
+	case gitRespectIgnored:
+		cmd := exec.Command("git", "-C", root, "check-ignore", "-z", "--stdin")
+		cmd.Stdin = strings.NewReader(strings.Join(files, "\x00") + "\x00")
+		out, err = cmd.Output()
+		// check-ignore sale con 1 si ningún archivo está ignorado
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return files
+		}
+	case gitRespectTracked:
+		out, err = exec.Command("git", "-C", root, "ls-files", "-z", "--cached").Output()
+	default:
+		fmt.Fprintf(os.Stderr, "⚠️  respect_git_status desconocido '%s' (usa %s o %s)\n", mode, gitRespectIgnored, gitRespectTracked)
+		return files
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  No se pudo consultar git: %v\n", err)
+		return files
 	}
 	
-	h := sha256.New()
+	return applyGitList(files, parseNulList(out), mode == gitRespectTracked)
+}
+
+// Lista separada por NUL de la salida de git
+func parseNulList(out []byte) map[string]bool {
+	set := map[string]bool{}
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p != "" {
+			set[p] = true
+		}
+	}
+	return set
+}
+
+// Conserva los archivos presentes en listed (keep) o los ausentes (!keep)
+func applyGitList(files []string, listed map[string]bool, keep bool) []string {
+	kept := make([]string, 0, len(files))
 	for _, f := range files {
-		data, err := os.ReadFile(filepath.Join(root, f))
+		if listed[f] == keep {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// Nueva versión de snapshotCmd que acepta directorio raíz
+func snapshotCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	msg := fs.String("m", "", "mensaje del snapshot")
+	nice := fs.Int("nice", 0, "limitar la E/S a N MB/s (0 = usar configuración)")
+	sign := fs.Bool("sign", false, "firmar el snapshot con GPG")
+	force := fs.Bool("force", false, "ignorar el límite max_file_count")
+	fromList := fs.String("from-list", "", "snapshot exacto de las rutas listadas en un archivo (- = stdin)")
+	followSymlinks := fs.Bool("follow-symlinks", false, "guardar el contenido de los enlaces simbólicos en vez del enlace")
+	verbose := fs.Bool("v", false, "mostrar estadísticas de compresión")
+	empty := fs.Bool("empty", false, "crear un snapshot vacío, sin archivos")
+	dedupeCheck := fs.Bool("dedupe-check", false, "avisar de archivos grandes duplicados")
+	parallelRead := fs.Int("parallel-read", 0, "leer N archivos en paralelo (0 = usar configuración)")
+	retain := fs.Bool("retain", false, "proteger el snapshot: la limpieza nunca lo borra")
+	fs.BoolVar(retain, "protect", false, "alias de --retain")
+	includeGitDir := fs.Bool("include-gitdir", false, "incluir el directorio .git/ en este snapshot")
+	timeout := fs.Duration("timeout", 0, "abortar el snapshot si tarda más (p. ej. 5m; 0 = usar configuración)")
+	asJSON := fs.Bool("json", false, "imprimir solo los metadatos del snapshot creado, en JSON")
+	since := fs.String("since-snapshot", "", "incremental: guardar solo los archivos cambiados desde este snapshot")
+	fromGit := fs.Bool("message-from-git", false, "usar como mensaje el del último commit de git (-m tiene prioridad)")
+	parseFlags(fs, os.Args[2:])
+	
+	if *msg == "" && *fromGit {
+		m, err := gitLastCommitMessage(rootDir)
 		if err != nil {
-			return err
+			// Un script (o --json) debe distinguir este fallo de un snapshot creado
+			fmt.Fprintln(os.Stderr, "💡 Usa: snapshot -m \"mensaje descriptivo\"")
+			must(fmt.Errorf("--message-from-git: %v", err))
 		}
-		h.Write([]byte(f))
-		h.Write(data)
+		*msg = m
 	}
-	sum := hex.EncodeToString(h.Sum(nil))[:12]
-	
-	id := time.Now().Format("20060102-150405") + "-" + sum
-	archivePath := filepath.Join(snapsDir, id+".tar.gz")
 	
-	config, _ := loadConfig(root)
-	if err := writeTarGz(root, archivePath, files, config.Compression); err != nil {
-		return err
+	if *msg == "" {
+		// Sin -m, intentar escribir el mensaje en $EDITOR
+		m, err := messageFromEditor(editorTemplate(rootDir))
+		if err != nil {
+			fmt.Printf("⚠️  %v\n", err)
+			fmt.Println("Uso: snapshot -m \"mensaje descriptivo\" [--nice <MB/s>]")
+			return
+		}
+		*msg = m
 	}
 	
-	var idx Index
-	if err := readJSON(indexPath, &idx); err != nil {
-		return err
+	opts := SnapshotOptions{
+		ThrottleMBps: *nice,
+		Sign:         *sign,
+		Force:        *force,
+		FromList:     *fromList,
+		
+		FollowSymlinks: *followSymlinks,
+		Verbose:        *verbose,
+		Empty:          *empty,
+		DedupeCheck:    *dedupeCheck,
+		IncludeStaged:  true,
+		Protect:        *retain,
+		IncludeGitDir:  *includeGitDir,
+		SinceSnapshot:  *since,
+		
+		ReadConcurrency: *parallelRead,
+		Timeout:         *timeout,
 	}
-	
-	meta := SnapshotMeta{
-		ID:        id,
-		Timestamp: time.Now().Format(time.RFC3339),
-		Message:   message,
-		Hash:      sum,
-		FileCount: len(files),
-		Files:     files,
+	if opts.Empty && opts.FromList != "" {
+		fmt.Println("Uso: --empty y --from-list no se pueden combinar")
+		return
 	}
-	
-	idx.Snapshots = append(idx.Snapshots, meta)
-	
-	config, _ = loadConfig(root)
-	if config.MaxSnapshots > 0 && len(idx.Snapshots) > config.MaxSnapshots {
-		oldest := idx.Snapshots[0]
-		idx.Snapshots = idx.Snapshots[1:]
-		
-		oldPath := filepath.Join(snapsDir, oldest.ID+".tar.gz")
-		os.Remove(oldPath)
+	if opts.Empty && opts.SinceSnapshot != "" {
+		fmt.Println("Uso: --empty y --since-snapshot no se pueden combinar")
+		return
 	}
-	
-	if err := writeJSON(indexPath, idx); err != nil {
-		return err
+	if *asJSON {
+		must(snapshotJSONOutput(rootDir, *msg, opts))
+		return
 	}
-	
-	fmt.Printf("✅ Snapshot creado: %s\n", id)
-	fmt.Printf("   📝 Mensaje: %s\n", message)
-	fmt.Printf("   📁 Archivos: %d\n", len(files))
-	
-	return nil
+	must(snapshotWithOptions(rootDir, *msg, opts))
 }
 
-func writeTarGz(root, out string, files []string, compression int) error {
-	f, err := os.Create(out)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	
-	gw, err := gzip.NewWriterLevel(f, compression)
-	if err != nil {
-		return err
-	}
-	defer gw.Close()
-	
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
-	
+// Tamaño mínimo de los archivos que revisa --dedupe-check
+const dedupeMinSize = 1 << 20
+
+// Archivos con el mismo contenido
+type duplicateGroup struct {
+	Size  int64
+	Files []string
+}
+
+// Agrupa los archivos de al menos minSize bytes con contenido idéntico.
+// Solo se calcula el hash de los que comparten tamaño con otro archivo.
+func findDuplicates(root string, files []string, follow bool, minSize int64) []duplicateGroup {
+	bySize := make(map[int64][]string)
 	for _, rel := range files {
 		full := filepath.Join(root, rel)
-		info, err := os.Stat(full)
-		if err != nil {
-			return err
+		info, err := os.Lstat(full)
+		if err == nil && follow && info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(full)
 		}
-		
-		hdr, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
+		if err != nil || !info.Mode().IsRegular() || info.Size() < minSize {
+			continue
 		}
-		
-		hdr.Name = rel
-		if err := tw.WriteHeader(hdr); err != nil {
-			return err
+		bySize[info.Size()] = append(bySize[info.Size()], rel)
+	}
+	
+	groups := []duplicateGroup{}
+	for size, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
 		}
-		
-		file, err := os.Open(full)
-		if err != nil {
-			return err
+		byHash := make(map[string][]string)
+		for _, rel := range candidates {
+			if sum, err := fileSHA256(filepath.Join(root, rel)); err == nil {
+				byHash[sum] = append(byHash[sum], rel)
+			}
 		}
-		
-		if _, err := io.Copy(tw, file); err != nil {
-			file.Close()
-			return err
+		for _, same := range byHash {
+			if len(same) > 1 {
+				sort.Strings(same)
+				groups = append(groups, duplicateGroup{Size: size, Files: same})
+			}
 		}
-		file.Close()
 	}
 	
-	return nil
+	// Primero los que más espacio desperdician
+	sort.Slice(groups, func(i, j int) bool {
+		wi := groups[i].Size * int64(len(groups[i].Files)-1)
+		wj := groups[j].Size * int64(len(groups[j].Files)-1)
+		if wi != wj {
+			return wi > wj
+		}
+		return groups[i].Files[0] < groups[j].Files[0]
+	})
+	return groups
 }
 
-func listSnapshots(root string) error {
-	_, _, indexPath, _, _, _ := repoPaths(root)
-	
-	var idx Index
-	if err := readJSON(indexPath, &idx); err != nil {
-		// Mostrar error específico
-		fmt.Printf("❌ No se pudo leer el índice en: %s\n", indexPath)
-		fmt.Println("   ¿Estás en el directorio correcto?")
-		fmt.Println("   Usa 'snapgo init' para crear un repositorio")
-		return err
-	}
-	
-	if len(idx.Snapshots) == 0 {
-		fmt.Println("📭 No hay snapshots todavía.")
-		fmt.Println("💡 Usa 'snapgo snapshot -m \"mensaje\"' para crear el primero.")
-		return nil
+func reportDuplicates(groups []duplicateGroup) {
+	if len(groups) == 0 {
+		logf("✅ Sin archivos duplicados de más de %s\n", formatSize(dedupeMinSize))
+		return
 	}
 	
-	fmt.Printf("📦 Snapshots disponibles (en %s):\n", root)
-	for i, s := range idx.Snapshots {
-		t, _ := time.Parse(time.RFC3339, s.Timestamp)
-		timeStr := t.Format("02/01 15:04")
-		
-		prefix := "   "
-		if i == len(idx.Snapshots)-1 {
-			prefix = "🟢 "
+	var wasted int64
+	fmt.Fprintf(os.Stderr, "⚠️  %d grupo(s) de archivos idénticos:\n", len(groups))
+	for _, g := range groups {
+		wasted += g.Size * int64(len(g.Files)-1)
+		fmt.Fprintf(os.Stderr, "   • %d × %s:\n", len(g.Files), formatSize(g.Size))
+		for _, f := range g.Files {
+			fmt.Fprintf(os.Stderr, "       %s\n", f)
 		}
-		
-		fmt.Printf("%s%s  %s  %d archivos\n", prefix, s.ID, timeStr, s.FileCount)
-		fmt.Printf("      \"%s\"\n", s.Message)
 	}
-	
-	return nil
+	fmt.Fprintf(os.Stderr, "   💾 Espacio duplicado: %s\n", formatSize(wasted))
+	fmt.Fprintln(os.Stderr, "💡 Añádelos a .snapgoignore o sustitúyelos por enlaces simbólicos")
 }
 
-func showSnapshot(root, id string) error {
-	id = resolveSpecialID(root, id)
-	
+// Dos snapshots con el mismo contenido en el mismo segundo tendrían el mismo
+// ID; se desambiguan con un sufijo -2, -3... como en 'import --rename'
+func uniqueSnapshotID(root, id string) string {
 	_, _, indexPath, _, _, _ := repoPaths(root)
-	
+	taken := map[string]bool{}
 	var idx Index
-	if err := readJSON(indexPath, &idx); err != nil {
-		return err
-	}
-	
-	for _, s := range idx.Snapshots {
-		if s.ID == id {
-			fmt.Println("📊 Detalles del Snapshot")
-			fmt.Println("══════════════════════════════════════════")
-			fmt.Printf("🆔 ID:        %s\n", s.ID)
-			
-			t, _ := time.Parse(time.RFC3339, s.Timestamp)
-			fmt.Printf("📅 Fecha:     %s\n", t.Format("02/01/2006 15:04:05"))
-			fmt.Printf("🔒 Hash:      %s\n", s.Hash)
-			fmt.Printf("📁 Archivos:  %d\n", s.FileCount)
-			fmt.Printf("📝 Mensaje:   %s\n", s.Message)
-			
-			if len(s.Files) > 0 {
-				fmt.Println("\n📄 Archivos incluidos:")
-				for _, f := range s.Files {
-					fmt.Printf("   • %s\n", f)
-				}
-			}
-			
-			return nil
+	if readJSON(indexPath, &idx) == nil {
+		for _, s := range idx.Snapshots {
+			taken[s.ID] = true
 		}
 	}
 	
-	return fmt.Errorf("snapshot '%s' no encontrado", id)
+	candidate := id
+	for k := 2; taken[candidate] || fileExists(snapshotArchive(root, candidate)); k++ {
+		candidate = fmt.Sprintf("%s-%d", id, k)
+	}
+	return candidate
 }
 
-// Nueva versión de restoreCmd que acepta directorio raíz
-func restoreCmdWithRoot(rootDir string) {
-	fs := flag.NewFlagSet("restore", flag.ExitOnError)
-	force := fs.Bool("force", false, "sobrescribir directorio actual")
-	fs.Parse(os.Args[2:])
+var errNoFiles = fmt.Errorf("no hay archivos para snapshot (usa --empty para un snapshot vacío)")
+
+func snapshot(root, message string) error {
+	return snapshotWithOptions(root, message, SnapshotOptions{})
+}
+
+func snapshotWithOptions(root, message string, opts SnapshotOptions) error {
+	_, err := createSnapshot(root, message, opts)
+	return err
+}
+
+// Salida de 'snapshot --json'
+type snapshotJSON struct {
+	SnapshotMeta
+	ArchiveSize      int64 `json:"archive_size"`
+	CompressionLevel int   `json:"compression_level"`
+}
+
+// Crea el snapshot e imprime en stdout solo sus metadatos en JSON. Los
+// mensajes de progreso se callan y los avisos van siempre a stderr, así que
+// no rompen el JSON.
+func snapshotJSONOutput(root, message string, opts SnapshotOptions) error {
+	wasQuiet := quiet
+	quiet = true
+	meta, err := createSnapshot(root, message, opts)
+	quiet = wasQuiet
+	if err != nil {
+		return err
+	}
 	
-	if fs.NArg() < 1 {
-		fmt.Println("Uso: restore <id> [--force]")
-		return
+	out := snapshotJSON{SnapshotMeta: meta}
+	if info, err := os.Stat(snapshotArchive(root, meta.ID)); err == nil {
+		out.ArchiveSize = info.Size()
+	}
+	if config, err := loadConfig(root); err == nil {
+		out.CompressionLevel = config.Compression
 	}
 	
-	id := fs.Arg(0)
-	must(restore(rootDir, id, *force))
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
 }
 
-func restore(root, id string, force bool) error {
-	id = resolveSpecialID(root, id)
+// Crea el snapshot y devuelve sus metadatos tal como quedan en el índice
+func createSnapshot(root, message string, opts SnapshotOptions) (SnapshotMeta, error) {
+	snapgoDir, snapsDir, indexPath, _, _, _ := repoPaths(root)
+	if _, err := os.Stat(snapgoDir); os.IsNotExist(err) {
+		if err := initRepo(root); err != nil {
+			return SnapshotMeta{}, err
+		}
+	}
+	if err := requireWorkingTree(root, "snapshot"); err != nil {
+		return SnapshotMeta{}, err
+	}
 	
-	_, snapsDir, _, _, _, _ := repoPaths(root)
+	ignores, err := loadIgnore(root)
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+	if opts.IncludeGitDir {
+		ignores = withoutGitDir(ignores)
+	}
+	attrs, err := loadAttributes(root)
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+	var base *SnapshotMeta
+	if opts.SinceSnapshot != "" {
+		if base, err = incrementalBase(root, opts.SinceSnapshot); err != nil {
+			return SnapshotMeta{}, err
+		}
+	}
 	
-	archive := filepath.Join(snapsDir, id+".tar.gz")
-	if _, err := os.Stat(archive); os.IsNotExist(err) {
-		return fmt.Errorf("snapshot '%s' no encontrado", id)
+	config, _ := loadConfig(root)
+	maxFiles := config.MaxFileCount
+	if opts.Force {
+		maxFiles = 0
 	}
 	
-	if force {
-		backupID := fmt.Sprintf("backup_pre_restore_%s", time.Now().Format("20060102_150405"))
-		fmt.Printf("💾 Creando backup automático: %s\n", backupID)
-		
-		if err := snapshot(root, fmt.Sprintf("Backup antes de restaurar %s", id)); err != nil {
-			return fmt.Errorf("error creando backup: %v", err)
-		}
-		
-		if err := moveCurrentFilesToTrash(root, "pre_restore"); err != nil {
-			fmt.Printf("⚠️  No se pudieron mover archivos a papelera: %v\n", err)
-		}
+	ctx := context.Background()
+	timeout := opts.Timeout
+	if timeout == 0 && config.SnapshotTimeout != "" {
+		timeout, _ = time.ParseDuration(config.SnapshotTimeout)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w (%v)", errSnapshotTimeout, timeout))
+		defer cancel()
 	}
 	
-	target := root
-	if !force {
-		target = filepath.Join(root, "_restore_"+id)
-		if err := os.MkdirAll(target, 0o755); err != nil {
-			return err
-		}
+	follow := opts.FollowSymlinks || config.FollowSymlinks
+	
+	files := []string{}
+	switch {
+	case opts.Empty:
+		// Snapshot base sin archivos
+	case opts.FromList != "":
+		files, err = readFileList(root, opts.FromList)
+	default:
+		files, err = collectFilesWith(ctx, root, ignores, maxFiles, follow)
+	}
+	if err != nil {
+		return SnapshotMeta{}, err
 	}
 	
-	if err := extractTarGz(archive, target); err != nil {
-		return err
+	var staged stagedFiles
+	if opts.IncludeStaged && !opts.Empty {
+		if staged, err = loadStaged(root); err != nil {
+			return SnapshotMeta{}, err
+		}
+		files = staged.merge(files)
 	}
 	
-	if force {
-		fmt.Printf("✅ Snapshot '%s' restaurado en directorio actual\n", id)
-		fmt.Println("   📝 Nota: Se creó un backup automático antes de la restauración")
-		fmt.Println("   🗑️  Los archivos anteriores fueron movidos a la papelera (.snapgo/trash)")
-	} else {
-		fmt.Printf("✅ Snapshot '%s' restaurado en: %s\n", id, target)
+	if len(files) == 0 && !opts.Empty {
+		return SnapshotMeta{}, errNoFiles
 	}
 	
-	return nil
-}
-
-func moveCurrentFilesToTrash(root, reason string) error {
-	_, _, _, _, _, trashDir := repoPaths(root)
+	if opts.IncludeGitDir {
+		warnGitDirSize(root, files)
+	}
 	
-	config, err := loadConfig(root)
-	if err != nil || !config.EnableTrash {
-		return nil
+	if config.WarnFileCount > 0 && len(files) > config.WarnFileCount {
+		fmt.Fprintf(os.Stderr, "⚠️  El snapshot incluye %d archivos (aviso a partir de %d). Revisa tu .snapgoignore\n",
+			len(files), config.WarnFileCount)
 	}
 	
-	trashSubdir := filepath.Join(trashDir, fmt.Sprintf("%s_%s", 
-		time.Now().Format("20060102_150405"), reason))
+	if opts.DedupeCheck {
+		reportDuplicates(findDuplicates(root, files, follow, dedupeMinSize))
+	}
 	
-	if err := os.MkdirAll(trashSubdir, 0o755); err != nil {
-		return err
+	readers := opts.ReadConcurrency
+	if readers == 0 {
+		readers = config.ReadConcurrency
 	}
 	
-	ignores, _ := loadIgnore(root)
-	currentFiles, err := collectFiles(root, ignores)
+	sum, err := contentHash(ctx, root, files, follow, staged, readers)
 	if err != nil {
-		return err
+		return SnapshotMeta{}, err
 	}
 	
-	movedCount := 0
-	for _, file := range currentFiles {
-		src := filepath.Join(root, file)
-		dst := filepath.Join(trashSubdir, file)
-		
-		dstDir := filepath.Dir(dst)
-		if err := os.MkdirAll(dstDir, 0o755); err != nil {
-			continue
-		}
-		
-		if err := os.Rename(src, dst); err == nil {
-			movedCount++
+	archived, inherited := files, []string(nil)
+	baseID := ""
+	if base != nil {
+		baseID = base.ID
+		if archived, inherited, err = splitInherited(root, baseID, files, staged, follow); err != nil {
+			return SnapshotMeta{}, err
 		}
 	}
 	
-	if movedCount > 0 {
-		fmt.Printf("📦 %d archivos movidos a papelera: %s\n", movedCount, trashSubdir)
+	id := uniqueSnapshotID(root, time.Now().Format("20060102-150405")+"-"+sum)
+	archivePath := archivePathFor(snapsDir, id, config.ArchiveLayout)
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0o755); err != nil {
+		return SnapshotMeta{}, err
 	}
 	
-	return nil
-}
-
-func extractTarGz(archive, target string) error {
-	f, err := os.Open(archive)
-	if err != nil {
-		return err
+	throttle := opts.ThrottleMBps
+	if throttle == 0 {
+		throttle = config.IOThrottleMBps
 	}
-	defer f.Close()
-	
-	gr, err := gzip.NewReader(f)
+	stats, err := writeTarGz(ctx, root, archivePath, archived, writeOptions{
+		Compression:     config.Compression,
+		ThrottleMBps:    throttle,
+		FollowSymlinks:  follow,
+		StoreExtensions: config.SkipCompressedExtensions,
+		Attrs:           attrs,
+		Staged:          staged,
+		ReadConcurrency: readers,
+	})
 	if err != nil {
-		return err
+		// No dejar un archivo a medias sin entrada en el índice
+		os.Remove(archivePath)
+		return SnapshotMeta{}, err
+	}
+	if opts.Verbose {
+		stats.print()
 	}
-	defer gr.Close()
 	
-	tr := tar.NewReader(gr)
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		
-		outPath := filepath.Join(target, hdr.Name)
-		if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
-			return err
-		}
-		
-		out, err := os.Create(outPath)
+	signatureKey := ""
+	if opts.Sign || config.SignSnapshots {
+		fingerprint, err := signArchive(archivePath)
 		if err != nil {
-			return err
-		}
-		
-		if _, err := io.Copy(out, tr); err != nil {
-			out.Close()
-			return err
+			fmt.Fprintf(os.Stderr, "⚠️  Snapshot sin firmar: %v\n", err)
+		} else {
+			signatureKey = fingerprint
 		}
-		out.Close()
 	}
 	
-	return nil
-}
-
-// Nueva versión de diffCmd que acepta directorio raíz
-func diffCmdWithRoot(rootDir string) {
-	if len(os.Args) < 4 {
-		fmt.Println("Uso: diff <id1> <id2>")
-		fmt.Println("Ejemplo: diff HEAD PREV")
-		fmt.Println("Nota: Necesitas al menos 2 snapshots para comparar")
-		return
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return SnapshotMeta{}, err
 	}
 	
-	id1 := os.Args[2]
-	id2 := os.Args[3]
-	must(diffSnapshots(rootDir, id1, id2))
-}
-
-func diffSnapshots(root, id1, id2 string) error {
-	id1 = resolveSpecialID(root, id1)
-	id2 = resolveSpecialID(root, id2)
+	if err := writeJSON(snapshotFilesPath(root, id), SnapshotFiles{ID: id, Files: files, Inherited: inherited}); err != nil {
+		return SnapshotMeta{}, err
+	}
 	
-	if id1 == id2 {
-		fmt.Println("ℹ️  Ambos snapshots son el mismo:")
-		fmt.Printf("   🆔 ID: %s\n", id1)
-		fmt.Println("   📊 Resultado: No hay diferencias")
-		return nil
+	if config.BranchMessagePrefix {
+		message = prefixWithBranch(message, idx.Current, config.PrefixSkipMain)
 	}
 	
-	_, _, indexPath, _, _, _ := repoPaths(root)
+	meta := SnapshotMeta{
+		ID:           id,
+		Timestamp:    time.Now().Format(time.RFC3339),
+		Message:      message,
+		Hash:         sum,
+		FileCount:    len(files),
+		Branch:       idx.Current,
+		Parent:       branchHead(idx, idx.Current),
+		SignatureKey: signatureKey,
+		ExplicitList: opts.FromList != "",
+		Author:       snapshotAuthor(config),
+		Protected:    opts.Protect,
+		Base:         baseID,
+		
+		FollowSymlinks: follow,
+	}
 	
-	var idx Index
-	if err := readJSON(indexPath, &idx); err != nil {
-		return fmt.Errorf("error leyendo índice: %v", err)
+	oldHead := branchHead(idx, idx.Current)
+	idx.Snapshots = append(idx.Snapshots, meta)
+	if idx.Branches == nil {
+		idx.Branches = make(map[string]string)
 	}
+	idx.Branches[idx.Current] = id
 	
-	if len(idx.Snapshots) == 0 {
-		return fmt.Errorf("no hay snapshots disponibles")
+	config, _ = loadConfig(root)
+	if config.MaxSnapshots > 0 && len(idx.Snapshots) > config.MaxSnapshots {
+		pruned := pruneCandidates(idx.Snapshots, config.MaxSnapshots)
+		idx.Snapshots = withoutSnapshots(idx.Snapshots, pruned)
+		
+		// La rotación automática borra siempre: con clean_to_trash cada
+		// snapshot nuevo dejaría otro en una papelera que nunca se vacía
+		for _, s := range pruned {
+			if err := removeSnapshotFiles(root, s.ID); err != nil {
+				fmt.Fprintf(os.Stderr, "⚠️  No se pudo borrar el snapshot rotado %s: %v\n", s.ID, err)
+			}
+		}
 	}
 	
-	if len(idx.Snapshots) == 1 {
-		fmt.Println("ℹ️  Solo hay 1 snapshot disponible:")
-		fmt.Printf("   🆔 ID: %s\n", idx.Snapshots[0].ID)
-		fmt.Printf("   📝 Mensaje: %s\n", idx.Snapshots[0].Message)
-		fmt.Println("   💡 Crea otro snapshot para poder comparar")
-		return nil
+	if err := writeJSON(indexPath, idx); err != nil {
+		return SnapshotMeta{}, err
 	}
+	recordReflog(root, "snapshot: "+strings.SplitN(message, "\n", 2)[0], oldHead, id)
 	
-	var snap1, snap2 *SnapshotMeta
-	for _, s := range idx.Snapshots {
-		if s.ID == id1 {
-			snap1 = &s
-		}
-		if s.ID == id2 {
-			snap2 = &s
+	if len(staged) > 0 {
+		if err := clearStaged(root); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  No se pudo vaciar el área de preparación: %v\n", err)
 		}
 	}
 	
-	if snap1 == nil {
-		return fmt.Errorf("snapshot '%s' no encontrado", id1)
+	logf("✅ Snapshot creado: %s\n", id)
+	logf("   📝 Mensaje: %s\n", message)
+	logf("   📁 Archivos: %d\n", len(files))
+	if baseID != "" {
+		logf("   🧩 Incremental sobre %s: %d guardado%s, %d heredado%s\n",
+			baseID, len(archived), plural(len(archived)), len(inherited), plural(len(inherited)))
 	}
-	if snap2 == nil {
-		return fmt.Errorf("snapshot '%s' no encontrado", id2)
+	if len(staged) > 0 {
+		logf("   📥 Desde add-content: %d\n", len(staged))
+	}
+	if signatureKey != "" {
+		logf("   🔏 Firmado con: %s\n", signatureKey)
 	}
 	
-	var older, newer *SnapshotMeta
-	time1, err1 := time.Parse(time.RFC3339, snap1.Timestamp)
-	time2, err2 := time.Parse(time.RFC3339, snap2.Timestamp)
+	return meta, nil
+}
+
+// Hash de contenido de un snapshot: nombre + datos de cada archivo, en
+// orden. Los archivos se leen en streaming, así que la memoria usada no
+// depende de su tamaño. Sin follow, de un enlace simbólico cuenta su destino.
+func contentHash(ctx context.Context, root string, files []string, follow bool, staged stagedFiles, readers int) (string, error) {
+	sources := make([]string, len(files))
+	for i, rel := range files {
+		sources[i] = staged.source(root, rel)
+	}
+	pf := newPrefetcher(sources, readers)
+	defer pf.close()
 	
-	if err1 != nil || err2 != nil {
+	h := sha256.New()
+	for i, rel := range files {
+		if err := interrupted(ctx, "calculados %d de %d hashes", i, len(files)); err != nil {
+			return "", err
+		}
+		full := sources[i]
+		data, prefetched := pf.get(i)
+		if target, ok := symlinkTarget(full, follow); ok {
+			h.Write([]byte(rel))
+			h.Write([]byte(target))
+			continue
+		}
+		if prefetched {
+			h.Write([]byte(rel))
+			h.Write(data)
+			continue
+		}
+		
+		f, err := os.Open(full)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(rel))
+		_, err = io.Copy(h, ctxReader{ctx, f})
+		f.Close()
+		if ierr := interrupted(ctx, "calculados %d de %d hashes", i, len(files)); ierr != nil {
+			return "", ierr
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+var errSnapshotTimeout = fmt.Errorf("se superó el tiempo límite del snapshot")
+
+// Error con el progreso alcanzado si ctx se canceló (o venció su plazo);
+// nil si sigue vivo
+func interrupted(ctx context.Context, format string, args ...any) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: "+format, append([]any{context.Cause(ctx)}, args...)...)
+}
+
+// Lector que deja de leer en cuanto se cancela ctx, para cortar copias largas
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// Si path es un enlace simbólico que debe guardarse como enlace (sin
+// follow), devuelve su destino
+func symlinkTarget(path string, follow bool) (string, bool) {
+	if follow {
+		return "", false
+	}
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return "", false
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", false
+	}
+	return target, true
+}
+
+// Contenido preparado con 'add-content': ruta en el snapshot → archivo en
+// .snapgo/staging. Como vive dentro de .snapgo, collectFiles nunca lo ve; se
+// añade después de recoger los archivos, sin aplicarle el ignore, y si la
+// misma ruta existe en disco, gana la versión preparada.
+type stagedFiles map[string]string
+
+func stagingDir(root string) string {
+	snapgoDir, _, _, _, _, _ := repoPaths(root)
+	return filepath.Join(snapgoDir, "staging")
+}
+
+// Archivo del que leer rel: el preparado si lo hay, si no el del directorio
+func (s stagedFiles) source(root, rel string) string {
+	if staged, ok := s[rel]; ok {
+		return staged
+	}
+	return filepath.Join(root, rel)
+}
+
+// Añade las rutas preparadas a files, sin duplicados y en orden
+func (s stagedFiles) merge(files []string) []string {
+	if len(s) == 0 {
+		return files
+	}
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		seen[f] = true
+	}
+	for rel := range s {
+		if !seen[rel] {
+			files = append(files, rel)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Prefijo de las escrituras de add-content a medias dentro de staging
+const stagingPartialPrefix = ".snapgo-partial-"
+
+func loadStaged(root string) (stagedFiles, error) {
+	dir := stagingDir(root)
+	staged := stagedFiles{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), stagingPartialPrefix) {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		staged[filepath.ToSlash(rel)] = path
+		return nil
+	})
+	return staged, err
+}
+
+func clearStaged(root string) error {
+	return os.RemoveAll(stagingDir(root))
+}
+
+func addContentCmdWithRoot(root string) {
+	fs := flag.NewFlagSet("add-content", flag.ExitOnError)
+	clear := fs.Bool("clear", false, "descartar todo el contenido preparado")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if *clear {
+		must(clearStaged(root))
+		logln("🧹 Área de preparación vacía")
+		return
+	}
+	if len(args) != 1 {
+		fmt.Println("Uso: add-content <ruta> < datos")
+		fmt.Println("     add-content --clear")
+		return
+	}
+	must(addContent(root, args[0], os.Stdin))
+}
+
+// Prepara el contenido de r como el archivo rel del próximo snapshot,
+// calculando el hash mientras se copia
+func addContent(root, rel string, r io.Reader) error {
+	clean := filepath.ToSlash(filepath.Clean(rel))
+	if filepath.IsAbs(rel) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("ruta fuera del repositorio: %s", rel)
+	}
+	if clean == ".snapgo" || strings.HasPrefix(clean, ".snapgo/") {
+		return fmt.Errorf("no se pueden añadir archivos internos de .snapgo: %s", rel)
+	}
+	if strings.HasPrefix(filepath.Base(clean), stagingPartialPrefix) {
+		return fmt.Errorf("nombre reservado para escrituras a medias: %s", rel)
+	}
+	
+	target := filepath.Join(stagingDir(root), filepath.FromSlash(clean))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(target), stagingPartialPrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	// CreateTemp crea el archivo con 0600; el snapshot guarda este modo
+	if err == nil {
+		err = os.Chmod(tmp, 0o644)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	
+	logf("📥 %s preparado (%s, sha256 %s)\n", clean, formatSize(n), hex.EncodeToString(h.Sum(nil))[:12])
+	logln("💡 Se incluirá en el próximo 'snapgo snapshot'")
+	return nil
+}
+
+// Lee una lista de rutas relativas (una por línea) para --from-list, sin
+// aplicar reglas de ignore. Cada ruta debe existir y estar dentro del repo.
+func readFileList(root, source string) ([]string, error) {
+	var data []byte
+	var err error
+	if source == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	// Las rutas se comprueban también tras resolver los enlaces: un enlace
+	// dentro del repositorio puede apuntar fuera de él
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	
+	seen := make(map[string]bool)
+	files := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		
+		rel := filepath.ToSlash(filepath.Clean(line))
+		if filepath.IsAbs(line) || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("ruta fuera del repositorio: %s", line)
+		}
+		if rel == ".snapgo" || strings.HasPrefix(rel, ".snapgo/") {
+			return nil, fmt.Errorf("no se pueden incluir archivos internos de .snapgo: %s", line)
+		}
+		
+		real, err := filepath.EvalSymlinks(filepath.Join(root, rel))
+		if err != nil {
+			return nil, fmt.Errorf("no existe: %s", line)
+		}
+		realRel, err := filepath.Rel(realRoot, real)
+		realRel = filepath.ToSlash(realRel)
+		if err != nil || realRel == ".." || strings.HasPrefix(realRel, "../") {
+			return nil, fmt.Errorf("ruta fuera del repositorio (por un enlace simbólico): %s", line)
+		}
+		if realRel == ".snapgo" || strings.HasPrefix(realRel, ".snapgo/") {
+			return nil, fmt.Errorf("no se pueden incluir archivos internos de .snapgo: %s", line)
+		}
+		
+		info, err := os.Stat(real)
+		if err != nil {
+			return nil, fmt.Errorf("no existe: %s", line)
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("no es un archivo regular: %s", line)
+		}
+		
+		if !seen[rel] {
+			seen[rel] = true
+			files = append(files, rel)
+		}
+	}
+	
+	sort.Strings(files)
+	return files, nil
+}
+
+// Antepone "[<rama>] " al mensaje si no lo lleva ya
+func prefixWithBranch(message, branch string, skipMain bool) string {
+	if branch == "" || (skipMain && branch == "main") {
+		return message
+	}
+	prefix := "[" + branch + "] "
+	if strings.HasPrefix(message, prefix) {
+		return message
+	}
+	return prefix + message
+}
+
+// Ruta del archivo de un snapshot según la distribución indicada. El año y
+// el mes salen del propio ID (yyyymmdd-hhmmss-hash).
+func archivePathFor(snapsDir, id, layout string) string {
+	if layout == layoutSharded && len(id) >= 6 {
+		return filepath.Join(snapsDir, id[:4], id[4:6], id+".tar.gz")
+	}
+	return filepath.Join(snapsDir, id+".tar.gz")
+}
+
+// Único punto para localizar el archivo de un snapshot. Usa la distribución
+// configurada, pero encuentra también archivos aún en la otra distribución.
+func snapshotArchive(root, id string) string {
+	_, snapsDir, _, _, _, _ := repoPaths(root)
+	config, _ := loadConfig(root)
+	
+	path := archivePathFor(snapsDir, id, config.ArchiveLayout)
+	if fileExists(path) {
+		return path
+	}
+	other := layoutSharded
+	if config.ArchiveLayout == layoutSharded {
+		other = layoutFlat
+	}
+	if alt := archivePathFor(snapsDir, id, other); fileExists(alt) {
+		return alt
+	}
+	return path
+}
+
+// El sidecar con la lista de archivos vive junto al archivo del snapshot
+func snapshotFilesPath(root, id string) string {
+	return strings.TrimSuffix(snapshotArchive(root, id), ".tar.gz") + ".meta.json"
+}
+
+// Mueve los archivos de todos los snapshots (archivo, firma y sidecar) a la
+// nueva distribución
+func migrateArchiveLayout(root, layout string) (int, error) {
+	_, snapsDir, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return 0, err
+	}
+	
+	moved := 0
+	for _, s := range idx.Snapshots {
+		from := snapshotArchive(root, s.ID)
+		to := archivePathFor(snapsDir, s.ID, layout)
+		if from == to || !fileExists(from) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+			return moved, err
+		}
+		
+		fromBase := strings.TrimSuffix(from, ".tar.gz")
+		toBase := strings.TrimSuffix(to, ".tar.gz")
+		for _, ext := range []string{".tar.gz", ".tar.gz.sig", ".meta.json"} {
+			if fileExists(fromBase + ext) {
+				if err := os.Rename(fromBase+ext, toBase+ext); err != nil {
+					return moved, err
+				}
+			}
+		}
+		moved++
+		
+		// Borrar los directorios de año/mes que queden vacíos
+		if dir := filepath.Dir(from); dir != snapsDir {
+			os.Remove(dir)
+			os.Remove(filepath.Dir(dir))
+		}
+	}
+	return moved, nil
+}
+
+// Lista (relativos a snapshots/) todos los .tar.gz, en cualquier distribución
+func listArchives(snapsDir string) []string {
+	archives := []string{}
+	filepath.WalkDir(snapsDir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(d.Name(), ".tar.gz") {
+			rel, _ := filepath.Rel(snapsDir, path)
+			archives = append(archives, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return archives
+}
+
+// Carga bajo demanda la lista de archivos de un snapshot. Los índices
+// antiguos la guardaban en línea; en ese caso no hay nada que leer.
+func loadSnapshotFiles(root string, s *SnapshotMeta) error {
+	if len(s.Files) > 0 || s.FileCount == 0 {
+		return nil
+	}
+	
+	var sf SnapshotFiles
+	if err := readJSON(snapshotFilesPath(root, s.ID), &sf); err != nil {
+		return fmt.Errorf("no se pudo leer la lista de archivos de %s: %v", s.ID, err)
+	}
+	s.Files = sf.Files
+	return nil
+}
+
+// Elimina el archivo de un snapshot y todos sus ficheros asociados.
+// Devuelve el error de borrar el archivo principal.
+func removeSnapshotFiles(root, id string) error {
+	archive := snapshotArchive(root, id)
+	os.Remove(archive + ".sig")
+	os.Remove(snapshotFilesPath(root, id))
+	return os.Remove(archive)
+}
+
+// IDs de los snapshots incrementales que usan id como base
+func baseDependents(snaps []SnapshotMeta, id string) []string {
+	var deps []string
+	for _, s := range snaps {
+		if s.Base == id {
+			deps = append(deps, s.ID)
+		}
+	}
+	return deps
+}
+
+// Mueve las listas de archivos de los índices antiguos a sidecars
+// <id>.meta.json. Se ejecuta al arrancar y no hace nada si ya se migró.
+func migrateIndex(root string) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	if !fileExists(indexPath) {
+		return nil
+	}
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	migrated := 0
+	for i := range idx.Snapshots {
+		s := &idx.Snapshots[i]
+		if len(s.Files) == 0 {
+			continue
+		}
+		
+		path := snapshotFilesPath(root, s.ID)
+		if !fileExists(path) {
+			if err := writeJSON(path, SnapshotFiles{ID: s.ID, Files: s.Files}); err != nil {
+				return err
+			}
+		}
+		s.Files = nil
+		migrated++
+	}
+	
+	if migrated == 0 {
+		return nil
+	}
+	return writeJSON(indexPath, idx)
+}
+
+type writeOptions struct {
+	Compression     int         // Nivel gzip
+	ThrottleMBps    int         // Límite de E/S (0 = sin límite)
+	FollowSymlinks  bool        // Guardar el contenido enlazado en vez del enlace
+	StoreExtensions []string    // Extensiones que se guardan con nivel 0
+	Attrs           fileAttrs   // .snapgoattributes (nocompress)
+	Staged          stagedFiles // Rutas cuyo contenido sale del área de preparación
+	ReadConcurrency int         // Archivos leídos en paralelo (<= 1 = secuencial)
+}
+
+// Estadísticas de escritura de un archivo, para snapshot -v
+type writeStats struct {
+	CompressedFiles int
+	CompressedBytes int64
+	CompressTime    time.Duration
+	StoredFiles     int
+	StoredBytes     int64
+	StoreTime       time.Duration
+}
+
+func (s writeStats) print() {
+	fmt.Fprintf(os.Stderr, "🗜️  Comprimidos: %d archivo(s), %s en %v\n", s.CompressedFiles, formatSize(s.CompressedBytes), s.CompressTime.Round(time.Millisecond))
+	fmt.Fprintf(os.Stderr, "📦 Sin comprimir (ya comprimidos): %d archivo(s), %s en %v\n", s.StoredFiles, formatSize(s.StoredBytes), s.StoreTime.Round(time.Millisecond))
+	if s.StoredBytes > 0 && s.CompressedBytes > 0 {
+		// Estimación: lo que habría costado comprimirlos al ritmo medido
+		perByte := float64(s.CompressTime) / float64(s.CompressedBytes)
+		saved := time.Duration(perByte*float64(s.StoredBytes)) - s.StoreTime
+		if saved > 0 {
+			fmt.Fprintf(os.Stderr, "⚡ Ahorro estimado de CPU: %v\n", saved.Round(time.Millisecond))
+		}
+	}
+}
+
+// gzip multimiembro: cada cambio de nivel cierra el miembro actual y abre
+// otro. gzip.Reader (y tar -z) leen los miembros como un único flujo.
+type levelGzipWriter struct {
+	out   io.Writer
+	gw    *gzip.Writer
+	level int
+}
+
+func (w *levelGzipWriter) setLevel(level int) error {
+	if w.gw != nil && level == w.level {
+		return nil
+	}
+	if w.gw != nil {
+		if err := w.gw.Close(); err != nil {
+			return err
+		}
+	}
+	gw, err := gzip.NewWriterLevel(w.out, level)
+	if err != nil {
+		return err
+	}
+	w.gw, w.level = gw, level
+	return nil
+}
+
+func (w *levelGzipWriter) Write(p []byte) (int, error) {
+	return w.gw.Write(p)
+}
+
+func (w *levelGzipWriter) Close() error {
+	return w.gw.Close()
+}
+
+func hasExtension(name string, exts []string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range exts {
+		if ext != "" && ext == strings.ToLower(e) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeTarGz(ctx context.Context, root, out string, files []string, opts writeOptions) (writeStats, error) {
+	var stats writeStats
+	
+	f, err := os.Create(out)
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+	
+	gw := &levelGzipWriter{out: f}
+	if err := gw.setLevel(opts.Compression); err != nil {
+		return stats, err
+	}
+	defer gw.Close()
+	
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+	
+	throttle := newIOThrottle(opts.ThrottleMBps)
+	
+	sources := make([]string, len(files))
+	for i, rel := range files {
+		sources[i] = opts.Staged.source(root, rel)
+	}
+	pf := newPrefetcher(sources, opts.ReadConcurrency)
+	defer pf.close()
+	
+	for i, rel := range files {
+		if err := interrupted(ctx, "escritos %d de %d archivos", i, len(files)); err != nil {
+			return stats, err
+		}
+		full := sources[i]
+		data, prefetched := pf.get(i)
+		
+		// Los enlaces simbólicos se guardan como enlaces, sin contenido
+		if target, ok := symlinkTarget(full, opts.FollowSymlinks); ok {
+			info, err := os.Lstat(full)
+			if err != nil {
+				return stats, err
+			}
+			hdr, err := tar.FileInfoHeader(info, target)
+			if err != nil {
+				return stats, err
+			}
+			hdr.Name = rel
+			if err := tw.WriteHeader(hdr); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		
+		info, err := os.Stat(full)
+		if err != nil {
+			return stats, err
+		}
+		
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return stats, err
+		}
+		
+		// Rellenar la entrada anterior antes de cambiar de nivel
+		if err := tw.Flush(); err != nil {
+			return stats, err
+		}
+		store := hasExtension(rel, opts.StoreExtensions) || opts.Attrs.lookup(rel).NoCompress
+		level := opts.Compression
+		if store {
+			level = gzip.NoCompression
+		}
+		if err := gw.setLevel(level); err != nil {
+			return stats, err
+		}
+		
+		hdr.Name = rel
+		var src io.ReadCloser
+		if prefetched {
+			// El archivo pudo cambiar entre el Stat y la lectura
+			hdr.Size = int64(len(data))
+			src = io.NopCloser(bytes.NewReader(data))
+		} else if src, err = os.Open(full); err != nil {
+			return stats, err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			src.Close()
+			return stats, err
+		}
+		
+		start := time.Now()
+		n, err := io.Copy(tw, throttle.wrap(ctxReader{ctx, src}))
+		src.Close()
+		if ierr := interrupted(ctx, "escritos %d de %d archivos", i, len(files)); ierr != nil {
+			return stats, ierr
+		}
+		if err != nil {
+			return stats, err
+		}
+		if store {
+			stats.StoredFiles++
+			stats.StoredBytes += n
+			stats.StoreTime += time.Since(start)
+		} else {
+			stats.CompressedFiles++
+			stats.CompressedBytes += n
+			stats.CompressTime += time.Since(start)
+		}
+	}
+	
+	return stats, nil
+}
+
+// Archivos de más de este tamaño no se leen por adelantado: se copian en
+// streaming para no cargarlos enteros en memoria
+const prefetchMaxSize = 8 << 20
+
+// Lectura anticipada de archivos con varios lectores en paralelo. Los
+// resultados se piden con get en el orden original, así que las entradas
+// del tar siguen siendo deterministas; como mucho hay n archivos leídos o
+// en lectura a la vez, lo que acota la memoria usada.
+type prefetcher struct {
+	results []chan []byte // nil = no se leyó por adelantado
+	slots   chan struct{}
+	stop    chan struct{}
+}
+
+// Con n <= 1 devuelve nil, y get siempre responde "léelo tú"
+func newPrefetcher(paths []string, n int) *prefetcher {
+	if n <= 1 || len(paths) == 0 {
+		return nil
+	}
+	p := &prefetcher{
+		results: make([]chan []byte, len(paths)),
+		slots:   make(chan struct{}, n),
+		stop:    make(chan struct{}),
+	}
+	for i := range p.results {
+		p.results[i] = make(chan []byte, 1)
+	}
+	
+	go func() {
+		for i, path := range paths {
+			select {
+			case p.slots <- struct{}{}:
+			case <-p.stop:
+				return
+			}
+			go func(i int, path string) {
+				p.results[i] <- readSmallFile(path)
+			}(i, path)
+		}
+	}()
+	return p
+}
+
+// Contenido de un archivo regular pequeño; nil si es otra cosa, es grande
+// o no se puede leer (quien lo pida lo leerá y verá el error)
+func readSmallFile(path string) []byte {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > prefetchMaxSize {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data
+}
+
+// Contenido leído por adelantado del archivo i. Hay que llamarlo para cada
+// índice y en orden, porque libera el hueco para el siguiente.
+func (p *prefetcher) get(i int) ([]byte, bool) {
+	if p == nil {
+		return nil, false
+	}
+	data := <-p.results[i]
+	<-p.slots
+	return data, data != nil
+}
+
+func (p *prefetcher) close() {
+	if p != nil {
+		close(p.stop)
+	}
+}
+
+// ioThrottle limita el ritmo de lectura de un snapshot completo insertando
+// pequeñas pausas. Cambia velocidad del snapshot por un sistema que sigue
+// respondiendo (útil en portátiles o snapshots en segundo plano).
+type ioThrottle struct {
+	bytesPerSec int64
+	start       time.Time
+	done        int64
+}
+
+func newIOThrottle(mbps int) *ioThrottle {
+	if mbps <= 0 {
+		return nil // Sin límite
+	}
+	return &ioThrottle{bytesPerSec: int64(mbps) * 1024 * 1024, start: time.Now()}
+}
+
+func (t *ioThrottle) wrap(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{r: r, t: t}
+}
+
+type throttledReader struct {
+	r io.Reader
+	t *ioThrottle
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	// Leer en bloques de ~100ms para que las pausas sean cortas
+	if chunk := int(tr.t.bytesPerSec / 10); chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+	
+	n, err := tr.r.Read(p)
+	tr.t.done += int64(n)
+	
+	expected := time.Duration(float64(tr.t.done) / float64(tr.t.bytesPerSec) * float64(time.Second))
+	if elapsed := time.Since(tr.t.start); expected > elapsed {
+		time.Sleep(expected - elapsed)
+	}
+	return n, err
+}
+
+func listCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	size := fs.Bool("size", false, "mostrar lo que ocupa cada snapshot en disco")
+	branch := fs.String("branch", "", "listar solo los snapshots de esa rama")
+	all := fs.Bool("all", false, "listar los snapshots de todas las ramas, indicando la rama de cada uno")
+	parseFlags(fs, os.Args[2:])
+	
+	if *branch != "" && *all {
+		fmt.Println("Uso: --branch y --all no se pueden combinar")
+		return
+	}
+	must(listSnapshots(rootDir, ListOptions{Size: *size, Branch: *branch, All: *all}))
+}
+
+// Espacio en disco de un snapshot: su archivo más los ficheros asociados
+// (firma y lista de archivos). ok es false si falta el archivo.
+func snapshotDiskSize(root, id string) (size int64, ok bool) {
+	archive := snapshotArchive(root, id)
+	info, err := os.Stat(archive)
+	if err != nil {
+		return 0, false
+	}
+	size = info.Size()
+	for _, extra := range []string{archive + ".sig", snapshotFilesPath(root, id)} {
+		if info, err := os.Stat(extra); err == nil {
+			size += info.Size()
+		}
+	}
+	return size, true
+}
+
+type ListOptions struct {
+	Size   bool   // Mostrar lo que ocupa cada snapshot en disco
+	Branch string // Solo los snapshots de esta rama ("" = todas)
+	All    bool   // Todas las ramas, con el nombre de la rama en cada entrada
+}
+
+func listSnapshots(root string, opts ListOptions) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		// Mostrar error específico
+		fmt.Printf("❌ No se pudo leer el índice en: %s\n", indexPath)
+		fmt.Println("   ¿Estás en el directorio correcto?")
+		fmt.Println("   Usa 'snapgo init' para crear un repositorio")
+		return err
+	}
+	
+	if len(idx.Snapshots) == 0 {
+		fmt.Println("📭 No hay snapshots todavía.")
+		fmt.Println("💡 Usa 'snapgo snapshot -m \"mensaje\"' para crear el primero.")
+		return nil
+	}
+	
+	snaps := idx.Snapshots
+	if opts.Branch != "" {
+		snaps = nil
+		for _, s := range idx.Snapshots {
+			if snapshotBranch(s) == opts.Branch {
+				snaps = append(snaps, s)
+			}
+		}
+		if len(snaps) == 0 {
+			if _, ok := idx.Branches[opts.Branch]; !ok {
+				return fmt.Errorf("la rama '%s' no existe", opts.Branch)
+			}
+			fmt.Printf("📭 La rama '%s' no tiene snapshots todavía.\n", opts.Branch)
+			return nil
+		}
+		fmt.Printf("📦 Snapshots de la rama '%s' (en %s):\n", opts.Branch, root)
+	} else {
+		fmt.Printf("📦 Snapshots disponibles (en %s):\n", root)
+	}
+	
+	var total int64
+	missing := 0
+	for i, s := range snaps {
+		t, _ := time.Parse(time.RFC3339, s.Timestamp)
+		timeStr := t.Format("02/01 15:04")
+		
+		prefix := "   "
+		if i == len(snaps)-1 {
+			prefix = "🟢 "
+		}
+		
+		branchStr := ""
+		if opts.All {
+			branchStr = "  [" + snapshotBranch(s) + "]"
+		}
+		
+		sizeStr := ""
+		if opts.Size {
+			if size, ok := snapshotDiskSize(root, s.ID); ok {
+				total += size
+				sizeStr = "  " + formatSize(size)
+			} else {
+				missing++
+				sizeStr = "  (faltante)"
+			}
+		}
+		
+		lock := ""
+		if s.Protected {
+			lock = "  🔒"
+		}
+		
+		fmt.Printf("%s%s  %s%s  %d archivos%s%s\n", prefix, s.ID, timeStr, branchStr, s.FileCount, sizeStr, lock)
+		fmt.Printf("      \"%s\"\n", s.Message)
+	}
+	
+	if opts.Size {
+		fmt.Printf("\n💾 Total: %s en %d snapshot(s)", formatSize(total), len(snaps)-missing)
+		if missing > 0 {
+			fmt.Printf(" (%d sin archivo)", missing)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func showCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	byType := fs.Bool("by-type", false, "desglose por extensión en lugar de la lista de archivos")
+	parent := fs.Bool("parent", false, "imprimir solo el ID del snapshot padre")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if len(args) < 1 {
+		fmt.Println("Uso: show <id> [--by-type] [--parent]")
+		return
+	}
+	
+	if *parent {
+		must(showParent(rootDir, args[0]))
+		return
+	}
+	must(showSnapshot(rootDir, args[0], *byType))
+}
+
+// Imprime solo el ID del padre, para recorrer la cadena desde scripts.
+// Un snapshot raíz no tiene padre y se trata como error.
+func showParent(root, id string) error {
+	id, err := resolveSpecialID(root, id)
+	if err != nil {
+		return err
+	}
+	s, err := findSnapshot(root, id)
+	if err != nil {
+		return err
+	}
+	if s.Parent == "" {
+		return fmt.Errorf("%s es un snapshot raíz (sin padre)", s.ID)
+	}
+	fmt.Println(s.Parent)
+	return nil
+}
+
+// Muestra el último snapshot; con --id solo imprime su ID (para scripts)
+func lastCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("last", flag.ExitOnError)
+	idOnly := fs.Bool("id", false, "imprimir solo el ID")
+	parseFlags(fs, os.Args[2:])
+	
+	must(lastSnapshot(rootDir, *idOnly))
+}
+
+func lastSnapshot(root string, idOnly bool) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	if len(idx.Snapshots) == 0 {
+		return fmt.Errorf("no hay snapshots")
+	}
+	
+	s := idx.Snapshots[len(idx.Snapshots)-1]
+	if idOnly {
+		fmt.Println(s.ID)
+		return nil
+	}
+	
+	fmt.Printf("🆔 %s\n", s.ID)
+	fmt.Printf("📅 %s\n", formatTimeLong(s.Timestamp))
+	fmt.Printf("📝 %s\n", s.Message)
+	fmt.Printf("📁 %d archivo%s\n", s.FileCount, plural(s.FileCount))
+	return nil
+}
+
+func lsCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	recursive := fs.Bool("R", false, "listar también los subdirectorios")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if len(args) < 1 {
+		fmt.Println("Uso: ls <id> [ruta] [-R]")
+		return
+	}
+	dir := ""
+	if len(args) > 1 {
+		dir = args[1]
+	}
+	must(lsSnapshot(rootDir, args[0], dir, *recursive))
+}
+
+// Lista un nivel del árbol de un snapshot (o todo el subárbol con
+// recursive), deduciendo los directorios de los nombres del archivo
+func lsSnapshot(root, id, dir string, recursive bool) error {
+	id, err := resolveSpecialID(root, id)
+	if err != nil {
+		return err
+	}
+	
+	sizes, err := snapshotEntrySizes(root, id)
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el snapshot '%s': %v", id, err)
+	}
+	
+	prefix := strings.Trim(filepath.ToSlash(dir), "/")
+	if prefix == "." {
+		prefix = ""
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	
+	children := archiveChildren(sizes, prefix)
+	if len(children) == 0 {
+		return fmt.Errorf("'%s' no existe en el snapshot %s", dir, id)
+	}
+	
+	fmt.Printf("📂 %s:/%s\n", id, prefix)
+	printLsLevel(children, sizes, prefix, "", "   ", recursive)
+	return nil
+}
+
+// Hijos directos de cada directorio bajo prefix ("" = el propio prefix),
+// deducidos de los nombres del archivo. Los directorios terminan en "/".
+// Vacío si ninguna entrada cuelga de prefix.
+func archiveChildren(sizes map[string]int64, prefix string) map[string]map[string]bool {
+	children := map[string]map[string]bool{}
+	for name := range sizes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(name, prefix), "/")
+		for i := range parts {
+			parent := strings.Join(parts[:i], "/")
+			child := parts[i]
+			if i < len(parts)-1 {
+				child += "/"
+			}
+			if children[parent] == nil {
+				children[parent] = map[string]bool{}
+			}
+			children[parent][child] = true
+		}
+	}
+	return children
+}
+
+// Hijos de dir: directorios primero, luego archivos, cada grupo en orden alfabético
+func sortedChildren(children map[string]map[string]bool, dir string) []string {
+	names := make([]string, 0, len(children[dir]))
+	for name := range children[dir] {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := strings.HasSuffix(names[i], "/"), strings.HasSuffix(names[j], "/")
+		if di != dj {
+			return di
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func printLsLevel(children map[string]map[string]bool, sizes map[string]int64, prefix, dir, indent string, recursive bool) {
+	for _, name := range sortedChildren(children, dir) {
+		path := name
+		if dir != "" {
+			path = dir + "/" + name
+		}
+		if strings.HasSuffix(name, "/") {
+			fmt.Printf("%s📁 %s\n", indent, name)
+			if recursive {
+				printLsLevel(children, sizes, prefix, strings.TrimSuffix(path, "/"), indent+"   ", recursive)
+			}
+			continue
+		}
+		fmt.Printf("%s📄 %-40s %s\n", indent, name, formatSize(sizes[prefix+path]))
+	}
+}
+
+func showSnapshot(root, id string, byType bool) error {
+	id, err := resolveSpecialID(root, id)
+	if err != nil {
+		return err
+	}
+	
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	for _, s := range idx.Snapshots {
+		if s.ID == id {
+			fmt.Println("📊 Detalles del Snapshot")
+			fmt.Println("══════════════════════════════════════════")
+			fmt.Printf("🆔 ID:        %s\n", s.ID)
+			
+			t, _ := time.Parse(time.RFC3339, s.Timestamp)
+			fmt.Printf("📅 Fecha:     %s\n", t.Format("02/01/2006 15:04:05"))
+			fmt.Printf("🔒 Hash:      %s\n", s.Hash)
+			fmt.Printf("📁 Archivos:  %d\n", s.FileCount)
+			fmt.Printf("📝 Mensaje:   %s\n", s.Message)
+			if s.SignatureKey != "" {
+				fmt.Printf("🔏 Firmado:   %s\n", s.SignatureKey)
+			}
+			if s.ExplicitList {
+				fmt.Println("📋 Origen:    lista explícita (--from-list)")
+			}
+			if s.Protected {
+				fmt.Println("🔐 Protegido: no se borra al limpiar")
+			}
+			if s.Base != "" {
+				fmt.Printf("🧩 Incremental sobre: %s\n", s.Base)
+			}
+			if tags := tagsFor(idx, s.ID); len(tags) > 0 {
+				fmt.Printf("🏷️  Etiquetas: %s\n", strings.Join(tags, ", "))
+			}
+			if len(s.Annotations) > 0 {
+				fmt.Println("\n🗒️  Notas:")
+				for _, note := range s.Annotations {
+					for i, line := range strings.Split(note, "\n") {
+						bullet := "•"
+						if i > 0 {
+							bullet = " "
+						}
+						fmt.Printf("   %s %s\n", bullet, line)
+					}
+				}
+			}
+			
+			if err := loadSnapshotFiles(root, &s); err != nil {
+				return err
+			}
+			
+			if byType {
+				sizes, err := snapshotEntrySizes(root, s.ID)
+				if err != nil {
+					fmt.Printf("\n⚠️  No se pudieron leer los tamaños: %v\n", err)
+				}
+				
+				fmt.Println("\n📊 Archivos por tipo:")
+				printTypeStats(statsByType(s.Files, sizes), sizes != nil)
+				return nil
+			}
+			
+			if len(s.Files) > 0 {
+				fmt.Println("\n📄 Archivos incluidos:")
+				for _, f := range s.Files {
+					fmt.Printf("   • %s\n", f)
+				}
+			}
+			
+			return nil
+		}
+	}
+	
+	return fmt.Errorf("snapshot '%s' no encontrado", id)
+}
+
+// Estadísticas de archivos agrupados por extensión
+type typeStat struct {
+	Ext   string
+	Count int
+	Bytes int64
+}
+
+const noExtension = "(sin extensión)"
+
+func fileTypeOf(path string) string {
+	base := filepath.Base(path)
+	ext := strings.ToLower(filepath.Ext(base))
+	// Archivos ocultos como .gitignore no tienen extensión
+	if ext == "" || ext == strings.ToLower(base) {
+		return noExtension
+	}
+	return ext
+}
+
+// Agrupa archivos por extensión. sizes puede ser nil si no hay tamaños.
+func statsByType(files []string, sizes map[string]int64) []typeStat {
+	byExt := make(map[string]*typeStat)
+	for _, f := range files {
+		ext := fileTypeOf(f)
+		st, ok := byExt[ext]
+		if !ok {
+			st = &typeStat{Ext: ext}
+			byExt[ext] = st
+		}
+		st.Count++
+		st.Bytes += sizes[f]
+	}
+	
+	stats := make([]typeStat, 0, len(byExt))
+	for _, st := range byExt {
+		stats = append(stats, *st)
+	}
+	sortTypeStats(stats)
+	return stats
+}
+
+// Ordena por tamaño total y después por número de archivos
+func sortTypeStats(stats []typeStat) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Ext < stats[j].Ext
+	})
+}
+
+func printTypeStats(stats []typeStat, withSizes bool) {
+	if len(stats) == 0 {
+		fmt.Println("   (sin archivos)")
+		return
+	}
+	for _, st := range stats {
+		if withSizes {
+			fmt.Printf("   %-18s %6d archivo%s  %10s\n", st.Ext, st.Count, plural(st.Count), formatSize(st.Bytes))
+		} else {
+			fmt.Printf("   %-18s %6d archivo%s\n", st.Ext, st.Count, plural(st.Count))
+		}
+	}
+}
+
+func treeCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	depth := fs.Int("depth", 0, "profundidad máxima (0 = sin límite)")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if len(args) != 1 {
+		fmt.Println("Uso: tree <id> [--depth N]")
+		return
+	}
+	must(treeSnapshot(rootDir, args[0], *depth))
+}
+
+// Dibuja el árbol completo de un snapshot, como el comando tree
+func treeSnapshot(root, id string, depth int) error {
+	id, err := resolveSpecialID(root, id)
+	if err != nil {
+		return err
+	}
+	
+	sizes, err := snapshotEntrySizes(root, id)
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el snapshot '%s': %v", id, err)
+	}
+	
+	children := archiveChildren(sizes, "")
+	dirs, files := 0, 0
+	
+	var walk func(dir, indent string, level int)
+	walk = func(dir, indent string, level int) {
+		names := sortedChildren(children, dir)
+		for i, name := range names {
+			branch, next := "├── ", "│   "
+			if i == len(names)-1 {
+				branch, next = "└── ", "    "
+			}
+			fmt.Println(indent + branch + name)
+			
+			if !strings.HasSuffix(name, "/") {
+				files++
+				continue
+			}
+			dirs++
+			if depth == 0 || level < depth {
+				path := strings.TrimSuffix(name, "/")
+				if dir != "" {
+					path = dir + "/" + path
+				}
+				walk(path, indent+next, level+1)
+			}
+		}
+	}
+	
+	fmt.Println(id)
+	walk("", "", 1)
+	fmt.Printf("\n%d directorios, %d archivos\n", dirs, files)
+	return nil
+}
+
+func grepCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	ignoreCase := fs.Bool("i", false, "no distinguir mayúsculas y minúsculas")
+	count := fs.Bool("count", false, "mostrar solo el número de coincidencias por archivo")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("Uso: grep <regex> [id] [-i] [--count]")
+		return
+	}
+	id := "HEAD"
+	if len(args) == 2 {
+		id = args[1]
+	}
+	
+	pattern := args[0]
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		must(fmt.Errorf("expresión regular inválida: %v", err))
+	}
+	
+	must(grepSnapshot(rootDir, id, re, *count))
+}
+
+// Busca re en los archivos de texto de un snapshot. Lee el archivo en
+// streaming, línea a línea, sin extraer nada a disco.
+func grepSnapshot(root, id string, re *regexp.Regexp, count bool) error {
+	id, err := resolveSpecialID(root, id)
+	if err != nil {
+		return err
+	}
+	
+	matches := 0
+	err = walkSnapshotEntries(root, id, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		
+		br := bufio.NewReaderSize(r, 64*1024)
+		head, _ := br.Peek(8000)
+		if isBinary(head) {
+			return nil
+		}
+		
+		n := 0
+		sc := bufio.NewScanner(br)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for lineNo := 1; sc.Scan(); lineNo++ {
+			line := sc.Text()
+			if !re.MatchString(line) {
+				continue
+			}
+			n++
+			if !count {
+				fmt.Printf("%s:%d:%s\n", hdr.Name, lineNo, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+		
+		if count && n > 0 {
+			fmt.Printf("%s:%d\n", hdr.Name, n)
+		}
+		matches += n
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el snapshot '%s': %v", id, err)
+	}
+	
+	if matches == 0 {
+		logln("🔎 Sin coincidencias")
+	}
+	return nil
+}
+
+// Tamaño original de cada archivo de un snapshot (en los incrementales,
+// también el de los heredados de la base)
+func snapshotEntrySizes(root, id string) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	err := walkSnapshotEntries(root, id, func(hdr *tar.Header, r io.Reader) error {
+		sizes[hdr.Name] = hdr.Size
+		return nil
+	})
+	return sizes, err
+}
+
+// Cabeceras de todos los archivos de un snapshot, incluidos los heredados
+func snapshotHeaders(root, id string) ([]*tar.Header, error) {
+	var headers []*tar.Header
+	err := walkSnapshotEntries(root, id, func(hdr *tar.Header, r io.Reader) error {
+		headers = append(headers, hdr)
+		return nil
+	})
+	return headers, err
+}
+
+// Estadísticas agregadas de todos los snapshots del repositorio
+func statsCmdWithRoot(root string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	churn := fs.Bool("churn", false, "archivos que más veces han cambiado entre snapshots")
+	limit := fs.Int("limit", 10, "con --churn, mostrar solo los N primeros (0 = todos)")
+	asJSON := fs.Bool("json", false, "con --churn, salida en JSON")
+	parseFlags(fs, os.Args[2:])
+	
+	if *asJSON && !*churn {
+		return fmt.Errorf("--json solo se puede usar con --churn")
+	}
+	
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	if *churn {
+		return printChurn(root, idx, *limit, *asJSON)
+	}
+	
+	if len(idx.Snapshots) == 0 {
+		fmt.Println("📭 No hay snapshots todavía")
+		return nil
+	}
+	
+	totalFiles := 0
+	var archiveBytes int64
+	withSizes := true
+	byExt := make(map[string]*typeStat)
+	
+	for _, s := range idx.Snapshots {
+		archive := snapshotArchive(root, s.ID)
+		if info, err := os.Stat(archive); err == nil {
+			archiveBytes += info.Size()
+		}
+		
+		sizes, err := snapshotEntrySizes(root, s.ID)
+		if err != nil {
+			withSizes = false
+		}
+		
+		if err := loadSnapshotFiles(root, &s); err != nil {
+			return err
+		}
+		
+		totalFiles += s.FileCount
+		for _, st := range statsByType(s.Files, sizes) {
+			agg, ok := byExt[st.Ext]
+			if !ok {
+				agg = &typeStat{Ext: st.Ext}
+				byExt[st.Ext] = agg
+			}
+			agg.Count += st.Count
+			agg.Bytes += st.Bytes
+		}
+	}
+	
+	stats := make([]typeStat, 0, len(byExt))
+	for _, st := range byExt {
+		stats = append(stats, *st)
+	}
+	sortTypeStats(stats)
+	
+	fmt.Printf("📈 Estadísticas del Repositorio (en %s)\n", root)
+	fmt.Println("══════════════════════════════════════════")
+	fmt.Printf("📦 Snapshots:        %d\n", len(idx.Snapshots))
+	fmt.Printf("📁 Archivos (total): %d\n", totalFiles)
+	fmt.Printf("💾 Tamaño en disco:  %s\n", formatSize(archiveBytes))
+	
+	fmt.Println("\n📊 Archivos por tipo (todos los snapshots):")
+	printTypeStats(stats, withSizes)
+	if !withSizes {
+		fmt.Println("\n⚠️  Algunos archivos de snapshot no se pudieron leer; los tamaños están incompletos")
+	}
+	
+	return nil
+}
+
+type churnEntry struct {
+	Path    string `json:"path"`
+	Changes int    `json:"changes"`
+}
+
+// Cuenta en cuántos snapshots cambió el contenido de cada archivo respecto
+// al snapshot padre (o al anterior del índice si no tiene padre). Devuelve
+// los archivos de más a menos cambios y cuántos snapshots no se pudieron leer.
+func fileChurn(root string, idx Index) (entries []churnEntry, unreadable int) {
+	hashes := map[string]map[string]string{}
+	failed := map[string]bool{}
+	hashesOf := func(id string) map[string]string {
+		if h, ok := hashes[id]; ok || failed[id] {
+			return h
+		}
+		h, err := snapshotContentHashes(root, id)
+		if err != nil {
+			failed[id] = true
+			unreadable++
+			return nil
+		}
+		hashes[id] = h
+		return h
+	}
+	
+	known := make(map[string]bool, len(idx.Snapshots))
+	for _, s := range idx.Snapshots {
+		known[s.ID] = true
+	}
+	
+	counts := map[string]int{}
+	for i, s := range idx.Snapshots {
+		parent := s.Parent
+		if parent == "" || !known[parent] {
+			if i == 0 {
+				continue
+			}
+			parent = idx.Snapshots[i-1].ID
+		}
+		older, newer := hashesOf(parent), hashesOf(s.ID)
+		if older == nil || newer == nil {
+			continue
+		}
+		_, _, modified := compareFileHashes(older, newer)
+		for _, f := range modified {
+			counts[f]++
+		}
+	}
+	
+	for path, n := range counts {
+		entries = append(entries, churnEntry{Path: path, Changes: n})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Changes != entries[j].Changes {
+			return entries[i].Changes > entries[j].Changes
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, unreadable
+}
+
+func printChurn(root string, idx Index, limit int, asJSON bool) error {
+	entries, unreadable := fileChurn(root, idx)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	
+	if asJSON {
+		if entries == nil {
+			entries = []churnEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	
+	if len(entries) == 0 {
+		fmt.Println("📭 Ningún archivo ha cambiado entre snapshots")
+	} else {
+		fmt.Printf("🔥 Archivos que más cambian (%d snapshots):\n", len(idx.Snapshots))
+		for i, e := range entries {
+			fmt.Printf("   %2d. %-40s %d cambio%s\n", i+1, e.Path, e.Changes, plural(e.Changes))
+		}
+	}
+	if unreadable > 0 {
+		fmt.Printf("\n⚠️  %d snapshot(s) no se pudieron leer y no cuentan\n", unreadable)
+	}
+	return nil
+}
+
+// Nueva versión de restoreCmd que acepta directorio raíz
+func restoreCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := fs.Bool("force", false, "sobrescribir directorio actual")
+	var merge mergeFlag
+	fs.Var(&merge, "merge", "restaurar solo archivos que faltan (--merge=newer: también los más antiguos)")
+	keepNewer := fs.Bool("keep-newer", false, "conservar la versión más reciente de cada archivo (igual que --merge=newer)")
+	only := fs.String("only", "", "restaurar solo los archivos que casen con el patrón (admite **)")
+	outDir := fs.String("out-dir", "", "directorio donde restaurar (por defecto _restore_<id>)")
+	at := fs.String("at", "", "restaurar el snapshot vigente en ese momento (RFC3339 o 'AAAA-MM-DD HH:MM')")
+	verifyAfter := fs.Bool("verify-after", false, "comprobar los hashes de los archivos restaurados")
+	dryRun := fs.Bool("dry-run", false, "mostrar qué archivos se crearían, sobrescribirían o eliminarían, sin escribir")
+	strip := fs.Int("strip", 0, "quitar N componentes iniciales de cada ruta")
+	forceWrite := fs.Bool("force-write", false, "reescribir también los archivos que ya coinciden con el snapshot")
+	branch := fs.String("branch", "", "restaurar el último snapshot de otra rama (sin cambiar de rama)")
+	preserveTimes := fs.Bool("preserve-times", true, "dar a los archivos la fecha de modificación del snapshot")
+	noPreserveTimes := fs.Bool("no-preserve-times", false, "dejar a los archivos la hora actual (fuerza recompilaciones)")
+	atomicFiles := fs.Bool("atomic-per-file", true, "escribir cada archivo en un temporal y renombrarlo al terminar")
+	noAtomicFiles := fs.Bool("no-atomic-per-file", false, "escribir directamente sobre cada archivo (más rápido)")
+	backupLabel := fs.String("backup-label", "", "mensaje del backup automático que crea --force")
+	noBackup := fs.Bool("no-backup", false, "con --force, no crear el backup automático")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if *branch != "" {
+		if len(args) > 0 || *at != "" {
+			fmt.Println("Uso: restore --branch <rama> (sin <id> ni --at)")
+			return
+		}
+		id, err := branchHeadSnapshot(rootDir, *branch)
+		must(err)
+		logf("🌿 Último snapshot de '%s': %s\n", *branch, id)
+		args = []string{id}
+	}
+	
+	if *at != "" {
+		if len(args) > 0 {
+			fmt.Println("Uso: restore --at <fecha> (sin <id>)")
+			return
+		}
+		id, err := snapshotAtTime(rootDir, *at)
+		must(err)
+		logf("🕰️  Snapshot vigente en %s: %s\n", *at, id)
+		args = []string{id}
+	}
+	
+	if len(args) < 1 {
+		fmt.Println("Uso: restore <id> [--force] [--merge[=newer]] [--only <patrón>] [--out-dir <dir>]")
+		return
+	}
+	
+	if *keepNewer {
+		if merge != "" && merge != "newer" {
+			fmt.Println("Uso: --keep-newer no se puede combinar con --merge")
+			return
+		}
+		merge = "newer"
+	}
+	
+	opts := RestoreOptions{
+		Force:  *force,
+		Merge:  string(merge),
+		Only:   *only,
+		OutDir: *outDir,
+		
+		VerifyAfter: *verifyAfter,
+		DryRun:      *dryRun,
+		Strip:       *strip,
+		ForceWrite:  *forceWrite,
+		
+		NoPreserveTimes: *noPreserveTimes || !*preserveTimes,
+		NoAtomicFiles:   *noAtomicFiles || !*atomicFiles,
+		BackupLabel:     *backupLabel,
+		NoBackup:        *noBackup,
+	}
+	if opts.NoBackup && opts.BackupLabel != "" {
+		fmt.Println("Uso: --backup-label y --no-backup no se pueden combinar")
+		return
+	}
+	must(restoreWithOptions(rootDir, args[0], opts))
+}
+
+// Formatos aceptados por --at, además de RFC3339 (en hora local)
+var atTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func parseAtTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range atTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("fecha inválida '%s' (usa RFC3339 o 'AAAA-MM-DD HH:MM')", value)
+}
+
+// El snapshot más reciente de la rama actual creado en o antes de 'at'
+func snapshotAtTime(root, at string) (string, error) {
+	when, err := parseAtTime(at)
+	if err != nil {
+		return "", err
+	}
+	
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return "", err
+	}
+	
+	current := snapshotBranch(SnapshotMeta{Branch: idx.Current})
+	for i := len(idx.Snapshots) - 1; i >= 0; i-- {
+		s := idx.Snapshots[i]
+		if snapshotBranch(s) != current {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s.Timestamp)
+		if err == nil && !t.After(when) {
+			return s.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no hay ningún snapshot en la rama '%s' anterior a %s", current, at)
+}
+
+// El último snapshot de una rama; no toca Index.Current
+func branchHeadSnapshot(root, branch string) (string, error) {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return "", err
+	}
+	
+	head := branchHead(idx, branch)
+	if head == "" {
+		return "", fmt.Errorf("la rama '%s' no tiene snapshots", branch)
+	}
+	return head, nil
+}
+
+// Opciones de restauración
+type RestoreOptions struct {
+	Force  bool   // Restaurar sobre el directorio actual (con backup y papelera)
+	Merge  string // "missing" o "newer": restaurar en el sitio sin pisar archivos
+	Only   string // Patrón (con soporte de **) de los archivos a restaurar
+	OutDir string // Directorio de destino en lugar de _restore_<id>
+	
+	VerifyAfter bool // Comparar los archivos restaurados con los hashes del snapshot
+	DryRun      bool // Solo mostrar qué archivos se crearían, sobrescribirían o eliminarían
+	Strip       int  // Quitar N componentes iniciales de las rutas (como tar --strip-components)
+	ForceWrite  bool // Reescribir también los archivos que ya coinciden con el snapshot
+	
+	// Dejar a los archivos escritos la hora actual en vez de la del snapshot.
+	// Con --keep-newer, esos archivos contarán después como más recientes
+	// que el snapshot y no se sobrescribirán.
+	NoPreserveTimes bool
+	
+	// Escribir cada archivo directamente en vez de en un temporal + rename.
+	// Una restauración interrumpida puede dejar archivos a medias.
+	NoAtomicFiles bool
+	
+	BackupLabel string // Mensaje del backup automático de --force ("" = el de siempre)
+	NoBackup    bool   // Restaurar con --force sin crear el backup automático
+}
+
+// Mensaje del snapshot de backup: la etiqueta de --backup-label o def
+func (o RestoreOptions) backupMessage(def string) string {
+	if o.BackupLabel != "" {
+		return o.BackupLabel
+	}
+	return def
+}
+
+// Valor de --merge. "--merge" solo restaura archivos que no existen;
+// "--merge=newer" además sobrescribe los que son más antiguos que el snapshot.
+type mergeFlag string
+
+func (m *mergeFlag) String() string { return string(*m) }
+
+func (m *mergeFlag) Set(v string) error {
+	switch v {
+	case "true", "missing":
+		*m = "missing"
+	case "newer":
+		*m = "newer"
+	case "false":
+		*m = ""
+	default:
+		return fmt.Errorf("modo de merge desconocido '%s' (usa missing o newer)", v)
+	}
+	return nil
+}
+
+func (m *mergeFlag) IsBoolFlag() bool { return true }
+
+// Flag que se puede repetir (--only a --only b)
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func restore(root, id string, force bool) error {
+	return restoreWithOptions(root, id, RestoreOptions{Force: force})
+}
+
+func restoreWithOptions(root, id string, opts RestoreOptions) error {
+	id, err := resolveSpecialID(root, id)
+	if err != nil {
+		return err
+	}
+	
+	if err := checkSnapshotChain(root, id); err != nil {
+		return err
+	}
+	
+	if opts.Strip < 0 {
+		return fmt.Errorf("--strip no puede ser negativo")
+	}
+	if opts.Strip > 0 && opts.VerifyAfter {
+		return fmt.Errorf("--strip y --verify-after no se pueden combinar")
+	}
+	
+	if opts.OutDir != "" && (opts.Force || opts.Merge != "") {
+		return fmt.Errorf("--out-dir no se puede combinar con --force ni --merge")
+	}
+	
+	if opts.Merge != "" {
+		if opts.Force {
+			return fmt.Errorf("--merge y --force no se pueden combinar")
+		}
+		if opts.Only != "" {
+			return fmt.Errorf("--merge y --only no se pueden combinar")
+		}
+		if opts.VerifyAfter {
+			return fmt.Errorf("--merge y --verify-after no se pueden combinar")
+		}
+	}
+	
+	if opts.DryRun {
+		return restoreDryRun(root, id, opts)
+	}
+	
+	if opts.Merge != "" {
+		return restoreMerge(root, id, opts)
+	}
+	
+	if opts.Only != "" {
+		return restoreOnly(root, id, opts)
+	}
+	
+	force := opts.Force
+	if force {
+		if opts.NoBackup {
+			logln("⚠️  Restaurando sin backup automático (--no-backup)")
+		} else {
+			backupID := fmt.Sprintf("backup_pre_restore_%s", time.Now().Format("20060102_150405"))
+			logf("💾 Creando backup automático: %s\n", backupID)
+			
+			// Un directorio vacío no necesita backup
+			if err := snapshot(root, opts.backupMessage(fmt.Sprintf("Backup antes de restaurar %s", id))); err != nil && err != errNoFiles {
+				return fmt.Errorf("error creando backup: %v", err)
+			}
+		}
+		
+		// Los archivos que ya coinciden con el snapshot no se tocan
+		var keep map[string]bool
+		if !opts.ForceWrite {
+			keep = unchangedFiles(root, id, opts.Strip)
+		}
+		if err := moveFilesToTrashExcept(root, "pre_restore", id, keep); err != nil {
+			fmt.Printf("⚠️  No se pudieron mover archivos a papelera: %v\n", err)
+		}
+	}
+	
+	target := root
+	if !force {
+		target = restoreTarget(root, id, opts.OutDir)
+		if err := os.MkdirAll(target, 0o755); err != nil {
+			return err
+		}
+	}
+	
+	extract := restoreExtractOptions(root)
+	extract.Strip = opts.Strip
+	extract.SkipUnchanged = !opts.ForceWrite
+	extract.PreserveTimes = !opts.NoPreserveTimes
+	extract.AtomicFiles = !opts.NoAtomicFiles
+	if _, _, err := extractSnapshot(root, id, target, extract); err != nil {
+		return err
+	}
+	
+	if opts.VerifyAfter {
+		if err := verifyRestored(root, id, target, nil); err != nil {
+			return err
+		}
+	}
+	
+	if force {
+		recordReflog(root, "restore: "+id, currentHead(root), id)
+		logf("✅ Snapshot '%s' restaurado en directorio actual\n", id)
+		if !opts.NoBackup {
+			logln("   📝 Nota: Se creó un backup automático antes de la restauración")
+		}
+		logln("   🗑️  Los archivos anteriores fueron movidos a la papelera (.snapgo/trash)")
+	} else {
+		logf("✅ Snapshot '%s' restaurado en: %s\n", id, target)
+	}
+	
+	return nil
+}
+
+// Deshace el último snapshot de la rama actual: deja el directorio como su
+// snapshot padre y retira el último del índice. Los archivos actuales y el
+// snapshot retirado van a la papelera, de donde se pueden recuperar.
+func revertHead(root string) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	config, _ := loadConfig(root)
+	if !config.EnableTrash {
+		return fmt.Errorf("revert necesita la papelera (enable_trash) para poder deshacerse; usa 'restore PREV --force'")
+	}
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	branch := snapshotBranch(SnapshotMeta{Branch: idx.Current})
+	headID := branchHead(idx, branch)
+	if headID == "" {
+		return fmt.Errorf("la rama '%s' no tiene snapshots", branch)
+	}
+	
+	pos := -1
+	for i, s := range idx.Snapshots {
+		if s.ID == headID {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return fmt.Errorf("snapshot no encontrado: %s", headID)
+	}
+	head := idx.Snapshots[pos]
+	
+	if head.Parent == "" {
+		return fmt.Errorf("%s no tiene snapshot anterior al que volver", head.ID)
+	}
+	parent, err := findSnapshot(root, head.Parent)
+	if err != nil {
+		return err
+	}
+	if snapshotBranch(*parent) != branch {
+		return fmt.Errorf("el snapshot anterior (%s) es de la rama '%s': revert no cruza ramas", parent.ID, snapshotBranch(*parent))
+	}
+	if tags := tagsFor(idx, head.ID); len(tags) > 0 {
+		return fmt.Errorf("%s tiene etiquetas (%s); bórralas antes de hacer revert", head.ID, strings.Join(tags, ", "))
+	}
+	for _, s := range idx.Snapshots {
+		if s.Parent == head.ID {
+			return fmt.Errorf("otros snapshots dependen de %s; revert solo deshace el último", head.ID)
+		}
+	}
+	if deps := baseDependents(idx.Snapshots, head.ID); len(deps) > 0 {
+		return fmt.Errorf("%s es la base del incremental %s; revert no puede retirarlo", head.ID, strings.Join(deps, ", "))
+	}
+	
+	if err := checkSnapshotChain(root, parent.ID); err != nil {
+		return err
+	}
+	
+	if err := moveCurrentFilesToTrash(root, "revert", head.ID); err != nil {
+		return err
+	}
+	if _, _, err := extractSnapshot(root, parent.ID, root, restoreExtractOptions(root)); err != nil {
+		return err
+	}
+	
+	idx.Snapshots = append(idx.Snapshots[:pos], idx.Snapshots[pos+1:]...)
+	if idx.Branches == nil {
+		idx.Branches = map[string]string{}
+	}
+	idx.Branches[branch] = parent.ID
+	if err := writeJSON(indexPath, idx); err != nil {
+		return err
+	}
+	if err := trashSnapshots(root, "revert_snapshot", []SnapshotMeta{head}); err != nil {
+		return err
+	}
+	recordReflog(root, "revert: "+head.ID, head.ID, parent.ID)
+	
+	logf("↩️  Snapshot %s deshecho; directorio restaurado a %s\n", head.ID, parent.ID)
+	logln("   🗑️  El snapshot retirado y los archivos anteriores están en la papelera ('snapgo trash list')")
+	return nil
+}
+
+func rollbackCmdWithRoot(rootDir string) {
+	if len(os.Args) < 3 {
+		fmt.Println("Uso: rollback <id>")
+		return
+	}
+	must(rollback(rootDir, os.Args[2]))
+}
+
+// Vuelve al estado de un snapshot sin reescribir la historia: guarda el
+// estado actual, deja el directorio exactamente como el snapshot y registra
+// el resultado como un snapshot nuevo "Rollback a <id>".
+func rollback(root, ref string) error {
+	// Resolver antes del snapshot de seguridad, que desplaza HEAD~N
+	id, err := resolveSpecialID(root, ref)
+	if err != nil {
+		return err
+	}
+	
+	if err := checkSnapshotChain(root, id); err != nil {
+		return err
+	}
+	
+	// Extraer antes de tocar nada: así un snapshot dañado no deja el
+	// directorio vacío, y el contenido sobrevive aunque el snapshot de
+	// seguridad lo saque del índice por max_snapshots
+	snapgoDir, _, _, _, _, _ := repoPaths(root)
+	staging, err := os.MkdirTemp(snapgoDir, "rollback-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if _, _, err := extractSnapshot(root, id, staging, restoreExtractOptions(root)); err != nil {
+		return fmt.Errorf("no se pudo leer el snapshot %s: %v", id, err)
+	}
+	
+	logf("💾 Guardando el estado actual antes del rollback...\n")
+	// Un directorio vacío no necesita snapshot de seguridad
+	if err := snapshot(root, fmt.Sprintf("Antes de rollback a %s", id)); err != nil && err != errNoFiles {
+		return fmt.Errorf("error creando snapshot de seguridad: %v", err)
+	}
+	
+	config, _ := loadConfig(root)
+	if config.EnableTrash {
+		if err := moveCurrentFilesToTrash(root, "rollback", id); err != nil {
+			return err
+		}
+	} else {
+		// Sin papelera: el snapshot de seguridad ya conserva estos archivos
+		ignores, _ := loadIgnore(root)
+		files, err := collectFiles(root, ignores)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			os.Remove(filepath.Join(root, f))
+		}
+	}
+	
+	if err := moveTree(staging, root); err != nil {
+		return err
+	}
+	
+	// Volver a un snapshot vacío (snapshot --empty) también queda registrado
+	message := fmt.Sprintf("Rollback a %s", id)
+	err = snapshot(root, message)
+	if err == errNoFiles {
+		err = snapshotWithOptions(root, message, SnapshotOptions{Empty: true})
+	}
+	if err != nil {
+		return err
+	}
+	
+	logf("⏪ Rollback a '%s' completado\n", id)
+	return nil
+}
+
+// Mueve los archivos y enlaces de src a las mismas rutas bajo dst,
+// sustituyendo lo que haya. src y dst deben estar en el mismo sistema de
+// archivos.
+func moveTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	})
+}
+
+// Compara el hash de cada archivo restaurado en target con el de su entrada
+// en el snapshot. match limita la comprobación a algunas entradas (nil = todas).
+func verifyRestored(root, id, target string, match func(name string) bool) error {
+	expected, err := snapshotContentHashes(root, id)
+	if err != nil {
+		return err
+	}
+	
+	mismatches := []string{}
+	checked := 0
+	for _, name := range sortedKeys(expected) {
+		if match != nil && !match(name) {
+			continue
+		}
+		checked++
+		actual, err := hashFiles(target, []string{name}, false)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s (%v)", name, err))
+			continue
+		}
+		if actual[name] != expected[name] {
+			mismatches = append(mismatches, name)
+		}
+	}
+	
+	if len(mismatches) > 0 {
+		fmt.Println("❌ Verificación tras restaurar fallida:")
+		for _, m := range mismatches {
+			fmt.Printf("   • %s\n", m)
+		}
+		return fmt.Errorf("%d de %d archivo(s) no coinciden con el snapshot", len(mismatches), checked)
+	}
+	logf("🔍 Verificados %d archivo(s): coinciden con el snapshot\n", checked)
+	return nil
+}
+
+// Directorio de restauración cuando no se restaura en el sitio
+func restoreTarget(root, id, outDir string) string {
+	if outDir != "" {
+		return outDir
+	}
+	return filepath.Join(root, "_restore_"+id)
+}
+
+// Restaura solo las entradas que casan con opts.Only. Con --force se
+// escriben en el directorio actual (tras un backup) sin mover el resto a
+// la papelera; si no, en el directorio de restauración.
+func restoreOnly(root, id string, opts RestoreOptions) error {
+	target := restoreTarget(root, id, opts.OutDir)
+	if opts.Force {
+		target = root
+		if !opts.NoBackup {
+			if err := snapshot(root, opts.backupMessage(fmt.Sprintf("Backup antes de restaurar %s (--only %s)", id, opts.Only))); err != nil {
+				return fmt.Errorf("error creando backup: %v", err)
+			}
+		}
+	}
+	
+	extract := restoreExtractOptions(root)
+	extract.Strip = opts.Strip
+	extract.SkipUnchanged = !opts.ForceWrite
+	extract.PreserveTimes = !opts.NoPreserveTimes
+	extract.AtomicFiles = !opts.NoAtomicFiles
+	extract.Filter = func(hdr *tar.Header, outPath string) bool {
+		return matchGlob(opts.Only, hdr.Name)
+	}
+	restored, _, err := extractSnapshot(root, id, target, extract)
+	if err != nil {
+		return err
+	}
+	if restored == 0 {
+		return fmt.Errorf("ningún archivo de '%s' coincide con '%s'", id, opts.Only)
+	}
+	
+	if opts.VerifyAfter {
+		match := func(name string) bool { return matchGlob(opts.Only, name) }
+		if err := verifyRestored(root, id, target, match); err != nil {
+			return err
+		}
+	}
+	
+	logf("✅ %d archivo(s) de '%s' restaurados en: %s\n", restored, id, target)
+	return nil
+}
+
+// Restaura en el sitio sin tocar archivos existentes, salvo en modo
+// "newer" si la versión del snapshot es más reciente que la del disco
+func restoreMerge(root, id string, opts RestoreOptions) error {
+	mode := opts.Merge
+	extract := restoreExtractOptions(root)
+	extract.Strip = opts.Strip
+	extract.SkipUnchanged = !opts.ForceWrite
+	extract.PreserveTimes = !opts.NoPreserveTimes
+	extract.AtomicFiles = !opts.NoAtomicFiles
+	wants := mergeFilter(mode)
+	overwritten := 0
+	extract.Filter = func(hdr *tar.Header, outPath string) bool {
+		if !wants(hdr, outPath) {
+			return false
+		}
+		if _, err := os.Lstat(outPath); err == nil {
+			overwritten++
+		}
+		return true
+	}
+	merged, skipped, err := extractSnapshot(root, id, root, extract)
+	if err != nil {
+		return err
+	}
+	
+	logf("✅ Snapshot '%s' fusionado en el directorio actual\n", id)
+	if mode == "newer" {
+		logf("   📥 Creados: %d\n", merged-overwritten)
+		logf("   ✏️  Sobrescritos (el snapshot era más reciente): %d\n", overwritten)
+		logf("   ⏭️  Conservados (la versión del disco es igual o más reciente): %d\n", skipped)
+		return nil
+	}
+	logf("   📥 Restaurados: %d\n", merged)
+	logf("   ⏭️  Omitidos (ya existen): %d\n", skipped)
+	return nil
+}
+
+// Entradas que escribe --merge: las que faltan y, en modo "newer", las
+// más recientes que el archivo del disco
+func mergeFilter(mode string) func(hdr *tar.Header, outPath string) bool {
+	return func(hdr *tar.Header, outPath string) bool {
+		info, err := os.Stat(outPath)
+		if os.IsNotExist(err) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		// Los tiempos del tar se redondean al segundo
+		return mode == "newer" && hdr.ModTime.Round(time.Second).After(info.ModTime().Round(time.Second))
+	}
+}
+
+// Vista previa de un restore: clasifica, sin escribir nada, los archivos
+// que se crearían, se sobrescribirían y (con --force) se eliminarían
+func restoreDryRun(root, id string, opts RestoreOptions) error {
+	target := restoreTarget(root, id, opts.OutDir)
+	if opts.Force || opts.Merge != "" {
+		target = root
+	}
+	
+	headers, err := snapshotHeaders(root, id)
+	if err != nil {
+		return err
+	}
+	
+	var created, overwritten, deleted []string
+	inSnapshot := map[string]bool{}
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := stripComponents(hdr.Name, opts.Strip)
+		if name == "" {
+			continue
+		}
+		inSnapshot[name] = true
+		if opts.Only != "" && !matchGlob(opts.Only, hdr.Name) {
+			continue
+		}
+		outPath := filepath.Join(target, filepath.FromSlash(name))
+		if opts.Merge != "" && !mergeFilter(opts.Merge)(hdr, outPath) {
+			continue
+		}
+		if _, err := os.Lstat(outPath); err == nil {
+			overwritten = append(overwritten, name)
+		} else {
+			created = append(created, name)
+		}
+	}
+	
+	// Solo el restore completo con --force quita los archivos actuales
+	if opts.Force && opts.Only == "" && opts.Merge == "" {
+		ignores, _ := loadIgnore(root)
+		current, err := collectFiles(root, ignores)
+		if err != nil {
+			return err
+		}
+		for _, f := range current {
+			if !inSnapshot[f] {
+				deleted = append(deleted, f)
+			}
+		}
+	}
+	
+	fmt.Printf("🔎 Vista previa: restaurar '%s' en %s\n", id, target)
+	printDryRunGroup("➕ Se crearían", created)
+	printDryRunGroup("✏️  Se sobrescribirían", overwritten)
+	if opts.Force && opts.Only == "" && opts.Merge == "" {
+		printDryRunGroup("➖ Se eliminarían (a la papelera si está activada)", deleted)
+	}
+	fmt.Println("\n💡 Modo --dry-run: no se modificó nada")
+	return nil
+}
+
+func printDryRunGroup(title string, files []string) {
+	fmt.Printf("\n%s (%d):\n", title, len(files))
+	for _, f := range files {
+		fmt.Printf("   • %s\n", f)
+	}
+}
+
+// Mueve los archivos actuales a la papelera. snapshotID indica el snapshot
+// que provocó el movimiento (vacío si no aplica).
+func moveCurrentFilesToTrash(root, reason, snapshotID string) error {
+	return moveFilesToTrashExcept(root, reason, snapshotID, nil)
+}
+
+// Como moveCurrentFilesToTrash, pero deja en su sitio los archivos de keep
+func moveFilesToTrashExcept(root, reason, snapshotID string, keep map[string]bool) error {
+	_, _, _, _, _, trashDir := repoPaths(root)
+	
+	config, err := loadConfig(root)
+	if err != nil || !config.EnableTrash {
+		return nil
+	}
+	
+	now := time.Now()
+	trashSubdir := newTrashSubdir(trashDir, now.Format("20060102_150405")+"_"+reason)
+	
+	if err := os.MkdirAll(trashSubdir, 0o755); err != nil {
+		return err
+	}
+	
+	ignores, _ := loadIgnore(root)
+	currentFiles, err := collectFiles(root, ignores)
+	if err != nil {
+		return err
+	}
+	
+	movedCount := 0
+	for _, file := range currentFiles {
+		if keep[file] {
+			continue
+		}
+		src := filepath.Join(root, file)
+		dst := filepath.Join(trashSubdir, file)
+		
+		dstDir := filepath.Dir(dst)
+		if err := os.MkdirAll(dstDir, 0o755); err != nil {
+			continue
+		}
+		
+		if err := os.Rename(src, dst); err == nil {
+			movedCount++
+		}
+	}
+	
+	// Los metadatos van los últimos: marcan que la entrada está completa
+	meta := TrashMeta{
+		Reason:     reason,
+		SnapshotID: snapshotID,
+		FileCount:  movedCount,
+		Timestamp:  now.Format(time.RFC3339),
+	}
+	if err := writeJSON(filepath.Join(trashSubdir, trashMetaFile), meta); err != nil {
+		return err
+	}
+	
+	if movedCount > 0 {
+		logf("📦 %d archivos movidos a papelera: %s\n", movedCount, trashSubdir)
+	}
+	
+	return nil
+}
+
+// Opciones de extracción de un .tar.gz
+type extractOptions struct {
+	Filter        func(hdr *tar.Header, outPath string) bool // nil extrae todas las entradas
+	PreserveOwner bool                                       // Aplicar el uid/gid guardado en el tar
+	Strip         int                                        // Quitar N componentes iniciales de cada ruta
+	SkipUnchanged bool                                       // No reescribir archivos cuyo contenido ya coincide
+	PreserveTimes bool                                       // Aplicar la fecha de modificación guardada en el tar
+	AtomicFiles   bool                                       // Escribir en un temporal y renombrarlo encima del destino
+}
+
+// Archivos del directorio de trabajo cuyo contenido ya coincide con su
+// entrada en el snapshot, con las rutas tal como quedarán tras quitar strip
+// componentes
+func unchangedFiles(root, id string, strip int) map[string]bool {
+	snap, err := findSnapshot(root, id)
+	if err != nil {
+		return nil
+	}
+	expected, err := snapshotContentHashes(root, id)
+	if err != nil {
+		return nil
+	}
+	same := map[string]bool{}
+	for name, sum := range expected {
+		name = stripComponents(name, strip)
+		if name == "" {
+			continue
+		}
+		if actual, err := hashFiles(root, []string{name}, snap.FollowSymlinks); err == nil && actual[name] == sum {
+			same[name] = true
+		}
+	}
+	return same
+}
+
+// Quita los n primeros componentes de una ruta del tar, como
+// tar --strip-components. Devuelve "" si la ruta no tiene más de n.
+func stripComponents(name string, n int) string {
+	if n <= 0 {
+		return name
+	}
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if len(parts) <= n {
+		return ""
+	}
+	return strings.Join(parts[n:], "/")
+}
+
+// Opciones de extracción para restaurar en un repositorio, según su configuración
+func restoreExtractOptions(root string) extractOptions {
+	config, _ := loadConfig(root)
+	return extractOptions{PreserveOwner: config.PreserveOwnership, PreserveTimes: true, AtomicFiles: true}
+}
+
+// Extrae solo las entradas para las que opts.Filter devuelve true.
+// Devuelve cuántas se extrajeron y cuántas se omitieron.
+func extractTarGzFiltered(archive, target string, opts extractOptions) (extracted, skipped int, err error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer gr.Close()
+	
+	// Hashes de las entradas, para no reescribir archivos que ya coinciden
+	var expected map[string]string
+	if opts.SkipUnchanged {
+		expected, _ = hashArchiveEntries(archive)
+	}
+	
+	ownerFailures := 0
+	unchanged := 0
+	// Enlaces creados en esta extracción: un archivo importado podría traer
+	// "dir -> /etc" seguido de "dir/passwd" para escribir fuera de target
+	links := map[string]bool{}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return extracted, skipped, err
+		}
+		
+		name := stripComponents(hdr.Name, opts.Strip)
+		if name == "" {
+			skipped++
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			return extracted, skipped, fmt.Errorf("ruta no válida en el archivo: %s", hdr.Name)
+		}
+		outPath := filepath.Join(target, name)
+		if opts.Filter != nil && !opts.Filter(hdr, outPath) {
+			skipped++
+			continue
+		}
+		for dir := filepath.Dir(filepath.Clean(name)); dir != "."; dir = filepath.Dir(dir) {
+			if links[dir] {
+				return extracted, skipped, fmt.Errorf("%s pasa por el enlace simbólico %s; no se extrae", hdr.Name, dir)
+			}
+		}
+		
+		if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+			return extracted, skipped, err
+		}
+		
+		if hdr.Typeflag == tar.TypeSymlink {
+			os.Remove(outPath)
+			if err := os.Symlink(hdr.Linkname, outPath); err != nil {
+				return extracted, skipped, err
+			}
+			links[filepath.Clean(name)] = true
+			extracted++
+			continue
+		}
+		
+		// Un enlace en el sitio de un archivo se sustituye, no se escribe a
+		// través de él
+		if info, err := os.Lstat(outPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(outPath); err != nil {
+				return extracted, skipped, err
+			}
+		}
+		
+		if expected != nil && hdr.Typeflag == tar.TypeReg {
+			if info, err := os.Lstat(outPath); err == nil && info.Mode().IsRegular() && info.Size() == hdr.Size {
+				if sum, err := fileSHA256(outPath); err == nil && sum == expected[hdr.Name] {
+					unchanged++
+					extracted++
+					continue
+				}
+			}
+		}
+		
+		mode := hdr.FileInfo().Mode().Perm()
+		if mode == 0 {
+			mode = 0o644
+		}
+		if err := writeExtractedFile(outPath, tr, opts.AtomicFiles, mode); err != nil {
+			return extracted, skipped, err
+		}
+		extracted++
+		
+		if opts.PreserveTimes {
+			os.Chtimes(outPath, hdr.ModTime, hdr.ModTime)
+		}
+		if opts.PreserveOwner && runtime.GOOS != "windows" {
+			if err := os.Chown(outPath, hdr.Uid, hdr.Gid); err != nil {
+				ownerFailures++
+			}
+		}
+	}
+	
+	if unchanged > 0 {
+		logf("⏭️  %d archivo(s) ya estaban al día (no se reescribieron)\n", unchanged)
+	}
+	if ownerFailures > 0 {
+		fmt.Printf("⚠️  No se pudo restaurar el propietario de %d archivo(s) (se necesitan permisos de root)\n", ownerFailures)
+	}
+	return extracted, skipped, nil
+}
+
+// Escribe un archivo extraído. Con atomic se escribe primero en un
+// temporal .<nombre>.snapgo-* junto al destino y se renombra encima, así que
+// una restauración interrumpida deja el archivo anterior o el nuevo, nunca
+// uno a medias (y no pisa un <nombre>.tmp del usuario). Un archivo que se
+// sobrescribe conserva sus permisos; uno nuevo recibe mode.
+func writeExtractedFile(outPath string, r io.Reader, atomic bool, mode os.FileMode) error {
+	var out *os.File
+	var err error
+	if atomic {
+		if info, statErr := os.Stat(outPath); statErr == nil && info.Mode().IsRegular() {
+			mode = info.Mode().Perm()
+		}
+		out, err = os.CreateTemp(filepath.Dir(outPath), "."+filepath.Base(outPath)+".snapgo-*")
+		if err == nil {
+			err = out.Chmod(mode)
+		}
+	} else {
+		out, err = os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	}
+	if err != nil {
+		if out != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+		return err
+	}
+	path := out.Name()
+	_, err = io.Copy(out, r)
+	if err == nil && atomic {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && atomic {
+		err = os.Rename(path, outPath)
+	}
+	if err != nil && atomic {
+		os.Remove(path)
+	}
+	return err
+}
+
+// Nueva versión de diffCmd que acepta directorio raíz
+func diffCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	dir := fs.String("dir", "", "comparar el snapshot con otro directorio")
+	working := fs.Bool("working", false, "comparar el snapshot con el directorio de trabajo")
+	summaryOnly := fs.Bool("summary-only", false, "solo una línea de resumen; sale con 2 si hay diferencias")
+	patch := fs.Bool("patch", false, "mostrar el contenido cambiado de los archivos modificados")
+	fs.BoolVar(patch, "p", false, "alias de --patch")
+	wordDiff := fs.Bool("word-diff", false, "como --patch, resaltando las palabras cambiadas dentro de cada línea")
+	output := fs.String("output", "", "escribir el diff en un archivo en vez de en pantalla")
+	noColor := fs.Bool("no-color", false, "desactivar los colores")
+	reverse := fs.Bool("reverse", false, "invertir el sentido (del más reciente al más antiguo)")
+	ignoreSpace := fs.Bool("ignore-whitespace", false, "ignorar cambios solo de espacios en blanco")
+	fs.BoolVar(ignoreSpace, "w", false, "alias de --ignore-whitespace")
+	var only stringsFlag
+	fs.Var(&only, "only", "limitar el diff a las rutas que casen con el patrón (repetible, admite **)")
+	asJSON := fs.Bool("json", false, "salida en JSON con hashes y tamaños de cada archivo")
+	binarySize := fs.Bool("binary-size", false, "mostrar cuánto cambió el tamaño de los binarios modificados")
+	contextLines := fs.Int("context", diffContext, "líneas de contexto alrededor de cada cambio (con --patch)")
+	fs.IntVar(contextLines, "U", diffContext, "alias de --context")
+	args := parseArgs(fs, os.Args[2:])
+	
+	attrs, err := loadAttributes(rootDir)
+	must(err)
+	
+	if *contextLines < 0 {
+		fmt.Println("Uso: --context <n> con n >= 0")
+		return
+	}
+	
+	opts := DiffOptions{
+		Context:     *contextLines,
+		Attrs:       attrs,
+		BinarySize:  *binarySize,
+		JSON:        *asJSON,
+		Only:        only,
+		IgnoreSpace: *ignoreSpace,
+		Reverse:     *reverse,
+		SummaryOnly: *summaryOnly,
+		Patch:       *patch || *wordDiff,
+		WordDiff:    *wordDiff,
+		Color:       *output == "" && useColor(os.Stdout, *noColor),
+		Plain:       *output != "" && *patch && !*wordDiff,
+	}
+	if *output != "" {
+		if err := os.MkdirAll(filepath.Dir(*output), 0o755); err != nil {
+			must(err)
+		}
+		f, err := os.Create(*output)
+		must(err)
+		defer f.Close()
+		opts.Out = f
+	}
+	// Con un solo snapshot (o ninguno, que equivale a HEAD) se compara
+	// con el directorio de trabajo
+	if *working || (*dir == "" && len(args) < 2) {
+		*dir = rootDir
+	}
+	if *dir == rootDir && len(args) == 0 {
+		args = []string{"HEAD"}
+	}
+	if *dir == rootDir {
+		must(requireWorkingTree(rootDir, "diff"))
+	}
+	
+	var res DiffResult
+	if *dir != "" {
+		if len(args) < 1 {
+			fmt.Println("Uso: diff <id> --dir <ruta>")
+			return
+		}
+		res, err = diffSnapshotWithDir(rootDir, args[0], *dir, opts)
+	} else {
+		res, err = diffSnapshots(rootDir, args[0], args[1], opts)
+	}
+	must(err)
+	
+	if *output != "" {
+		logf("✅ Diff guardado en %s\n", *output)
+	}
+	
+	// Para CI: código de salida distinto de cero si hay diferencias
+	if opts.SummaryOnly && !res.Empty() {
+		os.Exit(exitDiffFound)
+	}
+}
+
+// Código de salida de 'diff --summary-only' cuando hay diferencias (0 = sin
+// diferencias). No es 1 porque ese código ya lo usa must para los errores,
+// y así un fallo no se confunde con "hay cambios".
+const exitDiffFound = 2
+
+// Opciones de presentación del diff
+type DiffOptions struct {
+	SummaryOnly bool     // Solo imprimir "N añadidos, N eliminados, N modificados"
+	Patch       bool     // Mostrar el diff de líneas de los archivos modificados
+	WordDiff    bool     // Diff de contenido a nivel de palabra
+	Color       bool     // Colores ANSI (solo en terminal)
+	Reverse     bool     // Invertir el sentido: del más reciente al más antiguo
+	IgnoreSpace bool     // Ignorar cambios solo de espacios en blanco (-w)
+	Only        []string // Limitar el diff a las rutas que casan con estos patrones (admite **)
+	JSON        bool     // Salida estructurada con hashes, tamaños y renombrados
+	BinarySize  bool     // Tamaño anterior, nuevo y diferencia de los binarios modificados
+	Context     int      // Líneas sin cambios alrededor de cada cambio (-U)
+	
+	Attrs fileAttrs // .snapgoattributes: binary/text deciden cómo se compara cada archivo
+	
+	Out   io.Writer // Destino de la salida (nil = stdout)
+	Plain bool      // Solo el diff unificado, sin cabeceras (--patch --output)
+}
+
+func (o DiffOptions) writer() io.Writer {
+	if o.Out == nil {
+		return os.Stdout
+	}
+	return o.Out
+}
+
+// Resultado de comparar dos conjuntos de archivos
+type DiffResult struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// Restringe el resultado a las rutas que casan con alguno de los patrones
+// (sin patrones, lo devuelve tal cual)
+func (r DiffResult) only(globs []string) DiffResult {
+	if len(globs) == 0 {
+		return r
+	}
+	keep := func(names []string) []string {
+		out := []string{}
+		for _, name := range names {
+			for _, g := range globs {
+				if matchGlob(g, name) {
+					out = append(out, name)
+					break
+				}
+			}
+		}
+		return out
+	}
+	return DiffResult{Added: keep(r.Added), Removed: keep(r.Removed), Modified: keep(r.Modified)}
+}
+
+// Todas las rutas del resultado, ordenadas
+func (r DiffResult) names() []string {
+	names := append(append(append([]string{}, r.Added...), r.Removed...), r.Modified...)
+	sort.Strings(names)
+	return names
+}
+
+func (r DiffResult) Empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Modified) == 0
+}
+
+func (r DiffResult) Summary() string {
+	return fmt.Sprintf("%d añadidos, %d eliminados, %d modificados",
+		len(r.Added), len(r.Removed), len(r.Modified))
+}
+
+func diffSnapshots(root, id1, id2 string, opts DiffOptions) (DiffResult, error) {
+	w := opts.writer()
+	id1, err := resolveSpecialID(root, id1)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	id2, err = resolveSpecialID(root, id2)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	
+	if id1 == id2 {
+		if opts.JSON {
+			return DiffResult{}, writeDiffJSON(w, id1, id2, DiffResult{}, diffSide{}, diffSide{})
+		}
+		if opts.SummaryOnly {
+			fmt.Fprintln(w, DiffResult{}.Summary())
+			return DiffResult{}, nil
+		}
+		if opts.Plain {
+			return DiffResult{}, nil
+		}
+		fmt.Fprintln(w, "ℹ️  Ambos snapshots son el mismo:")
+		fmt.Fprintf(w, "   🆔 ID: %s\n", id1)
+		fmt.Fprintln(w, "   📊 Resultado: No hay diferencias")
+		return DiffResult{}, nil
+	}
+	
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return DiffResult{}, fmt.Errorf("error leyendo índice: %v", err)
+	}
+	
+	if len(idx.Snapshots) == 0 {
+		return DiffResult{}, fmt.Errorf("no hay snapshots disponibles")
+	}
+	
+	// La ayuda es solo para personas; --json, --summary-only y el parche de
+	// --output siguen y fallan con "no encontrado" como cualquier otro ID
+	if len(idx.Snapshots) == 1 && !opts.JSON && !opts.SummaryOnly && !opts.Plain {
+		fmt.Fprintln(w, "ℹ️  Solo hay 1 snapshot disponible:")
+		fmt.Fprintf(w, "   🆔 ID: %s\n", idx.Snapshots[0].ID)
+		fmt.Fprintf(w, "   📝 Mensaje: %s\n", idx.Snapshots[0].Message)
+		fmt.Fprintln(w, "   💡 Crea otro snapshot para poder comparar")
+		return DiffResult{}, nil
+	}
+	
+	var snap1, snap2 *SnapshotMeta
+	for _, s := range idx.Snapshots {
+		if s.ID == id1 {
+			snap1 = &s
+		}
+		if s.ID == id2 {
+			snap2 = &s
+		}
+	}
+	
+	if snap1 == nil {
+		return DiffResult{}, fmt.Errorf("snapshot '%s' no encontrado", id1)
+	}
+	if snap2 == nil {
+		return DiffResult{}, fmt.Errorf("snapshot '%s' no encontrado", id2)
+	}
+	
+	var older, newer *SnapshotMeta
+	time1, err1 := time.Parse(time.RFC3339, snap1.Timestamp)
+	time2, err2 := time.Parse(time.RFC3339, snap2.Timestamp)
+	
+	if err1 != nil || err2 != nil {
 		for i, s := range idx.Snapshots {
 			if s.ID == id1 {
 				older = snap1
@@ -981,312 +5038,5412 @@ func diffSnapshots(root, id1, id2 string) error {
 					older = snap2
 					newer = snap1
 				}
-				break
+				break
+			}
+		}
+	} else if time1.Before(time2) {
+		older = snap1
+		newer = snap2
+	} else {
+		older = snap2
+		newer = snap1
+	}
+	
+	// --reverse: qué hay que hacer para volver del más reciente al más antiguo
+	if opts.Reverse {
+		older, newer = newer, older
+	}
+	
+	res, hashed := snapshotDiff(root, older, newer)
+	res = res.only(opts.Only)
+	if opts.IgnoreSpace && hashed {
+		dropSpaceOnlyChanges(&res, opts.Attrs,
+			snapshotSource(root, older.ID, res.Modified),
+			snapshotSource(root, newer.ID, res.Modified))
+	}
+	
+	if opts.JSON {
+		return res, writeDiffJSON(w, older.ID, newer.ID, res,
+			snapshotDiffSide(root, older.ID),
+			snapshotDiffSide(root, newer.ID))
+	}
+	
+	if opts.SummaryOnly {
+		fmt.Fprintln(w, res.Summary())
+		return res, nil
+	}
+	
+	if opts.Plain {
+		if !hashed {
+			return res, fmt.Errorf("no se pudo leer el contenido de los snapshots para el parche")
+		}
+		changed := res.names()
+		printPlainPatch(w, res,
+			snapshotSource(root, older.ID, changed),
+			snapshotSource(root, newer.ID, changed), opts)
+		return res, nil
+	}
+	
+	fmt.Fprintf(w, "📊 Comparación: %s → %s\n", older.ID, newer.ID)
+	fmt.Fprintf(w, "📅 Fecha: %s → %s\n",
+		formatTime(older.Timestamp), 
+		formatTime(newer.Timestamp))
+	fmt.Fprintf(w, "📝 Mensajes: \"%s\" → \"%s\"\n",
+		older.Message, newer.Message)
+	
+	printDiffResult(w, res, opts.Color)
+	
+	if opts.BinarySize && hashed {
+		printBinarySizes(w, opts.Attrs, res.Modified,
+			snapshotSource(root, older.ID, res.Modified),
+			snapshotSource(root, newer.ID, res.Modified))
+	}
+	
+	if opts.Patch && hashed {
+		printContentDiffs(w, res.Modified,
+			snapshotSource(root, older.ID, res.Modified),
+			snapshotSource(root, newer.ID, res.Modified), opts)
+	}
+	
+	if !hashed {
+		common := len(older.Files) - len(res.Removed)
+		if common > 0 && (len(res.Added) > 0 || len(res.Removed) > 0) {
+			fmt.Fprintf(w, "\n🔸 %d archivos en ambos snapshots (podrían estar modificados)\n", common)
+		}
+		if len(res.Added) == 0 && len(res.Removed) == 0 {
+			fmt.Fprintln(w, "\n✅ No hay diferencias en la lista de archivos")
+		}
+	} else if res.Empty() {
+		fmt.Fprintln(w, "\n✅ No hay diferencias")
+	}
+	
+	return res, nil
+}
+
+// Compara dos snapshots por hash de contenido. Si algún archivo de snapshot
+// no se puede leer, solo compara la lista de archivos y hashed es false.
+func snapshotDiff(root string, older, newer *SnapshotMeta) (res DiffResult, hashed bool) {
+	olderHashes, err1 := snapshotContentHashes(root, older.ID)
+	newerHashes, err2 := snapshotContentHashes(root, newer.ID)
+	if err1 == nil && err2 == nil {
+		res.Added, res.Removed, res.Modified = compareFileHashes(olderHashes, newerHashes)
+		return res, true
+	}
+	
+	loadSnapshotFiles(root, older)
+	loadSnapshotFiles(root, newer)
+	
+	olderNames := make(map[string]string)
+	for _, f := range older.Files {
+		olderNames[f] = ""
+	}
+	newerNames := make(map[string]string)
+	for _, f := range newer.Files {
+		newerNames[f] = ""
+	}
+	res.Added, res.Removed, _ = compareFileHashes(olderNames, newerNames)
+	return res, false
+}
+
+// Salida de 'diff --json'
+type diffJSON struct {
+	From     string             `json:"from"`
+	To       string             `json:"to"`
+	Added    []diffFileJSON     `json:"added"`
+	Removed  []diffFileJSON     `json:"removed"`
+	Modified []diffModifiedJSON `json:"modified"`
+	Renamed  []diffRenamedJSON  `json:"renamed"`
+}
+
+type diffFileJSON struct {
+	Path string `json:"path,omitempty"`
+	Hash string `json:"hash,omitempty"`
+	Size int64  `json:"size"`
+}
+
+type diffModifiedJSON struct {
+	Path string       `json:"path"`
+	Old  diffFileJSON `json:"old"`
+	New  diffFileJSON `json:"new"`
+}
+
+type diffRenamedJSON struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Hash y tamaño de cada ruta de uno de los lados del diff
+type diffSide struct {
+	hashes map[string]string
+	sizes  map[string]int64
+}
+
+func (d diffSide) file(path string) diffFileJSON {
+	return diffFileJSON{Hash: d.hashes[path], Size: d.sizes[path]}
+}
+
+// Lado de un diff leído de un snapshot. Si no se puede leer, los archivos
+// salen sin hash ni tamaño.
+func snapshotDiffSide(root, id string) diffSide {
+	side := diffSide{hashes: map[string]string{}, sizes: map[string]int64{}}
+	walkSnapshotEntries(root, id, func(hdr *tar.Header, r io.Reader) error {
+		h := sha256.New()
+		if hdr.Typeflag == tar.TypeSymlink {
+			h.Write([]byte(hdr.Linkname))
+		} else if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		side.hashes[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+		side.sizes[hdr.Name] = hdr.Size
+		return nil
+	})
+	return side
+}
+
+// Tamaño de cada archivo (los enlaces simbólicos, el del propio enlace)
+func fileSizes(base string, files []string) map[string]int64 {
+	sizes := make(map[string]int64, len(files))
+	for _, rel := range files {
+		if info, err := os.Lstat(filepath.Join(base, rel)); err == nil {
+			sizes[rel] = info.Size()
+		}
+	}
+	return sizes
+}
+
+// Escribe el diff en JSON. Un archivo eliminado y otro añadido con el mismo
+// contenido se listan como renombrado en lugar de en added/removed.
+func writeDiffJSON(w io.Writer, fromLabel, toLabel string, res DiffResult, from, to diffSide) error {
+	out := diffJSON{
+		From:     fromLabel,
+		To:       toLabel,
+		Added:    []diffFileJSON{},
+		Removed:  []diffFileJSON{},
+		Modified: []diffModifiedJSON{},
+		Renamed:  []diffRenamedJSON{},
+	}
+	
+	removedByHash := make(map[string][]string)
+	for _, path := range res.Removed {
+		if h := from.hashes[path]; h != "" {
+			removedByHash[h] = append(removedByHash[h], path)
+		}
+	}
+	renamed := make(map[string]bool)
+	for _, path := range res.Added {
+		file := to.file(path)
+		if sources := removedByHash[file.Hash]; file.Hash != "" && len(sources) > 0 {
+			removedByHash[file.Hash] = sources[1:]
+			renamed[sources[0]] = true
+			out.Renamed = append(out.Renamed, diffRenamedJSON{From: sources[0], To: path, Hash: file.Hash, Size: file.Size})
+			continue
+		}
+		file.Path = path
+		out.Added = append(out.Added, file)
+	}
+	for _, path := range res.Removed {
+		if renamed[path] {
+			continue
+		}
+		file := from.file(path)
+		file.Path = path
+		out.Removed = append(out.Removed, file)
+	}
+	for _, path := range res.Modified {
+		out.Modified = append(out.Modified, diffModifiedJSON{Path: path, Old: from.file(path), New: to.file(path)})
+	}
+	
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func printDiffResult(w io.Writer, res DiffResult, color bool) {
+	if len(res.Added) > 0 {
+		fmt.Fprintln(w, "\n➕ Archivos añadidos:")
+		for _, f := range res.Added {
+			fmt.Fprintf(w, "   • %s\n", colorize(color, ansiGreen, f))
+		}
+	}
+	
+	if len(res.Removed) > 0 {
+		fmt.Fprintln(w, "\n➖ Archivos eliminados:")
+		for _, f := range res.Removed {
+			fmt.Fprintf(w, "   • %s\n", colorize(color, ansiRed, f))
+		}
+	}
+	
+	if len(res.Modified) > 0 {
+		fmt.Fprintln(w, "\n✏️  Archivos modificados:")
+		for _, f := range res.Modified {
+			fmt.Fprintf(w, "   • %s\n", colorize(color, ansiYellow, f))
+		}
+	}
+}
+
+// Colores ANSI de la salida del diff
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiCyan   = "\033[36m"
+)
+
+func colorize(on bool, code, s string) string {
+	if !on {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// Colores solo en una terminal y si no se desactivan con --no-color o NO_COLOR
+func useColor(f *os.File, noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Límites del diff de contenido: por encima de maxDiffCells (líneas × líneas)
+// no se calcula el diff, y cada hunk lleva por defecto diffContext líneas de
+// contexto (--context)
+const (
+	maxDiffCells = 4000000
+	diffContext  = 3
+)
+
+// Devuelve el contenido de un archivo del lado correspondiente del diff
+type contentSource func(name string) ([]byte, error)
+
+// Lee de una sola pasada las entradas indicadas de un snapshot
+func snapshotSource(root, id string, names []string) contentSource {
+	var contents map[string][]byte
+	var readErr error
+	return func(name string) ([]byte, error) {
+		if contents == nil && readErr == nil {
+			contents, readErr = readSnapshotEntries(root, id, names)
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+		data, ok := contents[name]
+		if !ok {
+			return nil, fmt.Errorf("'%s' no está en el snapshot", name)
+		}
+		return data, nil
+	}
+}
+
+func dirSource(dir string) contentSource {
+	return func(name string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	}
+}
+
+func readSnapshotEntries(root, id string, names []string) (map[string][]byte, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, n := range names {
+		wanted[n] = true
+	}
+	
+	contents := make(map[string][]byte)
+	err := walkSnapshotEntries(root, id, func(hdr *tar.Header, r io.Reader) error {
+		if !wanted[hdr.Name] {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		contents[hdr.Name] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+func printContentDiffs(w io.Writer, names []string, older, newer contentSource, opts DiffOptions) {
+	for _, name := range names {
+		a, err := older(name)
+		if err == nil {
+			var b []byte
+			b, err = newer(name)
+			if err == nil {
+				printFileDiff(w, name, a, b, opts)
+				continue
+			}
+		}
+		fmt.Fprintf(w, "\n⚠️  %s: %v\n", name, err)
+	}
+}
+
+// Para los binarios modificados (no tiene sentido un diff de líneas)
+// muestra el tamaño anterior, el nuevo y la diferencia
+func printBinarySizes(w io.Writer, attrs fileAttrs, names []string, older, newer contentSource) {
+	header := false
+	for _, name := range names {
+		a, errA := older(name)
+		b, errB := newer(name)
+		if errA != nil || errB != nil || !attrs.binary(name, a, b) {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "\n📦 Binarios modificados:")
+			header = true
+		}
+		before, after := int64(len(a)), int64(len(b))
+		fmt.Fprintf(w, "   %s: %s → %s (%s)\n", name, formatSize(before), formatSize(after), formatSizeDelta(after-before))
+	}
+}
+
+// Diferencia de tamaño con signo, p. ej. "+6.0 KB" o "-512 B"
+func formatSizeDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + formatSize(-delta)
+	}
+	return "+" + formatSize(delta)
+}
+
+// Un archivo se trata como binario si tiene bytes nulos al principio
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// Diff unificado sin cabeceras ni emojis, que se puede aplicar con patch o
+// git apply. Los archivos añadidos y eliminados van contra /dev/null.
+func printPlainPatch(w io.Writer, res DiffResult, older, newer contentSource, opts DiffOptions) {
+	added := map[string]bool{}
+	for _, name := range res.Added {
+		added[name] = true
+	}
+	removed := map[string]bool{}
+	for _, name := range res.Removed {
+		removed[name] = true
+	}
+	
+	for _, name := range res.names() {
+		var a, b []byte
+		var err error
+		oldName, newName := "a/"+name, "b/"+name
+		if added[name] {
+			oldName = "/dev/null"
+		} else {
+			a, err = older(name)
+		}
+		if removed[name] {
+			newName = "/dev/null"
+		} else if err == nil {
+			b, err = newer(name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  %s: %v\n", name, err)
+			continue
+		}
+		printUnifiedDiff(w, name, oldName, newName, a, b, opts)
+	}
+}
+
+func printFileDiff(w io.Writer, name string, a, b []byte, opts DiffOptions) {
+	fmt.Fprintln(w)
+	printUnifiedDiff(w, name, "a/"+name, "b/"+name, a, b, opts)
+}
+
+func printUnifiedDiff(w io.Writer, name, oldName, newName string, a, b []byte, opts DiffOptions) {
+	fmt.Fprintln(w, colorize(opts.Color, ansiBold, "--- "+oldName))
+	fmt.Fprintln(w, colorize(opts.Color, ansiBold, "+++ "+newName))
+	if opts.Attrs.binary(name, a, b) {
+		fmt.Fprintln(w, "Los archivos binarios son distintos")
+		return
+	}
+	
+	oldLines := splitLines(string(a))
+	newLines := splitLines(string(b))
+	if len(oldLines)*len(newLines) > maxDiffCells {
+		fmt.Fprintln(w, "Archivo demasiado grande para mostrar el diff")
+		return
+	}
+	
+	var ops []diffOp
+	if opts.IgnoreSpace {
+		ops = withOriginalText(diffTokens(normalizeSpaceLines(oldLines), normalizeSpaceLines(newLines)), oldLines, newLines)
+	} else {
+		ops = diffTokens(oldLines, newLines)
+	}
+	for _, h := range diffHunks(ops, opts.Context) {
+		header := fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.oldStart, h.oldCount, h.newStart, h.newCount)
+		fmt.Fprintln(w, colorize(opts.Color, ansiCyan, header))
+		if opts.WordDiff {
+			printWordDiffHunk(w, ops[h.from:h.to], opts.Color)
+			continue
+		}
+		for _, op := range ops[h.from:h.to] {
+			line := string(op.kind) + op.text
+			switch op.kind {
+			case '-':
+				line = colorize(opts.Color, ansiRed, line)
+			case '+':
+				line = colorize(opts.Color, ansiGreen, line)
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// Colapsa los espacios de cada línea y quita los de los extremos
+func normalizeSpaceLines(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = strings.Join(strings.Fields(l), " ")
+	}
+	return out
+}
+
+// Sustituye el texto normalizado de las operaciones por las líneas originales
+func withOriginalText(ops []diffOp, a, b []string) []diffOp {
+	i, j := 0, 0
+	for k := range ops {
+		switch ops[k].kind {
+		case ' ':
+			ops[k].text = b[j]
+			i++
+			j++
+		case '-':
+			ops[k].text = a[i]
+			i++
+		case '+':
+			ops[k].text = b[j]
+			j++
+		}
+	}
+	return ops
+}
+
+// Con -w, quita de res.Modified los archivos de texto que solo difieren
+// en espacios en blanco
+func dropSpaceOnlyChanges(res *DiffResult, attrs fileAttrs, older, newer contentSource) {
+	kept := res.Modified[:0]
+	for _, name := range res.Modified {
+		a, errA := older(name)
+		b, errB := newer(name)
+		if errA == nil && errB == nil && !attrs.binary(name, a, b) &&
+			slices.Equal(normalizeSpaceLines(splitLines(string(a))), normalizeSpaceLines(splitLines(string(b)))) {
+			continue
+		}
+		kept = append(kept, name)
+	}
+	res.Modified = kept
+}
+
+// Operación de un diff: ' ' igual, '-' eliminado, '+' añadido
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// Diff por LCS entre dos secuencias de tokens (líneas o palabras)
+func diffTokens(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	
+	ops := []diffOp{}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// Rango de operaciones [from, to) que forma un hunk, con sus posiciones
+type diffHunk struct {
+	from, to           int
+	oldStart, oldCount int
+	newStart, newCount int
+}
+
+// Agrupa los cambios en hunks con context líneas iguales alrededor
+func diffHunks(ops []diffOp, context int) []diffHunk {
+	hunks := []diffHunk{}
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == ' ' {
+			continue
+		}
+		
+		from := i - context
+		if from < 0 {
+			from = 0
+		}
+		// Extender mientras el siguiente cambio quede dentro del contexto
+		to := i
+		for k := i; k < len(ops) && k <= to+2*context; k++ {
+			if ops[k].kind != ' ' {
+				to = k
+			}
+		}
+		to += context + 1
+		if to > len(ops) {
+			to = len(ops)
+		}
+		
+		// Unir con el hunk anterior si se solapan
+		if len(hunks) > 0 && from <= hunks[len(hunks)-1].to {
+			from = hunks[len(hunks)-1].from
+			hunks = hunks[:len(hunks)-1]
+		}
+		hunks = append(hunks, diffHunk{from: from, to: to})
+		i = to - 1
+	}
+	
+	// Calcular las posiciones (1-based) de cada hunk
+	oldLine, newLine, pos := 1, 1, 0
+	for h := range hunks {
+		for ; pos < hunks[h].from; pos++ {
+			oldLine, newLine = advanceLines(ops[pos], oldLine, newLine)
+		}
+		hunks[h].oldStart, hunks[h].newStart = oldLine, newLine
+		for ; pos < hunks[h].to; pos++ {
+			if ops[pos].kind != '+' {
+				hunks[h].oldCount++
+			}
+			if ops[pos].kind != '-' {
+				hunks[h].newCount++
+			}
+			oldLine, newLine = advanceLines(ops[pos], oldLine, newLine)
+		}
+		// Como en diff -u, un lado vacío indica la línea anterior
+		// ("@@ -0,0 +1,3 @@" para un archivo nuevo)
+		if hunks[h].oldCount == 0 {
+			hunks[h].oldStart--
+		}
+		if hunks[h].newCount == 0 {
+			hunks[h].newStart--
+		}
+	}
+	return hunks
+}
+
+func advanceLines(op diffOp, oldLine, newLine int) (int, int) {
+	if op.kind != '+' {
+		oldLine++
+	}
+	if op.kind != '-' {
+		newLine++
+	}
+	return oldLine, newLine
+}
+
+// Imprime un hunk marcando dentro de cada bloque cambiado solo las palabras
+// eliminadas [-así-] y añadidas {+así+}
+func printWordDiffHunk(w io.Writer, ops []diffOp, color bool) {
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			fmt.Fprintln(w, ops[i].text)
+			i++
+			continue
+		}
+		
+		removed, added := []string{}, []string{}
+		for ; i < len(ops) && ops[i].kind == '-'; i++ {
+			removed = append(removed, ops[i].text)
+		}
+		for ; i < len(ops) && ops[i].kind == '+'; i++ {
+			added = append(added, ops[i].text)
+		}
+		fmt.Fprintln(w, wordDiff(strings.Join(removed, "\n"), strings.Join(added, "\n"), len(removed) > 0, len(added) > 0, color))
+	}
+}
+
+// Con color, las palabras se marcan en rojo/verde en vez de con [- -]/{+ +}
+func wordDiff(before, after string, hasBefore, hasAfter, color bool) string {
+	removed := func(s string) string {
+		if color {
+			return colorize(true, ansiRed, s)
+		}
+		return "[-" + s + "-]"
+	}
+	added := func(s string) string {
+		if color {
+			return colorize(true, ansiGreen, s)
+		}
+		return "{+" + s + "+}"
+	}
+	
+	if !hasBefore {
+		return added(after)
+	}
+	if !hasAfter {
+		return removed(before)
+	}
+	
+	a, b := splitWords(before), splitWords(after)
+	if len(a)*len(b) > maxDiffCells {
+		return removed(before) + added(after)
+	}
+	
+	var sb strings.Builder
+	ops := diffTokens(a, b)
+	for i := 0; i < len(ops); {
+		kind := ops[i].kind
+		run := ""
+		for ; i < len(ops) && ops[i].kind == kind; i++ {
+			run += ops[i].text
+		}
+		switch kind {
+		case '-':
+			sb.WriteString(removed(run))
+		case '+':
+			sb.WriteString(added(run))
+		default:
+			sb.WriteString(run)
+		}
+	}
+	return sb.String()
+}
+
+// Divide un texto en palabras y separadores, conservando los espacios
+func splitWords(s string) []string {
+	tokens := []string{}
+	start := 0
+	for i, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' {
+			if i > start {
+				tokens = append(tokens, s[start:i])
+			}
+			tokens = append(tokens, string(r))
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+// Compara un snapshot con un directorio cualquiera (p. ej. la copia de un
+// compañero o el propio directorio de trabajo). El directorio se recorre con
+// las mismas reglas de ignore que el repositorio y los archivos se comparan
+// por hash de contenido.
+func diffSnapshotWithDir(root, id, dir string, opts DiffOptions) (DiffResult, error) {
+	w := opts.writer()
+	id, err := resolveSpecialID(root, id)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	
+	info, err := os.Stat(dir)
+	if err != nil {
+		return DiffResult{}, fmt.Errorf("no se pudo acceder a '%s': %v", dir, err)
+	}
+	if !info.IsDir() {
+		return DiffResult{}, fmt.Errorf("'%s' no es un directorio", dir)
+	}
+	
+	snap, err := findSnapshot(root, id)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	
+	snapHashes, err := snapshotContentHashes(root, snap.ID)
+	if err != nil {
+		return DiffResult{}, fmt.Errorf("error leyendo snapshot: %v", err)
+	}
+	
+	ignores, err := loadIgnore(root)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	files, err := collectFiles(dir, ignores)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	dirHashes, err := hashFiles(dir, files, snap.FollowSymlinks)
+	if err != nil {
+		return DiffResult{}, err
+	}
+	
+	fromHashes, toHashes := snapHashes, dirHashes
+	fromLabel, toLabel := snap.ID, dir
+	if opts.Reverse {
+		fromHashes, toHashes = toHashes, fromHashes
+		fromLabel, toLabel = toLabel, fromLabel
+	}
+	
+	var res DiffResult
+	res.Added, res.Removed, res.Modified = compareFileHashes(fromHashes, toHashes)
+	res = res.only(opts.Only)
+	if opts.IgnoreSpace {
+		dropSpaceOnlyChanges(&res, opts.Attrs, snapshotSource(root, snap.ID, res.Modified), dirSource(dir))
+	}
+	
+	if opts.JSON {
+		from := snapshotDiffSide(root, snap.ID)
+		to := diffSide{hashes: dirHashes, sizes: fileSizes(dir, files)}
+		if opts.Reverse {
+			from, to = to, from
+		}
+		return res, writeDiffJSON(w, fromLabel, toLabel, res, from, to)
+	}
+	
+	if opts.SummaryOnly {
+		fmt.Fprintln(w, res.Summary())
+		return res, nil
+	}
+	
+	if opts.Plain {
+		from := snapshotSource(root, snap.ID, res.names())
+		to := dirSource(dir)
+		if opts.Reverse {
+			from, to = to, from
+		}
+		printPlainPatch(w, res, from, to, opts)
+		return res, nil
+	}
+	
+	fmt.Fprintf(w, "📊 Comparación: %s → %s\n", fromLabel, toLabel)
+	fmt.Fprintf(w, "📝 Mensaje: \"%s\"\n", snap.Message)
+	
+	printDiffResult(w, res, opts.Color)
+	
+	if opts.Patch || opts.BinarySize {
+		from := snapshotSource(root, snap.ID, res.Modified)
+		to := dirSource(dir)
+		if opts.Reverse {
+			from, to = to, from
+		}
+		if opts.BinarySize {
+			printBinarySizes(w, opts.Attrs, res.Modified, from, to)
+		}
+		if opts.Patch {
+			printContentDiffs(w, res.Modified, from, to, opts)
+		}
+	}
+	
+	if res.Empty() {
+		fmt.Fprintln(w, "\n✅ No hay diferencias")
+	}
+	
+	return res, nil
+}
+
+func findSnapshot(root, id string) (*SnapshotMeta, error) {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return nil, fmt.Errorf("error leyendo índice: %v", err)
+	}
+	
+	for i := range idx.Snapshots {
+		if idx.Snapshots[i].ID == id {
+			return &idx.Snapshots[i], nil
+		}
+	}
+	return nil, fmt.Errorf("snapshot '%s' no encontrado", id)
+}
+
+// Snapshot sobre el que se construye uno incremental; su archivo tiene que existir
+func incrementalBase(root, ref string) (*SnapshotMeta, error) {
+	id, err := resolveSpecialID(root, ref)
+	if err != nil {
+		return nil, err
+	}
+	base, err := findSnapshot(root, id)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot base: %v", err)
+	}
+	if !fileExists(snapshotArchive(root, base.ID)) {
+		return nil, fmt.Errorf("falta el archivo del snapshot base %s", base.ID)
+	}
+	return base, nil
+}
+
+// Separa los archivos que cambiaron respecto a la base (van al archivo) de
+// los que siguen igual (se heredan). Lo preparado con add-content siempre
+// se guarda.
+func splitInherited(root, baseID string, files []string, staged stagedFiles, follow bool) (archived, inherited []string, err error) {
+	baseHashes, err := snapshotContentHashes(root, baseID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("no se pudo leer el snapshot base %s: %v", baseID, err)
+	}
+	archived = []string{}
+	for _, f := range files {
+		if _, ok := staged[f]; !ok {
+			if sum, ok := baseHashes[f]; ok {
+				if current, err := hashFiles(root, []string{f}, follow); err == nil && current[f] == sum {
+					inherited = append(inherited, f)
+					continue
+				}
+			}
+		}
+		archived = append(archived, f)
+	}
+	return archived, inherited, nil
+}
+
+// Archivos que un snapshot incremental toma de su base
+func inheritedFiles(root, id string) ([]string, error) {
+	var sf SnapshotFiles
+	if err := readJSON(snapshotFilesPath(root, id), &sf); err != nil {
+		return nil, fmt.Errorf("no se pudo leer la lista de archivos de %s: %v", id, err)
+	}
+	return sf.Inherited, nil
+}
+
+// Hash de cada archivo de un snapshot. En los incrementales, los heredados
+// se buscan a lo largo de la cadena de bases.
+func snapshotContentHashes(root, id string) (map[string]string, error) {
+	hashes, err := hashArchiveEntries(snapshotArchive(root, id))
+	if err != nil {
+		return nil, err
+	}
+	s, err := findSnapshot(root, id)
+	if err != nil || s.Base == "" {
+		return hashes, nil
+	}
+	
+	inherited, err := inheritedFiles(root, id)
+	if err != nil {
+		return nil, err
+	}
+	baseHashes, err := snapshotContentHashes(root, s.Base)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot base %s: %v", s.Base, err)
+	}
+	for _, name := range inherited {
+		if sum, ok := baseHashes[name]; ok {
+			hashes[name] = sum
+		}
+	}
+	return hashes, nil
+}
+
+// Comprueba, antes de tocar el directorio, que están los archivos de un
+// snapshot y de toda su cadena de bases
+func checkSnapshotChain(root, id string) error {
+	seen := map[string]bool{}
+	for cur := id; cur != ""; {
+		if seen[cur] {
+			return fmt.Errorf("la cadena de bases de %s tiene un ciclo en %s", id, cur)
+		}
+		seen[cur] = true
+		
+		if !fileExists(snapshotArchive(root, cur)) {
+			if cur == id {
+				return fmt.Errorf("snapshot '%s' no encontrado", id)
+			}
+			return fmt.Errorf("falta el archivo del snapshot base %s de %s", cur, id)
+		}
+		s, err := findSnapshot(root, cur)
+		if err != nil {
+			// Un archivo fuera del índice no puede ser incremental
+			if cur == id {
+				return nil
+			}
+			return fmt.Errorf("falta el snapshot base %s de %s", cur, id)
+		}
+		cur = s.Base
+	}
+	return nil
+}
+
+// Extrae un snapshot completo en target. En los incrementales, los archivos
+// heredados se sacan de la cadena de bases. Devuelve cuántas entradas se
+// extrajeron y cuántas descartó opts.Filter, como extractTarGzFiltered.
+func extractSnapshot(root, id, target string, opts extractOptions) (extracted, skipped int, err error) {
+	extracted, skipped, err = extractTarGzFiltered(snapshotArchive(root, id), target, opts)
+	if err != nil {
+		return extracted, skipped, err
+	}
+	s, err := findSnapshot(root, id)
+	if err != nil || s.Base == "" {
+		return extracted, skipped, nil
+	}
+	names, err := inheritedFiles(root, id)
+	if err != nil {
+		return extracted, skipped, err
+	}
+	
+	baseID := s.Base
+	for len(names) > 0 {
+		base, err := findSnapshot(root, baseID)
+		if err != nil {
+			return extracted, skipped, fmt.Errorf("falta el snapshot base %s de %s", baseID, id)
+		}
+		archive := snapshotArchive(root, base.ID)
+		if !fileExists(archive) {
+			return extracted, skipped, fmt.Errorf("falta el archivo del snapshot base %s", base.ID)
+		}
+		
+		want := make(map[string]bool, len(names))
+		for _, n := range names {
+			want[n] = true
+		}
+		found := map[string]bool{}
+		notWanted := 0
+		baseOpts := opts
+		baseOpts.Filter = func(hdr *tar.Header, outPath string) bool {
+			if !want[hdr.Name] {
+				notWanted++
+				return false
+			}
+			found[hdr.Name] = true
+			return opts.Filter == nil || opts.Filter(hdr, outPath)
+		}
+		n, k, err := extractTarGzFiltered(archive, target, baseOpts)
+		extracted, skipped = extracted+n, skipped+k-notWanted
+		if err != nil {
+			return extracted, skipped, err
+		}
+		
+		// Lo que no está en este archivo lo heredó a su vez de su base
+		var rest []string
+		for _, n := range names {
+			if !found[n] {
+				rest = append(rest, n)
+			}
+		}
+		if len(rest) > 0 && base.Base == "" {
+			return extracted, skipped, fmt.Errorf("faltan %d archivo%s heredado%s en el snapshot base %s", len(rest), plural(len(rest)), plural(len(rest)), base.ID)
+		}
+		names, baseID = rest, base.Base
+	}
+	return extracted, skipped, nil
+}
+
+// Recorre las entradas de un snapshot completo. En los incrementales, las
+// heredadas se leen de la cadena de bases.
+func walkSnapshotEntries(root, id string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	var want map[string]bool // nil = todas las entradas del primer archivo
+	for {
+		s, err := findSnapshot(root, id)
+		if err != nil {
+			return err
+		}
+		err = eachArchiveEntry(snapshotArchive(root, id), func(hdr *tar.Header, r io.Reader) error {
+			if want != nil {
+				if !want[hdr.Name] {
+					return nil
+				}
+				delete(want, hdr.Name)
+			}
+			return fn(hdr, r)
+		})
+		if err != nil {
+			return err
+		}
+		
+		if want == nil && s.Base != "" {
+			inherited, err := inheritedFiles(root, id)
+			if err != nil {
+				return err
+			}
+			want = make(map[string]bool, len(inherited))
+			for _, name := range inherited {
+				want[name] = true
+			}
+		}
+		if len(want) == 0 {
+			return nil
+		}
+		if s.Base == "" {
+			return fmt.Errorf("faltan %d archivo%s heredado%s en el snapshot base %s", len(want), plural(len(want)), plural(len(want)), id)
+		}
+		id = s.Base
+	}
+}
+
+// Llama a fn con cada entrada de un .tar.gz, en orden
+func eachArchiveEntry(archive string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Calcula el hash SHA-256 de cada entrada de un archivo .tar.gz
+func hashArchiveEntries(archive string) (map[string]string, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	
+	hashes := make(map[string]string)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		
+		h := sha256.New()
+		if hdr.Typeflag == tar.TypeSymlink {
+			h.Write([]byte(hdr.Linkname))
+		} else if _, err := io.Copy(h, tr); err != nil {
+			return nil, err
+		}
+		hashes[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+	}
+	
+	return hashes, nil
+}
+
+// Calcula el hash SHA-256 de cada archivo (rutas relativas a base). Con
+// follow, de un enlace simbólico se lee el destino, como en un snapshot
+// hecho con --follow-symlinks; si no, se usa el propio enlace.
+func hashFiles(base string, files []string, follow bool) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, rel := range files {
+		full := filepath.Join(base, rel)
+		h := sha256.New()
+		if target, ok := symlinkTarget(full, follow); ok {
+			h.Write([]byte(target))
+			hashes[rel] = hex.EncodeToString(h.Sum(nil))
+			continue
+		}
+		
+		f, err := os.Open(full)
+		if err != nil {
+			return nil, err
+		}
+		
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		hashes[rel] = hex.EncodeToString(h.Sum(nil))
+	}
+	return hashes, nil
+}
+
+// Compara dos conjuntos ruta → hash y devuelve listas ordenadas
+func compareFileHashes(older, newer map[string]string) (added, removed, modified []string) {
+	for f, h := range newer {
+		oldHash, ok := older[f]
+		if !ok {
+			added = append(added, f)
+		} else if oldHash != h {
+			modified = append(modified, f)
+		}
+	}
+	for f := range older {
+		if _, ok := newer[f]; !ok {
+			removed = append(removed, f)
+		}
+	}
+	
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(modified)
+	return
+}
+
+// Ruta excluida por el ignore y el patrón responsable
+type ignoredPath struct {
+	Path    string
+	Pattern string
+}
+
+// Recorre el árbol como collectFiles, pero devuelve lo que este omite. Un
+// directorio ignorado se lista una vez (con '/' final) sin entrar en él.
+func collectIgnored(root string, ignores []string) ([]ignoredPath, error) {
+	out := []ignoredPath{}
+	checkSize := hasSizeRules(ignores)
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if rel == "." {
+			return nil
+		}
+		relUnix := filepath.ToSlash(rel)
+		if d.Name() == ".snapgo" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
+		if matched, pattern := matchIgnore(relUnix, ignores); matched {
+			if d.IsDir() {
+				out = append(out, ignoredPath{Path: relUnix + "/", Pattern: pattern})
+				return filepath.SkipDir
+			}
+			out = append(out, ignoredPath{Path: relUnix, Pattern: pattern})
+			return nil
+		}
+		
+		if checkSize && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				if matched, rule := matchSizeRule(relUnix, info.Size(), ignores); matched {
+					out = append(out, ignoredPath{Path: relUnix, Pattern: rule})
+				}
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+func statusIgnored(root string) error {
+	ignores, err := loadIgnore(root)
+	if err != nil {
+		return err
+	}
+	paths, err := collectIgnored(root, ignores)
+	if err != nil {
+		return err
+	}
+	
+	if len(paths) == 0 {
+		fmt.Println("✅ No hay archivos ignorados")
+		return nil
+	}
+	width := 0
+	for _, p := range paths {
+		width = max(width, len(p.Path))
+	}
+	fmt.Printf("🚫 Archivos ignorados (%d):\n", len(paths))
+	for _, p := range paths {
+		fmt.Printf("   %-*s  ← %s\n", width, p.Path, p.Pattern)
+	}
+	return nil
+}
+
+// Dice, para cada ruta, si el ignore la excluye y con qué patrón. Como git
+// check-ignore, sale con 0 si alguna está ignorada y con 1 si ninguna.
+func checkIgnoreCmdWithRoot(root string) {
+	fs := flag.NewFlagSet("check-ignore", flag.ExitOnError)
+	stdin := fs.Bool("stdin", false, "leer las rutas de la entrada estándar, una por línea")
+	paths := parseArgs(fs, os.Args[2:])
+	
+	if *stdin {
+		data, err := io.ReadAll(os.Stdin)
+		must(err)
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				paths = append(paths, line)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Println("Uso: check-ignore <ruta>... | check-ignore --stdin")
+		os.Exit(1)
+	}
+	
+	ignores, err := loadIgnore(root)
+	must(err)
+	
+	anyIgnored := false
+	for _, p := range paths {
+		rel, err := repoRelative(root, p)
+		if err != nil {
+			fmt.Printf("⚠️  %s: %v\n", p, err)
+			continue
+		}
+		matched, pattern := checkIgnore(root, rel, ignores)
+		if matched {
+			anyIgnored = true
+			fmt.Printf("🚫 %s  ← %s\n", rel, pattern)
+		} else {
+			fmt.Printf("✅ %s  (no ignorado)\n", rel)
+		}
+	}
+	
+	if !anyIgnored {
+		os.Exit(1)
+	}
+}
+
+// Aplica a una ruta relativa las mismas reglas que collectFiles: patrones,
+// el propio .snapgo/ y, si el archivo existe, las directivas size:
+func checkIgnore(root, rel string, ignores []string) (bool, string) {
+	if rel == ".snapgo" || strings.HasPrefix(rel, ".snapgo/") {
+		return true, ".snapgo/"
+	}
+	// Un directorio ignorado excluye todo lo que contiene
+	parts := strings.Split(rel, "/")
+	for i := 1; i <= len(parts); i++ {
+		if matched, pattern := matchIgnore(strings.Join(parts[:i], "/"), ignores); matched {
+			return true, pattern
+		}
+	}
+	if info, err := os.Stat(filepath.Join(root, rel)); err == nil && !info.IsDir() {
+		return matchSizeRule(rel, info.Size(), ignores)
+	}
+	return false, ""
+}
+
+// Ruta relativa a la raíz del repositorio, con '/'. Las rutas relativas se
+// interpretan desde el directorio actual, como en git.
+func repoRelative(root, path string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("ruta fuera del repositorio")
+	}
+	return rel, nil
+}
+
+// Nueva versión de statusCmd que acepta directorio raíz
+func statusCmdWithRoot(root string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	short := fs.Bool("short", false, "formato compacto para scripts y prompts")
+	fs.BoolVar(short, "s", false, "alias de --short")
+	ignored := fs.Bool("ignored", false, "listar los archivos excluidos y el patrón que los excluye")
+	parseFlags(fs, os.Args[2:])
+	
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+		fmt.Println("❌ No es un repositorio SnapGo")
+		fmt.Println("💡 Usa 'snapgo init' para crear uno")
+		return nil
+	}
+	
+	if *ignored {
+		return statusIgnored(root)
+	}
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	if *short {
+		return statusShort(root, idx)
+	}
+	
+	fmt.Printf("📊 Estado del Repositorio (en %s)\n", root)
+	fmt.Println("══════════════════════════════════════════")
+	
+	if len(idx.Snapshots) == 0 {
+		fmt.Println("📭 No hay snapshots todavía")
+	} else {
+		last := idx.Snapshots[len(idx.Snapshots)-1]
+		t, _ := time.Parse(time.RFC3339, last.Timestamp)
+		fmt.Printf("🕒 Último snapshot: %s (%s)\n", last.ID, t.Format("02/01 15:04"))
+		fmt.Printf("📝 Mensaje: %s\n", last.Message)
+	}
+	
+	ignores, err := loadIgnore(root)
+	if err != nil {
+		return err
+	}
+	
+	currentFiles, err := collectFiles(root, ignores)
+	if err != nil {
+		return err
+	}
+	
+	if len(idx.Snapshots) > 0 {
+		head := idx.Snapshots[len(idx.Snapshots)-1]
+		newFiles, deleted, modified := workingTreeChanges(root, head, currentFiles)
+		
+		if len(newFiles) > 0 {
+			fmt.Println("\n🆕 Archivos nuevos no versionados:")
+			for _, f := range newFiles {
+				fmt.Printf("   • %s\n", f)
+			}
+		}
+		
+		if len(modified) > 0 {
+			fmt.Println("\n✏️  Archivos modificados:")
+			for _, f := range modified {
+				fmt.Printf("   • %s\n", f)
+			}
+		}
+		
+		if len(deleted) > 0 {
+			fmt.Println("\n➖ Archivos eliminados:")
+			for _, f := range deleted {
+				fmt.Printf("   • %s\n", f)
+			}
+		}
+		
+		if len(newFiles) == 0 && len(modified) == 0 && len(deleted) == 0 {
+			fmt.Println("\n✅ No hay cambios desde el último snapshot")
+		}
+	} else {
+		fmt.Printf("\n🆕 Archivos listos para el primer snapshot: %d\n", len(currentFiles))
+		if len(currentFiles) > 0 && len(currentFiles) <= 10 {
+			for _, f := range currentFiles {
+				fmt.Printf("   • %s\n", f)
+			}
+		} else if len(currentFiles) > 10 {
+			fmt.Printf("   (mostrando 10 de %d)\n", len(currentFiles))
+			for i := 0; i < 10 && i < len(currentFiles); i++ {
+				fmt.Printf("   • %s\n", currentFiles[i])
+			}
+		}
+	}
+	
+	fmt.Println("\n💡 Usa 'snapgo snapshot -m \"mensaje\"' para guardar cambios")
+	return nil
+}
+
+// Formato compacto (porcelain) para prompts y scripts:
+//
+//	## <rama> <id del último snapshot>
+//	? archivo nuevo no versionado
+//	M archivo modificado
+//	D archivo eliminado
+//
+// Sin snapshots todavía, todos los archivos aparecen como A (añadidos).
+func statusShort(root string, idx Index) error {
+	ignores, err := loadIgnore(root)
+	if err != nil {
+		return err
+	}
+	
+	currentFiles, err := collectFiles(root, ignores)
+	if err != nil {
+		return err
+	}
+	
+	if len(idx.Snapshots) == 0 {
+		fmt.Printf("## %s (sin snapshots)\n", idx.Current)
+		for _, f := range currentFiles {
+			fmt.Printf("A %s\n", f)
+		}
+		return nil
+	}
+	
+	head := idx.Snapshots[len(idx.Snapshots)-1]
+	fmt.Printf("## %s %s\n", idx.Current, head.ID)
+	
+	added, deleted, modified := workingTreeChanges(root, head, currentFiles)
+	lines := []string{}
+	for _, f := range added {
+		lines = append(lines, "? "+f)
+	}
+	for _, f := range modified {
+		lines = append(lines, "M "+f)
+	}
+	for _, f := range deleted {
+		lines = append(lines, "D "+f)
+	}
+	
+	// Ordenar por ruta, no por estado
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i][2:] < lines[j][2:]
+	})
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+	return nil
+}
+
+// Compara el directorio de trabajo con un snapshot. Si el archivo del
+// snapshot no se puede leer, solo se detectan archivos nuevos y eliminados.
+func workingTreeChanges(root string, head SnapshotMeta, currentFiles []string) (added, deleted, modified []string) {
+	headHashes, err := snapshotContentHashes(root, head.ID)
+	if err == nil {
+		currentHashes, err := hashFiles(root, currentFiles, head.FollowSymlinks)
+		if err == nil {
+			return compareFileHashes(headHashes, currentHashes)
+		}
+	}
+	
+	loadSnapshotFiles(root, &head)
+	setHead := make(map[string]bool)
+	for _, f := range head.Files {
+		setHead[f] = true
+	}
+	setCurrent := make(map[string]bool)
+	for _, f := range currentFiles {
+		setCurrent[f] = true
+		if !setHead[f] {
+			added = append(added, f)
+		}
+	}
+	for _, f := range head.Files {
+		if !setCurrent[f] {
+			deleted = append(deleted, f)
+		}
+	}
+	return added, deleted, nil
+}
+
+// Nueva versión de historyCmd que acepta directorio raíz
+func historyCmdWithRoot(root string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	author := fs.String("author", "", "solo snapshots cuyo autor contenga el texto (sin distinguir mayúsculas)")
+	reverse := fs.Bool("reverse", false, "orden cronológico (del más antiguo al más reciente)")
+	parseFlags(fs, os.Args[2:])
+	
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	if len(idx.Snapshots) == 0 {
+		fmt.Println("📭 No hay historial de snapshots")
+		return nil
+	}
+	
+	snapshots := idx.Snapshots
+	if *author != "" {
+		needle := strings.ToLower(*author)
+		snapshots = nil
+		for _, s := range idx.Snapshots {
+			if strings.Contains(strings.ToLower(s.Author), needle) {
+				snapshots = append(snapshots, s)
+			}
+		}
+	}
+	
+	fmt.Printf("📜 Historial de Snapshots (en %s)\n", root)
+	fmt.Println("══════════════════════════════════════════")
+	
+	for n := range snapshots {
+		// Por defecto, del más reciente al más antiguo
+		s := snapshots[len(snapshots)-1-n]
+		if *reverse {
+			s = snapshots[n]
+		}
+		t, _ := time.Parse(time.RFC3339, s.Timestamp)
+		
+		now := time.Now()
+		diff := now.Sub(t)
+		
+		var timeStr string
+		if diff < time.Hour {
+			timeStr = "hace unos minutos"
+		} else if diff < 24*time.Hour {
+			hours := int(diff.Hours())
+			timeStr = fmt.Sprintf("hace %d hora%s", hours, plural(hours))
+		} else if diff < 7*24*time.Hour {
+			days := int(diff.Hours() / 24)
+			timeStr = fmt.Sprintf("hace %d día%s", days, plural(days))
+		} else {
+			timeStr = t.Format("02 Jan 2006")
+		}
+		
+		fmt.Printf("\n🆔 [%s]\n", s.ID)
+		fmt.Printf("   📅 %s | 📁 %d archivos\n", timeStr, s.FileCount)
+		fmt.Printf("   📝 %s\n", s.Message)
+		if s.Author != "" {
+			fmt.Printf("   👤 %s\n", s.Author)
+		}
+		
+		if n < len(snapshots)-1 {
+			fmt.Println("   ──────────────────────────────────────")
+		}
+	}
+	
+	if *author != "" {
+		fmt.Printf("\n🔎 %d de %d snapshot(s) de '%s'\n", len(snapshots), len(idx.Snapshots), *author)
+	}
+	return nil
+}
+
+// Nueva versión de cleanCmd que acepta directorio raíz
+func cleanCmdWithRoot(root string) error {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	policy := fs.String("policy", "count", "política de limpieza: count (max_snapshots) o gfs")
+	dryRun := fs.Bool("dry-run", false, "mostrar qué se conservaría sin eliminar nada")
+	toTrash := fs.Bool("trash", false, "mover los snapshots descartados a la papelera")
+	permanent := fs.Bool("permanent", false, "borrar los snapshots descartados para siempre")
+	keepN := fs.Int("keep", 0, "conservar los N snapshots más recientes (por defecto max_snapshots)")
+	parseFlags(fs, os.Args[2:])
+	
+	if *toTrash && *permanent {
+		return fmt.Errorf("--trash y --permanent no se pueden combinar")
+	}
+	if *keepN < 0 {
+		return fmt.Errorf("--keep no puede ser negativo")
+	}
+	
+	config, err := loadConfig(root)
+	if err != nil {
+		return err
+	}
+	useTrash := (config.CleanToTrash || *toTrash) && !*permanent
+	
+	switch *policy {
+	case "count":
+	case "gfs":
+		retention := defaultRetention()
+		if config.Retention != nil {
+			retention = *config.Retention
+		}
+		return cleanGFS(root, retention, *dryRun, useTrash)
+	default:
+		return fmt.Errorf("política desconocida '%s' (usa count o gfs)", *policy)
+	}
+	
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	limit := config.MaxSnapshots
+	if *keepN > 0 {
+		limit = *keepN
+	}
+	
+	// max_snapshots: 0 significa "sin límite", igual que al crear snapshots
+	if limit == 0 {
+		logf("✅ Sin límite de snapshots (max_snapshots: 0); no hay nada que limpiar\n")
+		return nil
+	}
+	candidates := pruneCandidates(idx.Snapshots, limit)
+	if len(candidates) == 0 {
+		logf("✅ Ya tienes %d snapshots (límite: %d, sin contar los protegidos)\n", len(idx.Snapshots), limit)
+		return nil
+	}
+	if *dryRun {
+		fmt.Printf("🔎 Se eliminarían %d snapshot(s):\n", len(candidates))
+		for _, s := range candidates {
+			fmt.Printf("   • %s  \"%s\"\n", s.ID, s.Message)
+		}
+		fmt.Println("\n💡 Modo --dry-run: no se eliminó nada")
+		return nil
+	}
+	logf("🧹 Limpiando %d snapshot(s) antiguo(s)...\n", len(candidates))
+	
+	var removed []SnapshotMeta
+	if useTrash {
+		if err := trashSnapshots(root, "pruned", candidates); err != nil {
+			return err
+		}
+		for _, s := range candidates {
+			logf("   🗑️  A la papelera: %s\n", s.ID)
+		}
+		removed = candidates
+	} else {
+		for _, s := range candidates {
+			if err := removeSnapshotFiles(root, s.ID); err == nil {
+				logf("   🗑️  Eliminado: %s\n", s.ID)
+				removed = append(removed, s)
+			}
+		}
+	}
+	
+	if len(removed) > 0 {
+		idx.Snapshots = withoutSnapshots(idx.Snapshots, removed)
+		if err := writeJSON(indexPath, idx); err != nil {
+			return err
+		}
+	}
+	
+	logf("✅ Limpieza completada. %d snapshots eliminados.\n", len(removed))
+	if useTrash && len(removed) > 0 {
+		logln("💡 Recuperables con 'snapgo trash restore'")
+	}
+	return nil
+}
+
+// Elige, de los más antiguos a los más recientes, los snapshots que sobran
+// para quedarse en limit. Los protegidos y las bases de snapshots
+// incrementales no cuentan para el límite y nunca se eligen.
+func pruneCandidates(snaps []SnapshotMeta, limit int) []SnapshotMeta {
+	bases := map[string]bool{}
+	for _, s := range snaps {
+		if s.Base != "" {
+			bases[s.Base] = true
+		}
+	}
+	var unprotected []SnapshotMeta
+	for _, s := range snaps {
+		if !s.Protected && !bases[s.ID] {
+			unprotected = append(unprotected, s)
+		}
+	}
+	if len(unprotected) <= limit {
+		return nil
+	}
+	return unprotected[:len(unprotected)-limit]
+}
+
+// Copia de snaps sin los snapshots de drop
+func withoutSnapshots(snaps, drop []SnapshotMeta) []SnapshotMeta {
+	dropped := make(map[string]bool, len(drop))
+	for _, s := range drop {
+		dropped[s.ID] = true
+	}
+	kept := []SnapshotMeta{}
+	for _, s := range snaps {
+		if !dropped[s.ID] {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// Decide qué snapshots conservar según la política GFS y devuelve el motivo
+// de cada uno. Los protegidos, las cabezas de rama y los snapshots
+// etiquetados nunca se borran.
+func gfsRetained(idx Index, r RetentionConfig, now time.Time) map[string]string {
+	keep := map[string]string{}
+	for _, s := range idx.Snapshots {
+		if s.Protected {
+			keep[s.ID] = "protegido"
+		}
+	}
+	for branch, id := range idx.Branches {
+		if _, ok := keep[id]; !ok {
+			keep[id] = "cabeza de la rama " + branch
+		}
+	}
+	for tag, id := range idx.Tags {
+		if _, ok := keep[id]; !ok {
+			keep[id] = "etiqueta " + tag
+		}
+	}
+	
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	months := map[string]bool{}
+	
+	// Del más reciente al más antiguo: el primero de cada periodo es el que queda
+	for i := len(idx.Snapshots) - 1; i >= 0; i-- {
+		s := idx.Snapshots[i]
+		t, err := time.Parse(time.RFC3339, s.Timestamp)
+		if err != nil {
+			keep[s.ID] = "fecha ilegible"
+			continue
+		}
+		age := now.Sub(t)
+		
+		var reason string
+		switch {
+		case age < time.Duration(r.KeepAllHours)*time.Hour:
+			reason = fmt.Sprintf("últimas %dh", r.KeepAllHours)
+		case age < time.Duration(r.DailyDays)*24*time.Hour:
+			key := t.Format("2006-01-02")
+			if !days[key] {
+				days[key] = true
+				reason = "diario " + key
+			}
+		case age < time.Duration(r.WeeklyWeeks)*7*24*time.Hour:
+			year, week := t.ISOWeek()
+			key := fmt.Sprintf("%d-S%02d", year, week)
+			if !weeks[key] {
+				weeks[key] = true
+				reason = "semanal " + key
+			}
+		default:
+			key := t.Format("2006-01")
+			if !months[key] && (r.MonthlyMonths == 0 || len(months) < r.MonthlyMonths) {
+				months[key] = true
+				reason = "mensual " + key
+			}
+		}
+		
+		if reason != "" {
+			if _, ok := keep[s.ID]; !ok {
+				keep[s.ID] = reason
+			}
+		}
+	}
+	
+	// Un incremental necesita toda su cadena de bases
+	for changed := true; changed; {
+		changed = false
+		for _, s := range idx.Snapshots {
+			if _, ok := keep[s.ID]; ok && s.Base != "" {
+				if _, ok := keep[s.Base]; !ok {
+					keep[s.Base] = "base de " + s.ID
+					changed = true
+				}
+			}
+		}
+	}
+	return keep
+}
+
+func cleanGFS(root string, r RetentionConfig, dryRun, useTrash bool) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	keep := gfsRetained(idx, r, time.Now())
+	
+	kept := []SnapshotMeta{}
+	removed := []SnapshotMeta{}
+	for _, s := range idx.Snapshots {
+		if _, ok := keep[s.ID]; ok {
+			kept = append(kept, s)
+		} else {
+			removed = append(removed, s)
+		}
+	}
+	
+	if dryRun {
+		fmt.Printf("📦 Se conservarían %d snapshot(s):\n", len(kept))
+		for _, s := range kept {
+			fmt.Printf("   ✓ %s  (%s)\n", s.ID, keep[s.ID])
+		}
+		fmt.Printf("\n🗑️  Se eliminarían %d snapshot(s):\n", len(removed))
+		for _, s := range removed {
+			fmt.Printf("   • %s  \"%s\"\n", s.ID, s.Message)
+		}
+		fmt.Println("\n💡 Modo --dry-run: no se eliminó nada")
+		return nil
+	}
+	
+	if len(removed) == 0 {
+		logf("✅ Nada que limpiar: los %d snapshots cumplen la política GFS\n", len(kept))
+		return nil
+	}
+	
+	// Primero se mueven o borran los archivos; del índice solo sale lo que
+	// de verdad se fue
+	var done []SnapshotMeta
+	if useTrash {
+		if err := trashSnapshots(root, "pruned", removed); err != nil {
+			return err
+		}
+		for _, s := range removed {
+			logf("   🗑️  A la papelera: %s\n", s.ID)
+		}
+		done = removed
+	} else {
+		for _, s := range removed {
+			if err := removeSnapshotFiles(root, s.ID); err == nil {
+				logf("   🗑️  Eliminado: %s\n", s.ID)
+				done = append(done, s)
+			}
+		}
+	}
+	
+	if len(done) > 0 {
+		idx.Snapshots = withoutSnapshots(idx.Snapshots, done)
+		if err := writeJSON(indexPath, idx); err != nil {
+			return err
+		}
+	}
+	
+	logf("✅ Limpieza GFS completada. %d snapshots eliminados, %d conservados.\n", len(done), len(idx.Snapshots))
+	if useTrash && len(done) > 0 {
+		logln("💡 Recuperables con 'snapgo trash restore'")
+	}
+	return nil
+}
+
+// Nueva versión de branchCmd que acepta directorio raíz
+func branchCmdWithRoot(rootDir string) {
+	if len(os.Args) < 3 {
+		listBranchesWithRoot(rootDir)
+		return
+	}
+	
+	if strings.HasPrefix(os.Args[2], "-") {
+		fs := flag.NewFlagSet("branch", flag.ExitOnError)
+		verbose := fs.Bool("verbose", false, "cabeza, número de snapshots y última actividad de cada rama")
+		fs.BoolVar(verbose, "v", false, "igual que --verbose")
+		asJSON := fs.Bool("json", false, "salida en JSON")
+		parseFlags(fs, os.Args[2:])
+		if !*verbose && !*asJSON {
+			fmt.Println("Uso: branch [nombre] | branch --verbose [--json]")
+			return
+		}
+		must(listBranchesVerbose(rootDir, *asJSON))
+		return
+	}
+	
+	branchName := os.Args[2]
+	must(createBranch(rootDir, branchName))
+}
+
+func listBranchesWithRoot(root string) {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		fmt.Println("Error cargando ramas:", err)
+		return
+	}
+	
+	fmt.Println("🌿 Ramas disponibles:")
+	fmt.Printf("   🟢 %s (actual)\n", idx.Current)
+	
+	fmt.Println("\n💡 Usa 'snapgo branch <nombre>' para crear una nueva rama")
+}
+
+type branchInfo struct {
+	Name         string `json:"name"`
+	Head         string `json:"head,omitempty"`
+	Snapshots    int    `json:"snapshots"`
+	LastActivity string `json:"last_activity,omitempty"`
+	Current      bool   `json:"current"`
+}
+
+// Todas las ramas conocidas con su cabeza, número de snapshots y fecha
+// del último, de la más activa a la menos
+func branchInfos(idx Index) []branchInfo {
+	byName := map[string]*branchInfo{}
+	get := func(name string) *branchInfo {
+		if b, ok := byName[name]; ok {
+			return b
+		}
+		b := &branchInfo{Name: name}
+		byName[name] = b
+		return b
+	}
+	
+	for name := range idx.Branches {
+		get(name)
+	}
+	if idx.Current != "" {
+		get(idx.Current)
+	}
+	for _, s := range idx.Snapshots {
+		b := get(snapshotBranch(s))
+		b.Snapshots++
+		if s.Timestamp > b.LastActivity {
+			b.LastActivity = s.Timestamp
+		}
+	}
+	
+	infos := make([]branchInfo, 0, len(byName))
+	for name, b := range byName {
+		b.Head = branchHead(idx, name)
+		b.Current = name == idx.Current
+		infos = append(infos, *b)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].LastActivity != infos[j].LastActivity {
+			return infos[i].LastActivity > infos[j].LastActivity
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func listBranchesVerbose(root string, asJSON bool) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	infos := branchInfos(idx)
+	
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	}
+	
+	fmt.Println("🌿 Ramas:")
+	for _, b := range infos {
+		marker := "  "
+		if b.Current {
+			marker = "🟢"
+		}
+		head := b.Head
+		if head == "" {
+			head = "(sin snapshots)"
+		}
+		last := "-"
+		if b.LastActivity != "" {
+			last = formatTimeLong(b.LastActivity)
+		}
+		fmt.Printf("   %s %-16s %-30s %3d snapshot(s)  %s\n", marker, b.Name, head, b.Snapshots, last)
+	}
+	return nil
+}
+
+func createBranch(root, name string) error {
+	if name == "" {
+		return fmt.Errorf("nombre de rama no puede estar vacío")
+	}
+	
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	// La rama nueva parte del último snapshot de la rama actual
+	if _, exists := idx.Branches[name]; !exists {
+		if head := branchHead(idx, idx.Current); head != "" {
+			if idx.Branches == nil {
+				idx.Branches = make(map[string]string)
+			}
+			idx.Branches[name] = head
+		}
+	}
+	
+	oldBranch := idx.Current
+	idx.Current = name
+	// Crear la rama también cambia a ella: 'switch -' vuelve a la de antes
+	if oldBranch != name {
+		idx.LastBranch = oldBranch
+	}
+	if err := writeJSON(indexPath, idx); err != nil {
+		return err
+	}
+	recordReflog(root, fmt.Sprintf("branch: '%s' creada desde '%s'", name, oldBranch), branchHead(idx, oldBranch), branchHead(idx, name))
+	
+	logf("✅ Rama '%s' creada y seleccionada\n", name)
+	return nil
+}
+
+// Rama de un snapshot. Los snapshots anteriores al registro de ramas
+// pertenecen a "main".
+func snapshotBranch(s SnapshotMeta) string {
+	if s.Branch == "" {
+		return "main"
+	}
+	return s.Branch
+}
+
+// Último snapshot de una rama ("" si no tiene ninguno)
+func branchHead(idx Index, branch string) string {
+	if head, ok := idx.Branches[branch]; ok {
+		return head
+	}
+	for i := len(idx.Snapshots) - 1; i >= 0; i-- {
+		if snapshotBranch(idx.Snapshots[i]) == branch {
+			return idx.Snapshots[i].ID
+		}
+	}
+	return ""
+}
+
+// Padre de un snapshot. Si no se registró (índices antiguos), se toma el
+// snapshot anterior de la misma rama.
+func parentOf(idx Index, i int) string {
+	s := idx.Snapshots[i]
+	if s.Parent != "" {
+		return s.Parent
+	}
+	for j := i - 1; j >= 0; j-- {
+		if snapshotBranch(idx.Snapshots[j]) == snapshotBranch(s) {
+			return idx.Snapshots[j].ID
+		}
+	}
+	return ""
+}
+
+// Snapshots alcanzables desde la cabeza de alguna rama o desde una
+// etiqueta siguiendo los punteros al padre
+func reachableSnapshots(idx Index) map[string]bool {
+	position := make(map[string]int)
+	for i, s := range idx.Snapshots {
+		position[s.ID] = i
+	}
+	
+	roots := []string{}
+	branches := map[string]bool{idx.Current: true}
+	for name := range idx.Branches {
+		branches[name] = true
+	}
+	for _, s := range idx.Snapshots {
+		branches[snapshotBranch(s)] = true
+	}
+	for name := range branches {
+		if head := branchHead(idx, name); head != "" {
+			roots = append(roots, head)
+		}
+	}
+	for _, target := range idx.Tags {
+		roots = append(roots, target)
+	}
+	
+	reachable := make(map[string]bool)
+	for k := 0; k < len(roots); k++ {
+		id := roots[k]
+		for id != "" && !reachable[id] {
+			i, ok := position[id]
+			if !ok {
+				break
+			}
+			reachable[id] = true
+			// La base de un incremental es necesaria para restaurarlo
+			if base := idx.Snapshots[i].Base; base != "" {
+				roots = append(roots, base)
+			}
+			id = parentOf(idx, i)
+		}
+	}
+	return reachable
+}
+
+func gcCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	compact := fs.Bool("compact", false, "recomprimir los archivos de los snapshots")
+	level := fs.Int("level", -100, "nivel gzip (por defecto compression_level de la configuración)")
+	orphanTrash := fs.Bool("orphan-trash", false, "eliminar las entradas de la papelera que quedaron a medias")
+	parseFlags(fs, os.Args[2:])
+	
+	if !*compact && !*orphanTrash {
+		fmt.Println("Uso: gc --compact [--level N] | gc --orphan-trash")
+		return
+	}
+	
+	if *orphanTrash {
+		must(removeIncompleteTrash(rootDir))
+	}
+	if !*compact {
+		return
+	}
+	
+	if *level == -100 {
+		config, err := loadConfig(rootDir)
+		must(err)
+		*level = config.Compression
+	}
+	must(compactArchives(rootDir, *level))
+}
+
+// Reescribe cada archivo de snapshot con el nivel gzip indicado (las
+// extensiones de skip_compressed_extensions siguen sin comprimir). Las
+// entradas del tar se copian tal cual y se comprueba el hash de contenido
+// antes de sustituir el original. Si el resultado no es más pequeño, se
+// conserva el original. Los archivos firmados se omiten: la firma cubre
+// los bytes del archivo y dejaría de ser válida.
+func compactArchives(root string, level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("nivel de compresión inválido %d (usa de %d a %d)", level, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	config, _ := loadConfig(root)
+	attrs, err := loadAttributes(root)
+	if err != nil {
+		return err
+	}
+	
+	logf("🗜️  Recomprimiendo %d snapshot(s) con nivel %d...\n", len(idx.Snapshots), level)
+	var saved int64
+	compacted, unchanged, failed := 0, 0, 0
+	for _, s := range idx.Snapshots {
+		archive := snapshotArchive(root, s.ID)
+		if fileExists(archive + ".sig") {
+			fmt.Printf("   🔏 %s: firmado, se omite\n", s.ID)
+			unchanged++
+			continue
+		}
+		
+		before, after, err := recompressArchive(archive, level, config.SkipCompressedExtensions, attrs)
+		switch {
+		case err != nil:
+			fmt.Printf("   ❌ %s: %v\n", s.ID, err)
+			failed++
+		case after < before:
+			fmt.Printf("   ✅ %s: %s → %s\n", s.ID, formatSize(before), formatSize(after))
+			saved += before - after
+			compacted++
+		default:
+			unchanged++
+		}
+	}
+	
+	logf("✅ %d recomprimido(s), %d sin cambios; %s ahorrados\n", compacted, unchanged, formatSize(saved))
+	if failed > 0 {
+		return fmt.Errorf("%d archivo(s) no se pudieron recomprimir", failed)
+	}
+	return nil
+}
+
+// Recomprime un archivo en <archivo>.compact y lo sustituye solo si el
+// contenido coincide y ocupa menos. Devuelve el tamaño antes y después.
+func recompressArchive(archive string, level int, storeExts []string, attrs fileAttrs) (before, after int64, err error) {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = info.Size()
+	
+	tmp := archive + ".compact"
+	defer os.Remove(tmp)
+	if err := rewriteTarGz(archive, tmp, level, storeExts, attrs); err != nil {
+		return before, before, err
+	}
+	
+	info, err = os.Stat(tmp)
+	if err != nil {
+		return before, before, err
+	}
+	if info.Size() >= before {
+		return before, before, nil
+	}
+	
+	oldSum, err := archiveContentHash(archive)
+	if err != nil {
+		return before, before, err
+	}
+	newSum, err := archiveContentHash(tmp)
+	if err != nil {
+		return before, before, err
+	}
+	if oldSum != newSum {
+		return before, before, fmt.Errorf("el contenido recomprimido no coincide (%s ≠ %s)", newSum, oldSum)
+	}
+	
+	if err := os.Rename(tmp, archive); err != nil {
+		return before, before, err
+	}
+	return before, info.Size(), nil
+}
+
+// Copia las entradas de un .tar.gz a otro con un nuevo nivel de compresión
+func rewriteTarGz(src, dst string, level int, storeExts []string, attrs fileAttrs) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	
+	gw := &levelGzipWriter{out: out}
+	if err := gw.setLevel(level); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gw)
+	
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		
+		// Rellenar la entrada anterior antes de cambiar de nivel
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		entryLevel := level
+		if hasExtension(hdr.Name, storeExts) || attrs.lookup(hdr.Name).NoCompress {
+			entryLevel = gzip.NoCompression
+		}
+		if err := gw.setLevel(entryLevel); err != nil {
+			return err
+		}
+		
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func pruneCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	unreachable := fs.Bool("unreachable", false, "eliminar snapshots que no alcanza ninguna rama ni etiqueta")
+	dryRun := fs.Bool("dry-run", false, "solo mostrar qué se eliminaría")
+	parseFlags(fs, os.Args[2:])
+	
+	if !*unreachable {
+		fmt.Println("Uso: prune --unreachable [--dry-run]")
+		return
+	}
+	
+	must(pruneUnreachable(rootDir, *dryRun))
+}
+
+func pruneUnreachable(root string, dryRun bool) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	reachable := reachableSnapshots(idx)
+	candidates := []SnapshotMeta{}
+	for _, s := range idx.Snapshots {
+		if !reachable[s.ID] && !s.Protected {
+			candidates = append(candidates, s)
+		}
+	}
+	
+	if len(candidates) == 0 {
+		logln("✅ Todos los snapshots son alcanzables desde alguna rama o etiqueta")
+		return nil
+	}
+	
+	fmt.Printf("🔎 %d snapshot(s) inalcanzable(s):\n", len(candidates))
+	for _, s := range candidates {
+		fmt.Printf("   • %s  [%s]  \"%s\"\n", s.ID, snapshotBranch(s), s.Message)
+	}
+	
+	if dryRun {
+		fmt.Println("\n💡 Modo --dry-run: no se eliminó nada")
+		return nil
+	}
+	
+	fmt.Print("\n¿Eliminar estos snapshots de forma permanente? (s/n): ")
+	var response string
+	fmt.Scanln(&response)
+	
+	if strings.ToLower(response) != "s" {
+		fmt.Println("❌ Operación cancelada")
+		return nil
+	}
+	
+	idx.Snapshots = withoutSnapshots(idx.Snapshots, candidates)
+	if err := writeJSON(indexPath, idx); err != nil {
+		return err
+	}
+	
+	for _, s := range candidates {
+		removeSnapshotFiles(root, s.ID)
+	}
+	
+	logf("✅ %d snapshot(s) eliminados\n", len(candidates))
+	return nil
+}
+
+func purgeCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	yes := fs.Bool("y", false, "no pedir confirmación")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if len(args) != 1 {
+		fmt.Println("Uso: purge <id> [-y]")
+		return
+	}
+	
+	must(purgeSnapshot(rootDir, args[0], *yes))
+}
+
+// Borra un snapshot de forma definitiva: entrada del índice, archivo,
+// firma, lista de archivos y referencias en la papelera. Sus hijos pasan a
+// colgar de su padre y las ramas que apuntaban a él retroceden al padre.
+func purgeSnapshot(root, ref string, yes bool) error {
+	_, _, indexPath, _, _, trashDir := repoPaths(root)
+	
+	id, err := resolveSpecialID(root, ref)
+	if err != nil {
+		return err
+	}
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	pos := -1
+	for i, s := range idx.Snapshots {
+		if s.ID == id {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return fmt.Errorf("snapshot no encontrado: %s", ref)
+	}
+	target := idx.Snapshots[pos]
+	
+	// Sin su base, un incremental ya no se puede restaurar
+	if deps := baseDependents(idx.Snapshots, id); len(deps) > 0 {
+		return fmt.Errorf("%s es la base del incremental %s; bórralo antes", id, strings.Join(deps, ", "))
+	}
+	
+	children := 0
+	for _, s := range idx.Snapshots {
+		if s.Parent == id {
+			children++
+		}
+	}
+	tags := []string{}
+	for name, tagged := range idx.Tags {
+		if tagged == id {
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+	
+	fmt.Printf("🆔 %s  [%s]  \"%s\"\n", target.ID, snapshotBranch(target), target.Message)
+	if children > 0 {
+		fmt.Printf("   🔗 %d snapshot(s) hijo(s) pasarán a depender de %s\n", children, displayParent(target.Parent))
+	}
+	if len(tags) > 0 {
+		fmt.Printf("   🏷️  Se eliminarán las etiquetas: %s\n", strings.Join(tags, ", "))
+	}
+	fmt.Println("⚠️  purge es irreversible: el snapshot NO se mueve a la papelera")
+	
+	if !yes {
+		fmt.Print("\n¿Eliminar este snapshot de forma permanente? (s/n): ")
+		var response string
+		fmt.Scanln(&response)
+		
+		if strings.ToLower(response) != "s" {
+			fmt.Println("❌ Operación cancelada")
+			return nil
+		}
+	}
+	
+	idx.Snapshots = append(idx.Snapshots[:pos], idx.Snapshots[pos+1:]...)
+	for i := range idx.Snapshots {
+		if idx.Snapshots[i].Parent == id {
+			idx.Snapshots[i].Parent = target.Parent
+		}
+	}
+	for branch, head := range idx.Branches {
+		if head == id {
+			idx.Branches[branch] = target.Parent
+		}
+	}
+	for _, name := range tags {
+		delete(idx.Tags, name)
+	}
+	if err := writeJSON(indexPath, idx); err != nil {
+		return err
+	}
+	
+	if err := removeSnapshotFiles(root, id); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	
+	// La papelera guarda archivos de trabajo, no del snapshot: solo se
+	// olvida la referencia
+	entries, _ := os.ReadDir(trashDir)
+	for _, entry := range entries {
+		metaPath := filepath.Join(trashDir, entry.Name(), trashMetaFile)
+		var meta TrashMeta
+		if readJSON(metaPath, &meta) == nil && meta.SnapshotID == id {
+			meta.SnapshotID = ""
+			writeJSON(metaPath, meta)
+		}
+	}
+	
+	logf("✅ Snapshot %s eliminado definitivamente\n", id)
+	return nil
+}
+
+func displayParent(parent string) string {
+	if parent == "" {
+		return "ningún snapshot (quedan como raíz)"
+	}
+	return parent
+}
+
+// Nueva versión de switchCmd que acepta directorio raíz
+func switchCmdWithRoot(rootDir string) {
+	if len(os.Args) < 3 {
+		fmt.Println("Uso: switch <nombre-de-rama>")
+		return
+	}
+	
+	branchName := os.Args[2]
+	must(switchBranch(rootDir, branchName))
+}
+
+func switchBranch(root, name string) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	// Como en git, "-" vuelve a la rama anterior
+	if name == "-" {
+		if idx.LastBranch == "" {
+			return fmt.Errorf("no hay rama anterior a la que volver")
+		}
+		name = idx.LastBranch
+	}
+	
+	oldBranch := idx.Current
+	if name == oldBranch {
+		logf("ℹ️  Ya estás en '%s'\n", name)
+		return nil
+	}
+	idx.Current = name
+	idx.LastBranch = oldBranch
+	
+	if err := writeJSON(indexPath, idx); err != nil {
+		return err
+	}
+	recordReflog(root, fmt.Sprintf("switch: de '%s' a '%s'", oldBranch, name), branchHead(idx, oldBranch), branchHead(idx, name))
+	
+	logf("✅ Cambiado de '%s' a '%s'\n", oldBranch, name)
+	return nil
+}
+
+// Movimiento de HEAD registrado en .snapgo/reflog (una línea JSON por
+// entrada, de la más antigua a la más reciente).
+type ReflogEntry struct {
+	Timestamp string `json:"timestamp"`
+	Command   string `json:"command"`
+	Old       string `json:"old,omitempty"`
+	New       string `json:"new,omitempty"`
+}
+
+// Entradas que se conservan; al pasarse se descartan las más antiguas
+const reflogMaxEntries = 1000
+
+func reflogPath(root string) string {
+	snapgoDir, _, _, _, _, _ := repoPaths(root)
+	return filepath.Join(snapgoDir, "reflog")
+}
+
+// Cabeza de la rama actual, o "" si no se puede leer el índice
+func currentHead(root string) string {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return ""
+	}
+	return branchHead(idx, idx.Current)
+}
+
+// Añade una entrada al reflog. Un fallo aquí no debe deshacer la operación
+// que ya se completó, así que solo se avisa.
+func recordReflog(root, command, oldHead, newHead string) {
+	if err := appendReflog(root, ReflogEntry{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Command:   command,
+		Old:       oldHead,
+		New:       newHead,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  No se pudo actualizar el reflog: %v\n", err)
+	}
+}
+
+func appendReflog(root string, entry ReflogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := reflogPath(root)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	
+	entries, err := readReflog(root)
+	if err != nil || len(entries) <= reflogMaxEntries {
+		return err
+	}
+	// Recortar reescribiendo en un temporal para no perder el reflog a medias
+	var buf bytes.Buffer
+	for _, e := range entries[len(entries)-reflogMaxEntries:] {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Entradas del reflog, de la más antigua a la más reciente. Las líneas
+// ilegibles (p. ej. una escritura cortada) se saltan.
+func readReflog(root string) ([]ReflogEntry, error) {
+	data, err := os.ReadFile(reflogPath(root))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []ReflogEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var e ReflogEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Resuelve @{N}: la cabeza que quedó tras el N-ésimo movimiento más reciente
+// (@{0} es la actual, @{1} la anterior...).
+func resolveReflogRef(root, ref string) (string, error) {
+	var n int
+	if _, err := fmt.Sscanf(ref, "@{%d}", &n); err != nil || n < 0 || ref != fmt.Sprintf("@{%d}", n) {
+		return "", fmt.Errorf("referencia inválida '%s'", ref)
+	}
+	entries, err := readReflog(root)
+	if err != nil {
+		return "", err
+	}
+	if n >= len(entries) {
+		return "", fmt.Errorf("'%s' va más allá del reflog (hay %d entradas)", ref, len(entries))
+	}
+	target := entries[len(entries)-1-n].New
+	if target == "" {
+		return "", fmt.Errorf("'%s' no apunta a ningún snapshot", ref)
+	}
+	return target, nil
+}
+
+func reflogCmdWithRoot(root string) {
+	fs := flag.NewFlagSet("reflog", flag.ExitOnError)
+	limit := fs.Int("n", 0, "mostrar solo las N entradas más recientes")
+	parseArgs(fs, os.Args[2:])
+	must(printReflog(root, *limit))
+}
+
+func printReflog(root string, limit int) error {
+	entries, err := readReflog(root)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("ℹ️  El reflog está vacío")
+		return nil
+	}
+	
+	fmt.Println("📜 Movimientos de HEAD (más reciente primero):")
+	for n := 0; n < len(entries); n++ {
+		if limit > 0 && n >= limit {
+			break
+		}
+		e := entries[len(entries)-1-n]
+		oldHead, newHead := e.Old, e.New
+		if oldHead == "" {
+			oldHead = "(ninguno)"
+		}
+		if newHead == "" {
+			newHead = "(ninguno)"
+		}
+		fmt.Printf("  @{%d}  %s  %s\n", n, formatTime(e.Timestamp), e.Command)
+		fmt.Printf("         %s → %s\n", oldHead, newHead)
+	}
+	return nil
+}
+
+// Nueva versión de configCmd que acepta directorio raíz
+func configCmdWithRoot(root string) {
+	if len(os.Args) >= 3 && os.Args[2] == "list" {
+		fs := flag.NewFlagSet("config list", flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "salida en JSON")
+		parseFlags(fs, os.Args[3:])
+		must(listConfigKeys(root, *asJSON))
+		return
+	}
+	if len(os.Args) >= 3 && (os.Args[2] == "set" || os.Args[2] == "get" || os.Args[2] == "reset") {
+		sub := os.Args[2]
+		fs := flag.NewFlagSet("config "+sub, flag.ExitOnError)
+		global := fs.Bool("global", false, "usar la configuración global del usuario ("+globalConfigPath()+")")
+		args := parseArgs(fs, os.Args[3:])
+		
+		switch {
+		case sub == "set" && len(args) == 2 && *global:
+			must(configSetGlobal(args[0], args[1]))
+		case sub == "set" && len(args) == 2:
+			must(configSet(root, args[0], args[1]))
+		case sub == "set" && len(args) == 3 && !*global && isAutoIgnoreKey(args[0]):
+			must(configSetAutoIgnore(root, args[1], args[2]))
+		case sub == "get" && len(args) == 1:
+			must(configGet(root, args[0], *global))
+		case sub == "reset" && len(args) <= 1:
+			key := ""
+			if len(args) == 1 {
+				key = args[0]
+			}
+			must(configReset(root, key, *global))
+		default:
+			fmt.Println("Uso: config set <clave> <valor> [--global]")
+			fmt.Println("     config set autoignore add|remove <patrón>")
+			fmt.Println("     config get <clave> [--global]")
+			fmt.Println("     config reset [clave] [--global]")
+		}
+		return
+	}
+	
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	showEffective := fs.Bool("show-effective", false, "mostrar la configuración combinada y el origen de cada valor")
+	asJSON := fs.Bool("json", false, "con --show-effective, salida en JSON")
+	parseFlags(fs, os.Args[2:])
+	if *showEffective {
+		must(showEffectiveConfig(root, *asJSON))
+		return
+	}
+	
+	config, err := loadConfig(root)
+	if err != nil {
+		fmt.Println("Error cargando configuración:", err)
+		return
+	}
+	
+	fmt.Printf("⚙️  Configuración de SnapGo (en %s)\n", root)
+	fmt.Println("══════════════════════════════════════════")
+	
+	fmt.Printf("📦 Versión:          %s\n", config.Version)
+	if config.Bare {
+		fmt.Println("🗄️  Tipo:             bare (sin directorio de trabajo)")
+	}
+	fmt.Printf("🗜️  Compresión:       nivel %d\n", config.Compression)
+	if config.MaxSnapshots > 0 {
+		fmt.Printf("🎯 Límite snapshots: %d\n", config.MaxSnapshots)
+	} else {
+		fmt.Printf("🎯 Límite snapshots: sin límite\n")
+	}
+	fmt.Printf("📏 Tamaño chunk:     %d MB\n", config.ChunkSizeMB)
+	fmt.Printf("🌀 Delta storage:    %v\n", config.UseDelta)
+	fmt.Printf("🔤 Alias habilitados: %v\n", config.Aliases)
+	fmt.Printf("🗑️  Papelera habilitada: %v\n", config.EnableTrash)
+	fmt.Printf("🐱 Modo Git habilitado: %v\n", config.GitMode)
+	if config.GitMode {
+		fmt.Printf("   Remoto/rama: %s %s\n", valueOr(config.GitRemote, "origin"), valueOr(config.GitBranch, "main"))
+	}
+	fmt.Printf("🔏 Firmar snapshots: %v\n", config.SignSnapshots)
+	fmt.Printf("👤 Conservar propietario (uid/gid): %v\n", config.PreserveOwnership)
+	fmt.Printf("📚 Máx. archivos por snapshot: %d (aviso: %d)\n", config.MaxFileCount, config.WarnFileCount)
+	fmt.Printf("🌿 Prefijo de rama en mensajes: %v (excepto main: %v)\n", config.BranchMessagePrefix, config.PrefixSkipMain)
+	layout := config.ArchiveLayout
+	if layout == "" {
+		layout = layoutFlat
+	}
+	fmt.Printf("🗂️  Distribución de archivos: %s\n", layout)
+	if config.IOThrottleMBps > 0 {
+		fmt.Printf("🐢 Límite de E/S:     %d MB/s\n", config.IOThrottleMBps)
+	} else {
+		fmt.Printf("🐢 Límite de E/S:     sin límite\n")
+	}
+	
+	fmt.Println("\n🚫 Auto-ignore:")
+	for _, pattern := range config.AutoIgnore {
+		fmt.Printf("   • %s\n", pattern)
+	}
+	
+	fmt.Println("\n💡 Usa 'snapgo config set <clave> <valor>' o edita .snapgo/config.json")
+}
+
+// Valor JSON de cada clave de la configuración
+func configValues(c Config) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Otros nombres admitidos para algunas claves, al estilo de git
+var configKeyAliases = map[string]string{
+	"git.remote": "git_remote",
+	"git.branch": "git_branch",
+	"user.name":  "user_name",
+}
+
+func canonicalConfigKey(key string) string {
+	if k, ok := configKeyAliases[key]; ok {
+		return k
+	}
+	return key
+}
+
+// Documentación de cada clave de config.json, en el orden de Config.
+// Es la referencia de 'config list'; al añadir un campo a Config hay que
+// añadirlo también aquí.
+var configKeyDocs = []struct {
+	Key         string
+	Type        string
+	Description string
+}{
+	{"version", "string", "versión del formato del repositorio"},
+	{"auto_ignore", "[]string", "patrones ignorados siempre, además de .snapgoignore"},
+	{"compression_level", "int, 0-9", "nivel de compresión gzip"},
+	{"max_snapshots", "int", "snapshots que conserva 'clean' (0 = sin límite)"},
+	{"chunk_size_mb", "int", "tamaño de bloque en MB"},
+	{"use_delta", "bool", "almacenamiento delta (experimental)"},
+	{"enable_aliases", "bool", "permitir alias cortos de comandos (s, c, b...)"},
+	{"enable_trash", "bool", "mover a la papelera los archivos sustituidos al restaurar"},
+	{"git_mode", "bool", "integración con Git (git-sync, git-save...)"},
+	{"io_throttle_mbps", "int", "límite de E/S en MB/s (0 = sin límite)"},
+	{"sign_snapshots", "bool", "firmar cada snapshot con GPG"},
+	{"max_file_count", "int", "máximo de archivos por snapshot (0 = sin límite)"},
+	{"warn_file_count", "int", "avisar a partir de este número de archivos"},
+	{"branch_message_prefix", "bool", "anteponer [rama] al mensaje de los snapshots"},
+	{"branch_prefix_skip_main", "bool", "no anteponer el prefijo en la rama main"},
+	{"retention", "object", "política GFS de 'clean --policy gfs'"},
+	{"archive_layout", "string, flat|sharded", "distribución de los archivos en .snapgo/snapshots"},
+	{"preserve_ownership", "bool", "restaurar el propietario (uid/gid) de los archivos"},
+	{"follow_symlinks", "bool", "guardar el contenido de los enlaces simbólicos"},
+	{"respect_git_status", "string, ignored|tracked", "excluir lo que git ignora o guardar solo lo versionado (vacío = no)"},
+	{"skip_compressed_extensions", "[]string", "extensiones ya comprimidas que se guardan sin gzip"},
+	{"clean_to_trash", "bool", "'clean' manda a la papelera los snapshots descartados en vez de borrarlos"},
+	{"read_concurrency", "int", "archivos leídos en paralelo al crear un snapshot (0 o 1 = secuencial)"},
+	{"git_remote", "string", "remoto de git-sync y git-share (también git.remote)"},
+	{"git_branch", "string", "rama de git-sync y git-share (también git.branch)"},
+	{"snapshot_timeout", "string", "tiempo máximo de un snapshot, p. ej. 10m (vacío = sin límite)"},
+	{"bare", "bool", "repositorio solo de copias, sin directorio de trabajo (init --bare)"},
+	{"clamp_invalid_config", "bool", "ajustar al rango válido los números fuera de rango en vez de dar error"},
+	{"user_name", "string", "autor de los snapshots nuevos (también user.name; SNAPGO_AUTHOR tiene prioridad)"},
+}
+
+func listConfigKeys(root string, asJSON bool) error {
+	config, err := loadConfig(root)
+	if err != nil {
+		return err
+	}
+	current, err := configValues(config)
+	if err != nil {
+		return err
+	}
+	defaults, err := configValues(defaultConfig())
+	if err != nil {
+		return err
+	}
+	
+	type entry struct {
+		Key         string          `json:"key"`
+		Type        string          `json:"type"`
+		Description string          `json:"description"`
+		Value       json.RawMessage `json:"value"`
+		Default     json.RawMessage `json:"default"`
+	}
+	
+	entries := make([]entry, 0, len(configKeyDocs))
+	for _, doc := range configKeyDocs {
+		e := entry{Key: doc.Key, Type: doc.Type, Description: doc.Description, Value: current[doc.Key], Default: defaults[doc.Key]}
+		if e.Value == nil {
+			e.Value = json.RawMessage("null")
+		}
+		if e.Default == nil {
+			e.Default = json.RawMessage("null")
+		}
+		entries = append(entries, e)
+	}
+	
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	
+	fmt.Println("⚙️  Claves de configuración")
+	fmt.Println("══════════════════════════════════════════")
+	for _, e := range entries {
+		fmt.Printf("%s (%s): %s\n", e.Key, e.Type, e.Description)
+		fmt.Printf("   valor: %s   por defecto: %s\n", string(e.Value), string(e.Default))
+	}
+	fmt.Println("\n💡 Cambia un valor con 'snapgo config set <clave> <valor>'")
+	return nil
+}
+
+// Muestra cada clave de la configuración efectiva junto a su origen
+func showEffectiveConfig(root string, asJSON bool) error {
+	config, sources, err := effectiveConfig(root)
+	if err != nil {
+		return err
+	}
+	
+	values, err := configValues(config)
+	if err != nil {
+		return err
+	}
+	
+	type entry struct {
+		Value  json.RawMessage `json:"value"`
+		Source string          `json:"source"`
+	}
+	
+	if asJSON {
+		out := map[string]entry{}
+		for k, v := range values {
+			out[k] = entry{Value: v, Source: sources[k]}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	
+	fmt.Printf("⚙️  Configuración efectiva (en %s)\n", root)
+	fmt.Println("══════════════════════════════════════════")
+	for _, k := range keys {
+		fmt.Printf("   %-24s %-36s [%s]\n", k, string(values[k]), sources[k])
+	}
+	if p := globalConfigPath(); p != "" {
+		fmt.Printf("\n🌐 Configuración global: %s (existe: %v)\n", p, fileExists(p))
+	}
+	fmt.Println("💡 Las variables SNAPGO_<CLAVE> (p. ej. SNAPGO_MAX_SNAPSHOTS) tienen prioridad")
+	return nil
+}
+
+// Valor por defecto de una clave que se puede cambiar con 'config set'
+func settableConfigDefault(key string) (any, error) {
+	if key == "archive_layout" {
+		return layoutFlat, nil
+	}
+	
+	data, err := json.Marshal(defaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	defaults := map[string]any{}
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return nil, err
+	}
+	
+	current, ok := defaults[key]
+	if !ok {
+		for _, doc := range configKeyDocs {
+			if doc.Key == key {
+				return nil, fmt.Errorf("'%s' no se puede cambiar con 'config set'; edita el archivo de configuración", key)
+			}
+		}
+		return nil, fmt.Errorf("clave de configuración desconocida '%s'", key)
+	}
+	switch current.(type) {
+	case bool, float64, string:
+		return current, nil
+	}
+	return nil, fmt.Errorf("'%s' no se puede cambiar con 'config set'; edita el archivo de configuración", key)
+}
+
+func readGlobalConfig() (string, map[string]any, error) {
+	path := globalConfigPath()
+	if path == "" {
+		return "", nil, fmt.Errorf("no se pudo determinar el directorio de configuración del usuario")
+	}
+	fields := map[string]any{}
+	if fileExists(path) {
+		if err := readJSON(path, &fields); err != nil {
+			return "", nil, fmt.Errorf("configuración global %s: %v", path, err)
+		}
+	}
+	return path, fields, nil
+}
+
+// Guarda una clave en la configuración global (la crea si no existe).
+// Solo se escriben las claves cambiadas: el resto sigue usando los valores
+// por defecto y los de cada repositorio. Se valida como 'config set'.
+func configSetGlobal(key, value string) error {
+	key = canonicalConfigKey(key)
+	current, err := settableConfigDefault(key)
+	if err != nil {
+		return err
+	}
+	if key == "archive_layout" && value != layoutFlat && value != layoutSharded {
+		return fmt.Errorf("distribución desconocida '%s' (usa %s o %s)", value, layoutFlat, layoutSharded)
+	}
+	v, err := parseConfigValue(current, value)
+	if err != nil {
+		return fmt.Errorf("'%s': %v", key, err)
+	}
+	
+	path, fields, err := readGlobalConfig()
+	if err != nil {
+		return err
+	}
+	fields[key] = v
+	
+	data, err := json.Marshal(defaultConfig())
+	if err != nil {
+		return err
+	}
+	merged := map[string]any{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	updated, err := decodeConfigFields(merged)
+	if err != nil {
+		return err
+	}
+	if err := checkConfig(&updated); err != nil {
+		return err
+	}
+	
+	// Con clamp_invalid_config lo guardado puede no ser lo pedido
+	values, err := configValues(updated)
+	if err != nil {
+		return err
+	}
+	var stored any
+	if err := json.Unmarshal(values[key], &stored); err != nil {
+		return err
+	}
+	fields[key] = stored
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := writeJSON(path, fields); err != nil {
+		return err
+	}
+	
+	logf("✅ %s = %s (global: %s)\n", key, fmt.Sprint(stored), path)
+	return nil
+}
+
+func configGet(root, key string, global bool) error {
+	key = canonicalConfigKey(key)
+	if global {
+		_, fields, err := readGlobalConfig()
+		if err != nil {
+			return err
+		}
+		v, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("'%s' no está definida en la configuración global", key)
+		}
+		data, _ := json.Marshal(v)
+		fmt.Println(string(data))
+		return nil
+	}
+	
+	config, _, err := effectiveConfig(root)
+	if err != nil {
+		return err
+	}
+	values, err := configValues(config)
+	if err != nil {
+		return err
+	}
+	v, ok := values[key]
+	if !ok {
+		known := false
+		for _, doc := range configKeyDocs {
+			known = known || doc.Key == key
+		}
+		if !known {
+			return fmt.Errorf("clave de configuración desconocida '%s'", key)
+		}
+		v = json.RawMessage("null")
+	}
+	fmt.Println(string(v))
+	return nil
+}
+
+// Quita una clave o, sin clave, toda la configuración propia del
+// repositorio (o la global), que vuelve a seguir a la capa de debajo.
+// archive_layout y bare se conservan porque describen cómo están guardados
+// los archivos (archive_layout se cambia con 'config set archive_layout').
+func configReset(root, key string, global bool) error {
+	key = canonicalConfigKey(key)
+	if key == "archive_layout" {
+		return fmt.Errorf("archive_layout no se puede restablecer; usa 'config set archive_layout %s'", layoutFlat)
+	}
+	if key != "" {
+		if _, err := settableConfigDefault(key); err != nil {
+			return err
+		}
+	}
+	
+	if global {
+		path, fields, err := readGlobalConfig()
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			fields = map[string]any{}
+		}
+		delete(fields, key)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := writeJSON(path, fields); err != nil {
+			return err
+		}
+		logf("✅ Configuración global restablecida: %s\n", displayKey(key))
+		return nil
+	}
+	
+	_, _, _, configPath, _, _ := repoPaths(root)
+	fields, err := loadRepoConfig(root)
+	if err != nil {
+		return err
+	}
+	
+	if key == "" {
+		kept := map[string]any{}
+		for _, k := range []string{"version", "archive_layout", "bare"} {
+			if v, ok := fields[k]; ok {
+				kept[k] = v
+			}
+		}
+		fields = kept
+	}
+	delete(fields, key)
+	if err := writeJSON(configPath, fields); err != nil {
+		return err
+	}
+	logf("✅ Configuración restablecida: %s\n", displayKey(key))
+	return nil
+}
+
+func displayKey(key string) string {
+	if key == "" {
+		return "todas las claves"
+	}
+	return key
+}
+
+// Cambia una clave escalar de config.json (por su nombre JSON). El valor se
+// interpreta según el tipo actual de la clave.
+func configSet(root, key, value string) error {
+	_, _, _, configPath, _, _ := repoPaths(root)
+	key = canonicalConfigKey(key)
+	
+	if key == "archive_layout" {
+		return setArchiveLayout(root, value)
+	}
+	
+	current, err := settableConfigDefault(key)
+	if err != nil {
+		return err
+	}
+	v, err := parseConfigValue(current, value)
+	if err != nil {
+		return fmt.Errorf("'%s': %v", key, err)
+	}
+	
+	fields, err := loadRepoConfig(root)
+	if err != nil {
+		return err
+	}
+	fields[key] = v
+	
+	updated, err := repoConfigWith(fields)
+	if err != nil {
+		return err
+	}
+	if err := checkConfig(&updated); err != nil {
+		return err
+	}
+	
+	// Con clamp_invalid_config lo guardado puede no ser lo pedido
+	values, err := configValues(updated)
+	if err != nil {
+		return err
+	}
+	var stored any
+	if err := json.Unmarshal(values[key], &stored); err != nil {
+		return err
+	}
+	fields[key] = stored
+	if err := writeJSON(configPath, fields); err != nil {
+		return err
+	}
+	
+	logf("✅ %s = %s\n", key, fmt.Sprint(stored))
+	return nil
+}
+
+func isAutoIgnoreKey(key string) bool {
+	return key == "autoignore" || key == "auto_ignore"
+}
+
+// Añade (sin duplicados) o quita un patrón de la lista auto_ignore
+func configSetAutoIgnore(root, op, pattern string) error {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return fmt.Errorf("el patrón no puede estar vacío")
+	}
+	
+	_, _, _, configPath, _, _ := repoPaths(root)
+	fields, err := loadRepoConfig(root)
+	if err != nil {
+		return err
+	}
+	// La lista de partida es la efectiva, aunque venga de la global
+	config, err := repoConfigWith(fields)
+	if err != nil {
+		return err
+	}
+	
+	idx := -1
+	for i, p := range config.AutoIgnore {
+		if p == pattern {
+			idx = i
+			break
+		}
+	}
+	
+	switch op {
+	case "add":
+		if idx >= 0 {
+			logf("ℹ️  '%s' ya está en auto_ignore\n", pattern)
+			return nil
+		}
+		config.AutoIgnore = append(config.AutoIgnore, pattern)
+	case "remove":
+		if idx < 0 {
+			return fmt.Errorf("'%s' no está en auto_ignore", pattern)
+		}
+		config.AutoIgnore = append(config.AutoIgnore[:idx], config.AutoIgnore[idx+1:]...)
+	default:
+		return fmt.Errorf("operación desconocida '%s' (usa add o remove)", op)
+	}
+	
+	fields["auto_ignore"] = config.AutoIgnore
+	if err := writeJSON(configPath, fields); err != nil {
+		return err
+	}
+	
+	if op == "add" {
+		logf("✅ auto_ignore += %s\n", pattern)
+	} else {
+		logf("✅ auto_ignore -= %s\n", pattern)
+	}
+	return nil
+}
+
+// Cambia la distribución de los archivos y mueve los existentes
+func setArchiveLayout(root, layout string) error {
+	if layout != layoutFlat && layout != layoutSharded {
+		return fmt.Errorf("distribución desconocida '%s' (usa %s o %s)", layout, layoutFlat, layoutSharded)
+	}
+	
+	moved, err := migrateArchiveLayout(root, layout)
+	if err != nil {
+		return fmt.Errorf("error moviendo archivos (%d ya movidos): %v", moved, err)
+	}
+	
+	_, _, _, configPath, _, _ := repoPaths(root)
+	fields, err := loadRepoConfig(root)
+	if err != nil {
+		return err
+	}
+	fields["archive_layout"] = layout
+	if err := writeJSON(configPath, fields); err != nil {
+		return err
+	}
+	
+	logf("✅ archive_layout = %s (%d snapshot(s) movidos)\n", layout, moved)
+	return nil
+}
+
+// Nueva versión de trashCmd que acepta directorio raíz
+func trashCmdWithRoot(rootDir string) {
+	if len(os.Args) < 3 {
+		listTrashWithRoot(rootDir)
+		return
+	}
+	
+	subcmd := os.Args[2]
+	switch subcmd {
+	case "list":
+		must(listTrashWithRoot(rootDir))
+	case "empty":
+		must(emptyTrash(rootDir))
+	case "restore":
+		if len(os.Args) < 4 {
+			fmt.Println("Uso: trash restore <timestamp>")
+			return
+		}
+		timestamp := os.Args[3]
+		must(restoreFromTrash(rootDir, timestamp))
+	default:
+		fmt.Println("🗑️  Comandos de papelera:")
+		fmt.Println("  trash list         Listar contenido de la papelera")
+		fmt.Println("  trash empty        Vaciar la papelera")
+		fmt.Println("  trash restore <ts> Restaurar archivos (y snapshots retirados) de un timestamp")
+	}
+}
+
+func listTrashWithRoot(root string) error {
+	_, _, _, _, _, trashDir := repoPaths(root)
+	
+	if _, err := os.Stat(trashDir); os.IsNotExist(err) {
+		fmt.Println("🗑️  La papelera está vacía")
+		return nil
+	}
+	
+	entries, err := os.ReadDir(trashDir)
+	if err != nil {
+		return err
+	}
+	
+	if len(entries) == 0 {
+		fmt.Println("🗑️  La papelera está vacía")
+		return nil
+	}
+	
+	fmt.Println("🗑️  Contenido de la Papelera")
+	fmt.Println("══════════════════════════════════════════")
+	for _, entry := range entries {
+		if entry.IsDir() {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			
+			trashPath := filepath.Join(trashDir, entry.Name())
+			
+			var meta TrashMeta
+			if err := readJSON(filepath.Join(trashPath, trashMetaFile), &meta); err == nil {
+				fmt.Printf("📦 [%s]\n", entry.Name())
+				fmt.Printf("   📁 Archivos: %d\n", meta.FileCount)
+				fmt.Printf("   📅 Fecha: %s\n", formatTimeLong(meta.Timestamp))
+				fmt.Printf("   ❓ Motivo: %s\n", meta.Reason)
+				if meta.SnapshotID != "" {
+					fmt.Printf("   🆔 Snapshot: %s\n", meta.SnapshotID)
+				}
+				fmt.Println()
+				continue
+			}
+			
+			// Entradas sin metadatos: antiguas o interrumpidas a medias
+			files, _ := countFilesInDir(trashPath)
+			
+			fmt.Printf("📦 [%s]\n", entry.Name())
+			fmt.Printf("   📁 Archivos: %d\n", files)
+			fmt.Printf("   📅 Fecha: %s\n", info.ModTime().Format("02/01/2006 15:04:05"))
+			fmt.Println("   ⚠️  Sin metadatos: puede estar incompleta ('snapgo gc --orphan-trash')")
+			fmt.Println()
+		}
+	}
+	
+	fmt.Println("💡 Usa 'snapgo trash restore <timestamp>' para restaurar archivos")
+	return nil
+}
+
+// Entradas de la papelera sin metadatos: el movimiento a la papelera se
+// interrumpió antes de terminar o son de una versión muy antigua
+func incompleteTrashEntries(root string) ([]string, error) {
+	_, _, _, _, _, trashDir := repoPaths(root)
+	entries, err := os.ReadDir(trashDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	var incomplete []string
+	for _, entry := range entries {
+		if entry.IsDir() && !fileExists(filepath.Join(trashDir, entry.Name(), trashMetaFile)) {
+			incomplete = append(incomplete, entry.Name())
+		}
+	}
+	return incomplete, nil
+}
+
+func removeIncompleteTrash(root string) error {
+	_, _, _, _, _, trashDir := repoPaths(root)
+	incomplete, err := incompleteTrashEntries(root)
+	if err != nil {
+		return err
+	}
+	if len(incomplete) == 0 {
+		logln("✅ No hay entradas incompletas en la papelera")
+		return nil
+	}
+	
+	fmt.Printf("⚠️  %d entrada(s) de la papelera sin %s (posiblemente incompletas):\n", len(incomplete), trashMetaFile)
+	for _, name := range incomplete {
+		files, _ := countFilesInDir(filepath.Join(trashDir, name))
+		fmt.Printf("   • %s  (%d archivo%s)\n", name, files, plural(files))
+	}
+	if !confirm("¿Eliminarlas de forma permanente?") {
+		fmt.Println("❌ Operación cancelada")
+		return nil
+	}
+	
+	for _, name := range incomplete {
+		if err := os.RemoveAll(filepath.Join(trashDir, name)); err != nil {
+			return err
+		}
+	}
+	logf("✅ %d entrada(s) incompleta(s) eliminadas\n", len(incomplete))
+	return nil
+}
+
+func countFilesInDir(dir string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func emptyTrash(root string) error {
+	_, _, _, _, _, trashDir := repoPaths(root)
+	
+	if _, err := os.Stat(trashDir); os.IsNotExist(err) {
+		fmt.Println("🗑️  La papelera ya está vacía")
+		return nil
+	}
+	
+	fmt.Print("¿Estás seguro de vaciar la papelera? (s/n): ")
+	var response string
+	fmt.Scanln(&response)
+	
+	if strings.ToLower(response) != "s" {
+		fmt.Println("❌ Operación cancelada")
+		return nil
+	}
+	
+	err := os.RemoveAll(trashDir)
+	if err != nil {
+		return err
+	}
+	
+	os.MkdirAll(trashDir, 0o755)
+	
+	logln("✅ Papelera vaciada correctamente")
+	return nil
+}
+
+func restoreFromTrash(root, timestamp string) error {
+	_, _, indexPath, _, _, trashDir := repoPaths(root)
+	
+	trashPath := filepath.Join(trashDir, timestamp)
+	if _, err := os.Stat(trashPath); os.IsNotExist(err) {
+		return fmt.Errorf("no se encontró el timestamp '%s' en la papelera", timestamp)
+	}
+	var meta TrashMeta
+	if err := readJSON(filepath.Join(trashPath, trashMetaFile), &meta); os.IsNotExist(err) {
+		fmt.Printf("⚠️  '%s' no tiene %s: puede que solo contenga parte de los archivos\n", timestamp, trashMetaFile)
+		if !confirm("¿Restaurarla de todos modos?") {
+			fmt.Println("❌ Operación cancelada")
+			return nil
+		}
+	}
+	
+	logf("🔄 Restaurando archivos desde: %s\n", timestamp)
+	
+	restored := 0
+	err := filepath.WalkDir(trashPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		
+		if d.IsDir() {
+			return nil
+		}
+		
+		rel, _ := filepath.Rel(trashPath, path)
+		if rel == trashMetaFile {
+			return nil
+		}
+		dst := filepath.Join(root, rel)
+		
+		dstDir := filepath.Dir(dst)
+		if err := os.MkdirAll(dstDir, 0o755); err != nil {
+			return err
+		}
+		
+		if err := os.Rename(path, dst); err == nil {
+			restored++
+			fmt.Printf("   ✅ Restaurado: %s\n", rel)
+		}
+		
+		return nil
+	})
+	
+	if err != nil {
+		return err
+	}
+	
+	logf("✅ %d archivos restaurados desde la papelera\n", restored)
+	
+	if len(meta.Snapshots) > 0 {
+		var idx Index
+		if err := readJSON(indexPath, &idx); err != nil {
+			return err
+		}
+		known := map[string]bool{}
+		for _, s := range idx.Snapshots {
+			known[s.ID] = true
+		}
+		added := 0
+		// De más nuevo a más antiguo: cada uno se inserta delante de su primer
+		// hijo o del primer snapshot posterior, así el orden no depende de que
+		// las fechas (con resolución de segundos) sean distintas
+		for i := len(meta.Snapshots) - 1; i >= 0; i-- {
+			s := meta.Snapshots[i]
+			if known[s.ID] {
+				continue
+			}
+			pos := len(idx.Snapshots)
+			for j, other := range idx.Snapshots {
+				if other.Parent == s.ID || other.Timestamp > s.Timestamp {
+					pos = j
+					break
+				}
+			}
+			idx.Snapshots = slices.Insert(idx.Snapshots, pos, s)
+			added++
+			// Si era la cabeza de su rama (caso de revert), vuelve a serlo
+			if branch := snapshotBranch(s); idx.Branches[branch] == s.Parent && s.Parent != "" {
+				idx.Branches[branch] = s.ID
+			}
+		}
+		if err := writeJSON(indexPath, idx); err != nil {
+			return err
+		}
+		logf("📦 %d snapshot(s) devueltos al índice\n", added)
+	}
+	
+	os.RemoveAll(trashPath)
+	
+	return nil
+}
+
+// Directorio nuevo de la papelera con ese nombre; si ya existe (dos
+// operaciones en el mismo segundo) se añade un sufijo -2, -3...
+func newTrashSubdir(trashDir, name string) string {
+	base := filepath.Join(trashDir, name)
+	dir := base
+	for k := 2; fileExists(dir); k++ {
+		dir = fmt.Sprintf("%s-%d", base, k)
+	}
+	return dir
+}
+
+// Mueve los archivos de unos snapshots (ya retirados del índice) a una
+// entrada de la papelera, conservando su ruta relativa a la raíz para que
+// 'trash restore' los devuelva a su sitio
+func trashSnapshots(root, reason string, snaps []SnapshotMeta) error {
+	_, _, _, _, _, trashDir := repoPaths(root)
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	
+	// <motivo>_<fecha> (p. ej. pruned_20240102_150405) para distinguirlas
+	// de las entradas con archivos del directorio de trabajo
+	now := time.Now()
+	trashSubdir := newTrashSubdir(trashDir, reason+"_"+now.Format("20060102_150405"))
+	if err := os.MkdirAll(trashSubdir, 0o755); err != nil {
+		return err
+	}
+	
+	moved := 0
+	for _, s := range snaps {
+		archive := snapshotArchive(root, s.ID)
+		for _, path := range []string{archive, archive + ".sig", snapshotFilesPath(root, s.ID)} {
+			if !fileExists(path) {
+				continue
+			}
+			rel, err := filepath.Rel(absRoot, path)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(trashSubdir, rel)
+			if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+				return err
+			}
+			if err := os.Rename(path, dst); err != nil {
+				return err
+			}
+			moved++
+		}
+	}
+	
+	// Los metadatos van los últimos: marcan que la entrada está completa
+	meta := TrashMeta{
+		Reason:    reason,
+		FileCount: moved,
+		Timestamp: now.Format(time.RFC3339),
+		Snapshots: snaps,
+	}
+	if len(snaps) == 1 {
+		meta.SnapshotID = snaps[0].ID
+	}
+	return writeJSON(filepath.Join(trashSubdir, trashMetaFile), meta)
+}
+
+func verifyCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	signatures := fs.Bool("signatures", false, "comprobar también las firmas GPG")
+	deep := fs.Bool("deep", false, "recalcular el hash de contenido de cada snapshot (lento)")
+	repair := fs.Bool("repair", false, "ofrecer reparar entradas sin archivo y archivos sin entrada")
+	parseFlags(fs, os.Args[2:])
+	
+	if *repair {
+		must(repairIndex(rootDir))
+		fmt.Println()
+	}
+	must(verifySnapshots(rootDir, *signatures, *deep))
+}
+
+// Repara, con confirmación, las dos incoherencias entre índice y archivos:
+// entradas cuyo archivo falta (se quitan del índice) y archivos sin entrada
+// (se registran). No borra archivos de snapshot, y antes del primer cambio
+// guarda una copia del índice para poder deshacerlo.
+func repairIndex(root string) error {
+	_, snapsDir, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return fmt.Errorf("índice ilegible: %v", err)
+	}
+	
+	missing := map[string]bool{}
+	ids := map[string]bool{}
+	for _, s := range idx.Snapshots {
+		ids[s.ID] = true
+		if !fileExists(snapshotArchive(root, s.ID)) {
+			missing[s.ID] = true
+		}
+	}
+	
+	orphans := []string{}
+	filepath.WalkDir(snapsDir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(d.Name(), ".tar.gz") {
+			if id := strings.TrimSuffix(d.Name(), ".tar.gz"); !ids[id] {
+				orphans = append(orphans, id)
+			}
+		}
+		return nil
+	})
+	sort.Strings(orphans)
+	
+	if len(missing) == 0 && len(orphans) == 0 {
+		fmt.Println("🔧 Nada que reparar: índice y archivos coinciden")
+		return nil
+	}
+	
+	backup := fmt.Sprintf("%s.bak-%s", indexPath, time.Now().Format("20060102_150405"))
+	if err := writeJSON(backup, idx); err != nil {
+		return fmt.Errorf("error guardando copia del índice: %v", err)
+	}
+	logf("💾 Copia del índice en %s\n", backup)
+	
+	dropped := map[string]bool{}
+	kept := []SnapshotMeta{}
+	for _, s := range idx.Snapshots {
+		if missing[s.ID] && confirm(fmt.Sprintf("❓ Falta el archivo de %s (\"%s\"). ¿Quitarlo del índice?", s.ID, s.Message)) {
+			dropped[s.ID] = true
+			fmt.Printf("   🔧 %s quitado del índice\n", s.ID)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	idx.Snapshots = kept
+	
+	// Las ramas y etiquetas que apuntaban a un snapshot quitado se
+	// recolocan en el último de su rama o se eliminan
+	for _, branch := range sortedKeys(idx.Branches) {
+		if !dropped[idx.Branches[branch]] {
+			continue
+		}
+		delete(idx.Branches, branch)
+		for i := len(idx.Snapshots) - 1; i >= 0; i-- {
+			if snapshotBranch(idx.Snapshots[i]) == branch {
+				idx.Branches[branch] = idx.Snapshots[i].ID
+				break
+			}
+		}
+		fmt.Printf("   🔧 rama '%s' → %s\n", branch, displayHead(idx.Branches[branch]))
+	}
+	for _, tag := range sortedKeys(idx.Tags) {
+		if dropped[idx.Tags[tag]] {
+			delete(idx.Tags, tag)
+			fmt.Printf("   🔧 etiqueta '%s' eliminada\n", tag)
+		}
+	}
+	
+	registered := 0
+	for _, id := range orphans {
+		if !confirm(fmt.Sprintf("❓ %s no está en el índice. ¿Registrarlo?", id)) {
+			continue
+		}
+		meta, err := recoverSnapshotMeta(root, id)
+		if err != nil {
+			fmt.Printf("   ❌ %s: %v\n", id, err)
+			continue
+		}
+		idx.Snapshots = append(idx.Snapshots, meta)
+		registered++
+		fmt.Printf("   🔧 %s registrado (%d archivos)\n", id, meta.FileCount)
+	}
+	// Los registrados se colocan en su sitio cronológico
+	sort.SliceStable(idx.Snapshots, func(i, j int) bool {
+		return idx.Snapshots[i].Timestamp < idx.Snapshots[j].Timestamp
+	})
+	
+	if len(dropped) == 0 && registered == 0 {
+		os.Remove(backup)
+		fmt.Println("🔧 No se hizo ningún cambio")
+		return nil
+	}
+	if err := writeJSON(indexPath, idx); err != nil {
+		return err
+	}
+	fmt.Printf("🔧 %d entrada(s) quitadas, %d snapshot(s) registrados\n", len(dropped), registered)
+	logf("💡 Para deshacerlo, copia %s sobre index.json\n", filepath.Base(backup))
+	return nil
+}
+
+func displayHead(id string) string {
+	if id == "" {
+		return "(sin snapshots)"
+	}
+	return id
+}
+
+// Pregunta s/n por la entrada estándar
+func confirm(question string) bool {
+	fmt.Printf("%s (s/n): ", question)
+	var response string
+	fmt.Scanln(&response)
+	return strings.ToLower(response) == "s"
+}
+
+// Entrada de índice para un archivo huérfano, con lo poco que se sabe de
+// él: la fecha sale del ID y la lista de archivos, del propio archivo. La
+// rama y el mensaje se pierden. Los IDs sin el formato de snapgo y los
+// incrementales (no se sabe su base) se dejan para revisarlos a mano.
+func recoverSnapshotMeta(root, id string) (SnapshotMeta, error) {
+	when, err := time.ParseInLocation("20060102-150405", id[:min(len(id), 15)], time.Local)
+	if err != nil {
+		return SnapshotMeta{}, fmt.Errorf("el ID no tiene el formato de snapgo; revísalo a mano")
+	}
+	var list SnapshotFiles
+	if readJSON(snapshotFilesPath(root, id), &list) == nil && len(list.Inherited) > 0 {
+		return SnapshotMeta{}, fmt.Errorf("es incremental y no se sabe su base; revísalo a mano")
+	}
+	
+	archive := snapshotArchive(root, id)
+	hashes, err := hashArchiveEntries(archive)
+	if err != nil {
+		return SnapshotMeta{}, fmt.Errorf("archivo ilegible (%v)", err)
+	}
+	sum, err := archiveContentHash(archive)
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+	
+	files := sortedKeys(hashes)
+	if err := writeJSON(snapshotFilesPath(root, id), SnapshotFiles{ID: id, Files: files}); err != nil {
+		return SnapshotMeta{}, err
+	}
+	return SnapshotMeta{
+		ID:        id,
+		Timestamp: when.Format(time.RFC3339),
+		Message:   "Recuperado por verify --repair",
+		Hash:      sum,
+		FileCount: len(files),
+	}, nil
+}
+
+// Comprueba que cada snapshot del índice tenga un archivo legible y
+// coherente con sus metadatos. Con signatures, verifica además las firmas.
+// Con deep, recalcula el hash de contenido igual que snapshot: lee cada
+// byte de cada archivo, así que es mucho más lento, pero detecta archivos
+// manipulados aunque el gzip siga siendo válido.
+func verifySnapshots(root string, signatures, deep bool) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	if len(idx.Snapshots) == 0 {
+		fmt.Println("📭 No hay snapshots que verificar")
+		return nil
+	}
+	
+	if signatures {
+		if _, err := lookupTool("gpg"); err != nil {
+			fmt.Printf("⚠️  %v: se omite la verificación de firmas\n", err)
+			signatures = false
+		}
+	}
+	
+	fmt.Printf("🔍 Verificando %d snapshot(s)...\n", len(idx.Snapshots))
+	problems := 0
+	for _, s := range idx.Snapshots {
+		archive := snapshotArchive(root, s.ID)
+		
+		hashes, err := snapshotContentHashes(root, s.ID)
+		if err != nil {
+			fmt.Printf("   ❌ %s: archivo ilegible (%v)\n", s.ID, err)
+			problems++
+			continue
+		}
+		if len(hashes) != s.FileCount {
+			fmt.Printf("   ❌ %s: contiene %d archivos, el índice dice %d\n", s.ID, len(hashes), s.FileCount)
+			problems++
+			continue
+		}
+		
+		if deep {
+			sum, err := storedContentHash(root, s)
+			if err != nil {
+				fmt.Printf("   ❌ %s: %v\n", s.ID, err)
+				problems++
+				continue
+			}
+			if sum != s.Hash {
+				fmt.Printf("   ❌ %s: el contenido no coincide con el hash (%s ≠ %s)\n", s.ID, sum, s.Hash)
+				problems++
+				continue
+			}
+		}
+		
+		if !signatures {
+			fmt.Printf("   ✅ %s\n", s.ID)
+			continue
+		}
+		
+		if !fileExists(archive + ".sig") {
+			// Si se firmó, que falte la firma es una manipulación
+			if s.SignatureKey != "" {
+				fmt.Printf("   ❌ %s: falta la firma (se firmó con %s)\n", s.ID, s.SignatureKey)
+				problems++
+				continue
+			}
+			fmt.Printf("   ⚠️  %s: sin firma\n", s.ID)
+			continue
+		}
+		fingerprint, err := verifyArchiveSignature(archive)
+		if err != nil {
+			fmt.Printf("   ❌ %s: %v\n", s.ID, err)
+			problems++
+			continue
+		}
+		if s.SignatureKey != "" && fingerprint != s.SignatureKey {
+			fmt.Printf("   ❌ %s: firmado por %s, se esperaba %s\n", s.ID, fingerprint, s.SignatureKey)
+			problems++
+			continue
+		}
+		fmt.Printf("   ✅ %s 🔏 %s\n", s.ID, fingerprint)
+	}
+	
+	if problems > 0 {
+		return fmt.Errorf("%d snapshot(s) con problemas", problems)
+	}
+	fmt.Println("✅ Todos los snapshots están íntegros")
+	return nil
+}
+
+func fsckCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	deep := fs.Bool("deep", false, "recalcular también el hash de contenido (lento)")
+	fix := fs.Bool("fix", false, "reparar automáticamente los problemas seguros")
+	parseFlags(fs, os.Args[2:])
+	
+	must(fsckRepo(rootDir, *deep, *fix))
+}
+
+// Problema detectado por fsck. fix es nil si no hay reparación segura.
+type fsckIssue struct {
+	category string
+	message  string
+	fix      func() error
+}
+
+// Categorías de fsck, en el orden en que se informan
+var fsckCategories = []string{"archivos", "contenido", "listas", "huérfanos", "ramas", "etiquetas", "padres", "papelera"}
+
+// Revisión completa: índice legible, archivos de snapshot legibles y
+// coherentes, archivos huérfanos, cabezas de rama, etiquetas, cadenas de
+// padres y entradas incompletas de la papelera. Las reparaciones de --fix nunca borran datos de snapshots.
+func fsckRepo(root string, deep, fix bool) error {
+	_, snapsDir, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return fmt.Errorf("índice ilegible: %v", err)
+	}
+	fmt.Printf("🩺 Revisando %d snapshot(s) en %s...\n", len(idx.Snapshots), root)
+	
+	ids := make(map[string]bool)
+	for _, s := range idx.Snapshots {
+		ids[s.ID] = true
+	}
+	
+	issues := []fsckIssue{}
+	indexChanged := false
+	
+	for i := range idx.Snapshots {
+		s := &idx.Snapshots[i]
+		
+		hashes, err := snapshotContentHashes(root, s.ID)
+		if err != nil {
+			issues = append(issues, fsckIssue{category: "archivos", message: fmt.Sprintf("%s: archivo ilegible (%v)", s.ID, err)})
+			continue
+		}
+		if len(hashes) != s.FileCount {
+			issues = append(issues, fsckIssue{category: "archivos",
+				message: fmt.Sprintf("%s: contiene %d archivos, el índice dice %d", s.ID, len(hashes), s.FileCount)})
+		}
+		
+		if deep {
+			if sum, err := storedContentHash(root, *s); err != nil || sum != s.Hash {
+				issues = append(issues, fsckIssue{category: "contenido",
+					message: fmt.Sprintf("%s: el contenido no coincide con el hash %s", s.ID, s.Hash)})
+			}
+		}
+		
+		if len(s.Files) == 0 && s.FileCount > 0 && !fileExists(snapshotFilesPath(root, s.ID)) {
+			id := s.ID
+			issues = append(issues, fsckIssue{category: "listas",
+				message: fmt.Sprintf("%s: falta la lista de archivos (%s.meta.json)", id, id),
+				fix: func() error {
+					files := make([]string, 0, len(hashes))
+					for name := range hashes {
+						files = append(files, name)
+					}
+					sort.Strings(files)
+					return writeJSON(snapshotFilesPath(root, id), SnapshotFiles{ID: id, Files: files})
+				}})
+		}
+		
+		if s.Parent != "" && !ids[s.Parent] {
+			issues = append(issues, fsckIssue{category: "padres",
+				message: fmt.Sprintf("%s: el padre %s no existe", s.ID, s.Parent),
+				fix: func() error {
+					// Sin padre explícito, parentOf lo deduce de la rama
+					s.Parent = ""
+					indexChanged = true
+					return nil
+				}})
+		}
+	}
+	
+	filepath.WalkDir(snapsDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		switch {
+		case strings.HasSuffix(name, ".tar.gz"):
+			if id := strings.TrimSuffix(name, ".tar.gz"); !ids[id] {
+				issues = append(issues, fsckIssue{category: "huérfanos",
+					message: fmt.Sprintf("%s: archivo sin entrada en el índice", id)})
+			}
+		case strings.HasSuffix(name, ".meta.json"):
+			id := strings.TrimSuffix(name, ".meta.json")
+			if !ids[id] && !fileExists(strings.TrimSuffix(path, ".meta.json")+".tar.gz") {
+				p := path
+				issues = append(issues, fsckIssue{category: "huérfanos",
+					message: fmt.Sprintf("%s: lista de archivos sin snapshot", id),
+					fix:     func() error { return os.Remove(p) }})
+			}
+		}
+		return nil
+	})
+	
+	for _, branch := range sortedKeys(idx.Branches) {
+		head := idx.Branches[branch]
+		if ids[head] {
+			continue
+		}
+		b := branch
+		issues = append(issues, fsckIssue{category: "ramas",
+			message: fmt.Sprintf("rama '%s' apunta a %s, que no existe", b, head),
+			fix: func() error {
+				// Recolocar la cabeza en el último snapshot de la rama
+				delete(idx.Branches, b)
+				for i := len(idx.Snapshots) - 1; i >= 0; i-- {
+					if snapshotBranch(idx.Snapshots[i]) == b {
+						idx.Branches[b] = idx.Snapshots[i].ID
+						break
+					}
+				}
+				indexChanged = true
+				return nil
+			}})
+	}
+	
+	for _, tag := range sortedKeys(idx.Tags) {
+		target := idx.Tags[tag]
+		if ids[target] {
+			continue
+		}
+		t := tag
+		issues = append(issues, fsckIssue{category: "etiquetas",
+			message: fmt.Sprintf("etiqueta '%s' apunta a %s, que no existe", t, target),
+			fix: func() error {
+				delete(idx.Tags, t)
+				indexChanged = true
+				return nil
+			}})
+	}
+	
+	incomplete, _ := incompleteTrashEntries(root)
+	for _, name := range incomplete {
+		issues = append(issues, fsckIssue{category: "papelera",
+			message: fmt.Sprintf("%s: entrada sin %s, posiblemente incompleta (revísala o usa 'gc --orphan-trash')", name, trashMetaFile)})
+	}
+	
+	remaining := 0
+	fixed := 0
+	for _, category := range fsckCategories {
+		found := []fsckIssue{}
+		for _, issue := range issues {
+			if issue.category == category {
+				found = append(found, issue)
+			}
+		}
+		if category == "contenido" && !deep {
+			continue
+		}
+		if len(found) == 0 {
+			fmt.Printf("   ✅ %s\n", category)
+			continue
+		}
+		
+		fmt.Printf("   ❌ %s (%d)\n", category, len(found))
+		for _, issue := range found {
+			switch {
+			case fix && issue.fix != nil:
+				if err := issue.fix(); err != nil {
+					fmt.Printf("      • %s — no se pudo reparar: %v\n", issue.message, err)
+					remaining++
+				} else {
+					fmt.Printf("      • %s — 🔧 reparado\n", issue.message)
+					fixed++
+				}
+			case issue.fix != nil:
+				fmt.Printf("      • %s (reparable con --fix)\n", issue.message)
+				remaining++
+			default:
+				fmt.Printf("      • %s\n", issue.message)
+				remaining++
+			}
+		}
+	}
+	
+	if indexChanged {
+		if err := writeJSON(indexPath, idx); err != nil {
+			return err
+		}
+	}
+	
+	if fixed > 0 {
+		fmt.Printf("\n🔧 %d problema(s) reparados\n", fixed)
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%d problema(s) encontrados", remaining)
+	}
+	fmt.Println("\n✅ El repositorio está sano")
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Recalcula el hash de contenido de un snapshot a partir de su archivo,
+// exactamente como lo hace snapshot: nombre + datos de cada archivo en orden
+func archiveContentHash(archive string) (string, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gr.Close()
+	
+	h := sha256.New()
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		
+		h.Write([]byte(hdr.Name))
+		if hdr.Typeflag == tar.TypeSymlink {
+			h.Write([]byte(hdr.Linkname))
+		} else if _, err := io.Copy(h, tr); err != nil {
+			return "", err
+		}
+	}
+	
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+// Hash de contenido de un snapshot guardado. Los incrementales se
+// reconstruyen en un directorio temporal para calcularlo completo.
+func storedContentHash(root string, s SnapshotMeta) (string, error) {
+	if s.Base == "" {
+		return archiveContentHash(snapshotArchive(root, s.ID))
+	}
+	tmp, err := os.MkdirTemp("", "snapgo-verify-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	
+	if _, _, err := extractSnapshot(root, s.ID, tmp, extractOptions{}); err != nil {
+		return "", err
+	}
+	if err := loadSnapshotFiles(root, &s); err != nil {
+		return "", err
+	}
+	return contentHash(context.Background(), tmp, s.Files, false, nil, 0)
+}
+
+// Firma el checksum SHA-256 de un archivo con la clave GPG por defecto del
+// usuario y guarda la firma separada en <archivo>.sig. Devuelve la huella
+// de la clave firmante.
+func signArchive(archivePath string) (string, error) {
+	gpg, err := lookupTool("gpg")
+	if err != nil {
+		return "", err
+	}
+	
+	sum, err := fileSHA256(archivePath)
+	if err != nil {
+		return "", err
+	}
+	
+	cmd := exec.Command(gpg, "--batch", "--yes", "--detach-sign", "--output", archivePath+".sig")
+	cmd.Stdin = strings.NewReader(sum)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(archivePath + ".sig")
+		return "", fmt.Errorf("gpg falló: %s", strings.TrimSpace(string(out)))
+	}
+	
+	return verifyArchiveSignature(archivePath)
+}
+
+// Verifica <archivo>.sig contra el checksum actual del archivo y devuelve
+// la huella de la clave que lo firmó.
+func verifyArchiveSignature(archivePath string) (string, error) {
+	gpg, err := lookupTool("gpg")
+	if err != nil {
+		return "", err
+	}
+	
+	sum, err := fileSHA256(archivePath)
+	if err != nil {
+		return "", err
+	}
+	
+	cmd := exec.Command(gpg, "--batch", "--status-fd", "1", "--verify", archivePath+".sig", "-")
+	cmd.Stdin = strings.NewReader(sum)
+	out, _ := cmd.Output()
+	
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == "[GNUPG:]" && fields[1] == "VALIDSIG" {
+			return fields[2], nil
+		}
+	}
+	return "", fmt.Errorf("firma inválida")
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func importCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	ours := fs.Bool("ours", false, "en conflicto, conservar el snapshot local")
+	theirs := fs.Bool("theirs", false, "en conflicto, reemplazar el snapshot local por el importado")
+	rename := fs.Bool("rename", false, "en conflicto, importar el snapshot con un ID nuevo")
+	bundle := fs.String("bundle", "", "crear el repositorio a partir de un bundle de 'export --all'")
+	force := fs.Bool("force", false, "con --bundle, reemplazar un repositorio existente")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if *bundle != "" {
+		dest := "."
+		if len(args) > 0 {
+			dest = args[0]
+		}
+		must(importBundle(*bundle, dest, *force))
+		return
+	}
+	
+	if len(args) < 1 {
+		fmt.Println("Uso: import <repo> [--ours|--theirs|--rename] | import --bundle <archivo> [dir] [--force]")
+		return
+	}
+	
+	resolution := ""
+	chosen := 0
+	for name, set := range map[string]bool{"ours": *ours, "theirs": *theirs, "rename": *rename} {
+		if set {
+			resolution = name
+			chosen++
+		}
+	}
+	if chosen > 1 {
+		must(fmt.Errorf("--ours, --theirs y --rename son excluyentes"))
+	}
+	
+	must(importRepo(rootDir, args[0], resolution))
+}
+
+// Snapshot a copiar desde el repositorio de origen
+type importItem struct {
+	meta    SnapshotMeta // Metadatos con el ID que tendrá localmente
+	srcID   string       // ID en el origen
+	replace bool         // Sustituye a un snapshot local con el mismo ID
+}
+
+// Decide qué snapshots importar. Un ID que ya existe con el mismo hash es
+// el mismo snapshot y se omite (importar dos veces no cambia nada); con
+// distinto hash es un conflicto que se resuelve según resolution ("ours",
+// "theirs", "rename") o se devuelve en conflicts si no hay resolución.
+func planImport(local, incoming Index, resolution string) (items []importItem, renamed map[string]string, conflicts []string) {
+	byID := make(map[string]SnapshotMeta)
+	for _, s := range local.Snapshots {
+		byID[s.ID] = s
+	}
+	renamed = make(map[string]string)
+	
+	for _, s := range incoming.Snapshots {
+		existing, ok := byID[s.ID]
+		if !ok {
+			items = append(items, importItem{meta: s, srcID: s.ID})
+			continue
+		}
+		if existing.Hash == s.Hash {
+			continue
+		}
+		
+		switch resolution {
+		case "ours":
+		case "theirs":
+			items = append(items, importItem{meta: s, srcID: s.ID, replace: true})
+		case "rename":
+			for k := 2; ; k++ {
+				candidate := fmt.Sprintf("%s-%d", s.ID, k)
+				other, taken := byID[candidate]
+				if !taken {
+					renamed[s.ID] = candidate
+					meta := s
+					meta.ID = candidate
+					items = append(items, importItem{meta: meta, srcID: s.ID})
+					break
+				}
+				if other.Hash == s.Hash {
+					// Ya importado con este nombre en una ejecución anterior
+					renamed[s.ID] = candidate
+					break
+				}
+			}
+		default:
+			conflicts = append(conflicts, fmt.Sprintf("%s: hash local %s, importado %s", s.ID, existing.Hash, s.Hash))
+		}
+	}
+	
+	// Los padres y las bases que apuntan a snapshots renombrados siguen al
+	// nuevo ID
+	for i := range items {
+		if newID, ok := renamed[items[i].meta.Parent]; ok {
+			items[i].meta.Parent = newID
+		}
+		if newID, ok := renamed[items[i].meta.Base]; ok {
+			items[i].meta.Base = newID
+		}
+	}
+	return items, renamed, conflicts
+}
+
+// Un incremental solo se puede importar si su base llega en la misma
+// importación o ya está en el repositorio local con el mismo contenido
+func checkImportBases(local, incoming Index, items []importItem, renamed map[string]string) error {
+	localByID := make(map[string]SnapshotMeta)
+	for _, s := range local.Snapshots {
+		localByID[s.ID] = s
+	}
+	incomingByID := make(map[string]SnapshotMeta)
+	for _, s := range incoming.Snapshots {
+		incomingByID[s.ID] = s
+	}
+	imported := make(map[string]bool)
+	for _, item := range items {
+		imported[item.meta.ID] = true
+	}
+	// ID en el origen de cada snapshot renombrado
+	original := make(map[string]string)
+	for src, id := range renamed {
+		original[id] = src
+	}
+	
+	for _, item := range items {
+		base := item.meta.Base
+		if base == "" || imported[base] {
+			continue
+		}
+		srcBase := base
+		if src, ok := original[base]; ok {
+			srcBase = src
+		}
+		l, inLocal := localByID[base]
+		in, inIncoming := incomingByID[srcBase]
+		if inLocal && inIncoming && l.Hash == in.Hash {
+			continue
+		}
+		return fmt.Errorf("%s es incremental y su base %s no se importa ni está aquí con el mismo contenido", item.srcID, base)
+	}
+	return nil
+}
+
+// Indica si anc es id o uno de sus antecesores
+func isAncestor(idx Index, anc, id string) bool {
+	position := make(map[string]int)
+	for i, s := range idx.Snapshots {
+		position[s.ID] = i
+	}
+	for id != "" {
+		if id == anc {
+			return true
+		}
+		i, ok := position[id]
+		if !ok {
+			return false
+		}
+		id = parentOf(idx, i)
+	}
+	return false
+}
+
+func exportCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	all := fs.Bool("all", false, "exportar todo el repositorio como bundle")
+	format := fs.String("format", "tar.gz", "formato de salida: tar.gz o zip")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if *format != "tar.gz" && *format != "zip" {
+		must(fmt.Errorf("formato desconocido '%s' (usa tar.gz o zip)", *format))
+	}
+	zipped := *format == "zip"
+	
+	if *all {
+		if len(args) != 1 {
+			fmt.Println("Uso: export --all <bundle.tar.gz> | export --all --format zip <archivo.zip>")
+			return
+		}
+		if zipped {
+			must(exportAllZip(rootDir, args[0]))
+			return
+		}
+		must(exportBundle(rootDir, args[0]))
+		return
+	}
+	
+	if len(args) != 2 {
+		fmt.Println("Uso: export <id> <archivo.tar.gz> | export --all <bundle.tar.gz> [--format zip]")
+		return
+	}
+	if zipped {
+		must(exportSnapshotZip(rootDir, args[0], args[1]))
+		return
+	}
+	must(exportSnapshot(rootDir, args[0], args[1]))
+}
+
+// Exporta el contenido de un snapshot como .zip, para abrirlo sin snapgo
+// (p. ej. en Windows)
+func exportSnapshotZip(root, ref, out string) error {
+	id, err := resolveSpecialID(root, ref)
+	if err != nil {
+		return err
+	}
+	if !fileExists(snapshotArchive(root, id)) {
+		return fmt.Errorf("snapshot '%s' no encontrado", id)
+	}
+	
+	count, err := writeZip(out, func(zw *zip.Writer) (int, error) {
+		return addSnapshotToZip(zw, root, id, "")
+	})
+	if err != nil {
+		return fmt.Errorf("no se pudo exportar '%s': %v", id, err)
+	}
+	logf("📤 Snapshot %s exportado a %s (%d archivo%s)\n", id, out, count, plural(count))
+	return nil
+}
+
+// Exporta todos los snapshots en un único .zip, cada uno en una carpeta <id>/
+func exportAllZip(root, out string) error {
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return fmt.Errorf("'%s' no es un repositorio SnapGo", root)
+	}
+	if len(idx.Snapshots) == 0 {
+		return fmt.Errorf("no hay snapshots que exportar")
+	}
+	
+	count, err := writeZip(out, func(zw *zip.Writer) (int, error) {
+		total := 0
+		for _, s := range idx.Snapshots {
+			n, err := addSnapshotToZip(zw, root, s.ID, s.ID+"/")
+			if err != nil {
+				return total, fmt.Errorf("%s: %v", s.ID, err)
+			}
+			total += n
+		}
+		return total, nil
+	})
+	if err != nil {
+		return err
+	}
+	logf("📦 %d snapshot%s exportado%s a %s (%d archivo%s)\n",
+		len(idx.Snapshots), plural(len(idx.Snapshots)), plural(len(idx.Snapshots)), out, count, plural(count))
+	return nil
+}
+
+// Crea out con el contenido que escribe fill; si algo falla no deja un
+// .zip a medias
+func writeZip(out string, fill func(zw *zip.Writer) (int, error)) (int, error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	zw := zip.NewWriter(f)
+	count, err := fill(zw)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		return 0, err
+	}
+	return count, nil
+}
+
+// Copia las entradas de un snapshot al zip bajo prefix, en streaming desde
+// el .tar.gz. Conserva los permisos y los enlaces simbólicos.
+func addSnapshotToZip(zw *zip.Writer, root, id, prefix string) (int, error) {
+	count := 0
+	err := walkSnapshotEntries(root, id, func(hdr *tar.Header, r io.Reader) error {
+		zh := &zip.FileHeader{Name: prefix + hdr.Name, Method: zip.Deflate, Modified: hdr.ModTime}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			zh.SetMode(os.FileMode(hdr.Mode).Perm())
+		case tar.TypeSymlink:
+			// Como en Info-ZIP: el contenido de la entrada es el destino
+			zh.SetMode(os.ModeSymlink | 0o777)
+			r = strings.NewReader(hdr.Linkname)
+		default:
+			return nil
+		}
+		w, err := zw.CreateHeader(zh)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func exportSnapshot(root, ref, out string) error {
+	id, err := resolveSpecialID(root, ref)
+	if err != nil {
+		return err
+	}
+	s, err := findSnapshot(root, id)
+	if err != nil {
+		return err
+	}
+	
+	var n int64
+	if s.Base == "" {
+		n, err = copyFileVerified(snapshotArchive(root, id), out)
+	} else {
+		// Un incremental solo guarda lo cambiado: se exporta completo
+		n, err = writeFullSnapshotArchive(root, id, out)
+	}
+	if err != nil {
+		return fmt.Errorf("no se pudo exportar '%s': %v", id, err)
+	}
+	logf("📤 Snapshot %s exportado a %s (%s)\n", id, out, formatSize(n))
+	return nil
+}
+
+// Escribe en out un .tar.gz con todos los archivos de un snapshot,
+// incluidos los que un incremental hereda de su base. Devuelve su tamaño.
+func writeFullSnapshotArchive(root, id, out string) (int64, error) {
+	config, _ := loadConfig(root)
+	f, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	gw, err := gzip.NewWriterLevel(f, config.Compression)
+	if err != nil {
+		f.Close()
+		os.Remove(out)
+		return 0, err
+	}
+	tw := tar.NewWriter(gw)
+	err = walkSnapshotEntries(root, id, func(hdr *tar.Header, r io.Reader) error {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		return 0, err
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Manifiesto de un bundle: SHA-256 de cada entrada, para comprobarlas al importar
+const bundleManifest = "snapgo-bundle.json"
+
+type BundleManifest struct {
+	Version string            `json:"version"`
+	Created string            `json:"created"`
+	Files   map[string]string `json:"files"` // ruta en el bundle → sha256
+}
+
+// Empaqueta índice, configuración, .snapgoignore y todos los archivos de
+// snapshots en un único .tar.gz. La papelera no se incluye.
+func exportBundle(root, out string) error {
+	snapgoDir, snapsDir, indexPath, configPath, ignorePath, _ := repoPaths(root)
+	if !fileExists(indexPath) {
+		return fmt.Errorf("'%s' no es un repositorio SnapGo", root)
+	}
+	
+	sources := map[string]string{} // ruta en el bundle → ruta en disco
+	for _, p := range []string{indexPath, configPath} {
+		if fileExists(p) {
+			rel, _ := filepath.Rel(filepath.Dir(snapgoDir), p)
+			sources[filepath.ToSlash(rel)] = p
+		}
+	}
+	if fileExists(ignorePath) {
+		sources[".snapgoignore"] = ignorePath
+	}
+	err := filepath.WalkDir(snapsDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(filepath.Dir(snapgoDir), path)
+		sources[filepath.ToSlash(rel)] = path
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	
+	manifest := BundleManifest{Version: "1", Created: time.Now().Format(time.RFC3339), Files: map[string]string{}}
+	var total int64
+	for _, name := range sortedKeys(sources) {
+		info, err := os.Stat(sources[name])
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		
+		in, err := os.Open(sources[name])
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(tw, h), in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		manifest.Files[name] = hex.EncodeToString(h.Sum(nil))
+		total += n
+	}
+	
+	// El manifiesto va al final, cuando ya se conocen todos los hashes
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: bundleManifest, Mode: 0o644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	
+	logf("📦 Bundle creado: %s (%d archivos, %s sin comprimir)\n", out, len(sources), formatSize(total))
+	return nil
+}
+
+// Desempaqueta un bundle de 'export --all' en dest. Las entradas se
+// extraen primero a un directorio temporal y solo se colocan en su sitio
+// si todos los checksums coinciden con el manifiesto.
+func importBundle(bundle, dest string, force bool) error {
+	snapgoDir, snapsDir, indexPath, _, ignorePath, trashDir := repoPaths(dest)
+	if fileExists(indexPath) && !force {
+		return fmt.Errorf("ya existe un repositorio SnapGo en '%s' (usa --force para reemplazarlo)", dest)
+	}
+	
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(dest, ".snapgo-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	
+	f, err := os.Open(bundle)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("'%s' no es un bundle válido: %v", bundle, err)
+	}
+	defer gr.Close()
+	
+	sums := map[string]string{}
+	var manifest *BundleManifest
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		
+		if hdr.Name == bundleManifest {
+			manifest = &BundleManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return fmt.Errorf("manifiesto ilegible: %v", err)
+			}
+			continue
+		}
+		
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.ToSlash(filepath.Clean(hdr.Name))
+		if name != ".snapgoignore" && !strings.HasPrefix(name, ".snapgo/") {
+			return fmt.Errorf("entrada inesperada en el bundle: %s", hdr.Name)
+		}
+		
+		target := filepath.Join(staging, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(out, h), tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	
+	if manifest == nil {
+		return fmt.Errorf("el bundle no tiene manifiesto (%s)", bundleManifest)
+	}
+	bad := []string{}
+	for name, want := range manifest.Files {
+		if sums[name] != want {
+			bad = append(bad, name)
+		}
+	}
+	for name := range sums {
+		if _, ok := manifest.Files[name]; !ok {
+			bad = append(bad, name)
+		}
+	}
+	if len(bad) > 0 {
+		sort.Strings(bad)
+		return fmt.Errorf("checksums incorrectos en el bundle: %s", strings.Join(bad, ", "))
+	}
+	if _, ok := sums[".snapgo/index.json"]; !ok {
+		return fmt.Errorf("el bundle no contiene index.json")
+	}
+	
+	// Todo verificado: reemplazar el repositorio (la papelera se conserva)
+	if err := os.RemoveAll(snapsDir); err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(sums) {
+		target := filepath.Join(dest, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(staging, filepath.FromSlash(name)), target); err != nil {
+			return err
+		}
+	}
+	for _, dir := range []string{snapsDir, trashDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	logf("📥 Bundle importado en %s: %d snapshot(s)\n", snapgoDir, len(idx.Snapshots))
+	if fileExists(ignorePath) {
+		logln("   🚫 .snapgoignore restaurado")
+	}
+	logln("💡 Usa 'snapgo restore HEAD --force' para recuperar el árbol de trabajo")
+	return nil
+}
+
+// Importa los snapshots de otro repositorio SnapGo (p. ej. un clon en otra
+// máquina), junto con sus ramas y etiquetas
+func importRepo(root, src, resolution string) error {
+	_, _, srcIndex, _, _, _ := repoPaths(src)
+	_, snapsDir, indexPath, _, _, _ := repoPaths(root)
+	
+	var incoming, idx Index
+	if err := readJSON(srcIndex, &incoming); err != nil {
+		return fmt.Errorf("'%s' no es un repositorio SnapGo: %v", src, err)
+	}
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
+	}
+	
+	items, renamed, conflicts := planImport(idx, incoming, resolution)
+	if len(conflicts) > 0 {
+		fmt.Printf("⚔️  %d conflicto(s): mismo ID con distinto contenido\n", len(conflicts))
+		for _, c := range conflicts {
+			fmt.Printf("   • %s\n", c)
+		}
+		fmt.Println("💡 Usa --ours (conservar local), --theirs (usar el importado) o --rename (importar con otro ID)")
+		return fmt.Errorf("importación cancelada por conflictos")
+	}
+	if err := checkImportBases(idx, incoming, items, renamed); err != nil {
+		return err
+	}
+	
+	config, _ := loadConfig(root)
+	for _, item := range items {
+		from := strings.TrimSuffix(snapshotArchive(src, item.srcID), ".tar.gz")
+		if item.replace {
+			removeSnapshotFiles(root, item.meta.ID)
+		}
+		to := strings.TrimSuffix(archivePathFor(snapsDir, item.meta.ID, config.ArchiveLayout), ".tar.gz")
+		if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+			return err
+		}
+		
+		if _, err := copyFileVerified(from+".tar.gz", to+".tar.gz"); err != nil {
+			return fmt.Errorf("%s: %v", item.srcID, err)
+		}
+		if fileExists(from + ".tar.gz.sig") {
+			copyFileVerified(from+".tar.gz.sig", to+".tar.gz.sig")
+		}
+		
+		// La lista del origen tal cual (con Inherited en los incrementales);
+		// los índices antiguos la llevan dentro de los metadatos
+		var sf SnapshotFiles
+		if err := readJSON(from+".meta.json", &sf); err != nil {
+			sf = SnapshotFiles{Files: item.meta.Files}
+		}
+		sf.ID = item.meta.ID
+		if err := writeJSON(to+".meta.json", sf); err != nil {
+			return err
+		}
+		item.meta.Files = nil
+		
+		if item.replace {
+			for i := range idx.Snapshots {
+				if idx.Snapshots[i].ID == item.meta.ID {
+					idx.Snapshots[i] = item.meta
+				}
 			}
+		} else {
+			idx.Snapshots = append(idx.Snapshots, item.meta)
 		}
-	} else if time1.Before(time2) {
-		older = snap1
-		newer = snap2
-	} else {
-		older = snap2
-		newer = snap1
 	}
 	
-	setOlder := make(map[string]bool)
-	setNewer := make(map[string]bool)
+	// Mantener el índice en orden cronológico (los IDs empiezan por la fecha)
+	sort.SliceStable(idx.Snapshots, func(i, j int) bool {
+		return idx.Snapshots[i].ID < idx.Snapshots[j].ID
+	})
 	
-	for _, f := range older.Files {
-		setOlder[f] = true
+	mapID := func(id string) string {
+		if newID, ok := renamed[id]; ok {
+			return newID
+		}
+		return id
 	}
-	for _, f := range newer.Files {
-		setNewer[f] = true
+	
+	if idx.Branches == nil {
+		idx.Branches = make(map[string]string)
+	}
+	for _, branch := range sortedKeys(incoming.Branches) {
+		head := mapID(incoming.Branches[branch])
+		local, ok := idx.Branches[branch]
+		switch {
+		case !ok || isAncestor(idx, local, head):
+			idx.Branches[branch] = head
+		case isAncestor(idx, head, local):
+			// La rama local ya contiene la importada
+		default:
+			diverged := branch + "-import"
+			idx.Branches[diverged] = head
+			fmt.Printf("⚠️  La rama '%s' ha divergido; la versión importada queda en '%s'\n", branch, diverged)
+		}
+	}
+	
+	if idx.Tags == nil {
+		idx.Tags = make(map[string]string)
+	}
+	for _, tag := range sortedKeys(incoming.Tags) {
+		target := mapID(incoming.Tags[tag])
+		if local, ok := idx.Tags[tag]; ok && local != target {
+			fmt.Printf("⚠️  La etiqueta '%s' ya apunta a %s; se conserva\n", tag, local)
+			continue
+		}
+		idx.Tags[tag] = target
 	}
 	
-	added := []string{}
-	removed := []string{}
+	if err := writeJSON(indexPath, idx); err != nil {
+		return err
+	}
 	
-	for f := range setNewer {
-		if !setOlder[f] {
-			added = append(added, f)
+	logf("✅ %d snapshot(s) importados desde %s\n", len(items), src)
+	for _, item := range items {
+		if item.srcID != item.meta.ID {
+			logf("   🔀 %s → %s\n", item.srcID, item.meta.ID)
+		}
+	}
+	return nil
+}
+
+func cloneCmd() {
+	fs := flag.NewFlagSet("clone", flag.ExitOnError)
+	bare := fs.Bool("bare", false, "no restaurar el árbol de trabajo")
+	withTrash := fs.Bool("trash", false, "copiar también la papelera")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if len(args) < 2 {
+		fmt.Println("Uso: clone <origen> <destino> [--bare] [--trash]")
+		return
+	}
+	
+	must(cloneRepo(args[0], args[1], *bare, *withTrash))
+}
+
+// Copia el directorio .snapgo completo de src a dst verificando el checksum
+// de cada archivo copiado. El índice y la configuración solo guardan rutas
+// relativas, así que no hay rutas absolutas que reescribir. Sin bare, el
+// último snapshot se restaura en dst como árbol de trabajo.
+func cloneRepo(src, dst string, bare, withTrash bool) error {
+	srcSnapgo, _, srcIndex, _, srcIgnore, srcTrash := repoPaths(src)
+	dstSnapgo, dstSnaps, dstIndex, _, dstIgnore, dstTrash := repoPaths(dst)
+	
+	if !fileExists(srcIndex) {
+		return fmt.Errorf("'%s' no es un repositorio SnapGo", src)
+	}
+	if fileExists(dstIndex) {
+		return fmt.Errorf("ya existe un repositorio SnapGo en '%s'", dst)
+	}
+	
+	logf("📥 Clonando %s → %s\n", srcSnapgo, dstSnapgo)
+	
+	var totalBytes int64
+	copied := 0
+	failures := []string{}
+	
+	err := filepath.WalkDir(srcSnapgo, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		
+		if d.IsDir() {
+			if path == srcTrash && !withTrash {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
+		rel, _ := filepath.Rel(srcSnapgo, path)
+		target := filepath.Join(dstSnapgo, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
 		}
-	}
-	
-	for f := range setOlder {
-		if !setNewer[f] {
-			removed = append(removed, f)
+		
+		n, err := copyFileVerified(path, target)
+		totalBytes += n
+		if err != nil {
+			fmt.Printf("   ❌ %s: %v\n", filepath.ToSlash(rel), err)
+			failures = append(failures, rel)
+			return nil
 		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	
-	fmt.Printf("📊 Comparación: %s → %s\n", older.ID, newer.ID)
-	fmt.Printf("📅 Fecha: %s → %s\n", 
-		formatTime(older.Timestamp), 
-		formatTime(newer.Timestamp))
-	fmt.Printf("📝 Mensajes: \"%s\" → \"%s\"\n",
-		older.Message, newer.Message)
-	
-	if len(added) > 0 {
-		fmt.Println("\n➕ Archivos añadidos:")
-		for _, f := range added {
-			fmt.Printf("   • %s\n", f)
-		}
+	if err := os.MkdirAll(dstSnaps, 0o755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dstTrash, 0o755); err != nil {
+		return err
 	}
 	
-	if len(removed) > 0 {
-		fmt.Println("\n➖ Archivos eliminados:")
-		for _, f := range removed {
-			fmt.Printf("   • %s\n", f)
+	if fileExists(srcIgnore) {
+		if n, err := copyFileVerified(srcIgnore, dstIgnore); err != nil {
+			fmt.Printf("   ❌ .snapgoignore: %v\n", err)
+			failures = append(failures, ".snapgoignore")
+		} else {
+			totalBytes += n
+			copied++
 		}
 	}
 	
-	commonFiles := []string{}
-	for f := range setOlder {
-		if setNewer[f] {
-			commonFiles = append(commonFiles, f)
+	if !bare {
+		var idx Index
+		if err := readJSON(dstIndex, &idx); err != nil {
+			return err
+		}
+		if len(idx.Snapshots) > 0 {
+			head := idx.Snapshots[len(idx.Snapshots)-1]
+			if err := checkSnapshotChain(dst, head.ID); err != nil {
+				return fmt.Errorf("error restaurando árbol de trabajo: %v", err)
+			}
+			if _, _, err := extractSnapshot(dst, head.ID, dst, extractOptions{}); err != nil {
+				return fmt.Errorf("error restaurando árbol de trabajo: %v", err)
+			}
+			logf("🌳 Árbol de trabajo restaurado desde %s\n", head.ID)
 		}
 	}
 	
-	if len(commonFiles) > 0 && (len(added) > 0 || len(removed) > 0) {
-		fmt.Printf("\n🔸 %d archivos en ambos snapshots (podrían estar modificados)\n", len(commonFiles))
-	}
+	logf("✅ Repositorio clonado en %s\n", dst)
+	logf("   📦 %d archivos copiados (%s)\n", copied, formatSize(totalBytes))
 	
-	if len(added) == 0 && len(removed) == 0 {
-		fmt.Println("\n✅ No hay diferencias en la lista de archivos")
+	if len(failures) > 0 {
+		return fmt.Errorf("%d archivo(s) fallaron la verificación de integridad", len(failures))
 	}
-	
 	return nil
 }
 
-// Nueva versión de statusCmd que acepta directorio raíz
-func statusCmdWithRoot(root string) error {
-	_, _, indexPath, _, _, _ := repoPaths(root)
-	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
-		fmt.Println("❌ No es un repositorio SnapGo")
-		fmt.Println("💡 Usa 'snapgo init' para crear uno")
-		return nil
+// Copia un archivo y comprueba que el destino tenga el mismo SHA-256
+// que el origen leído
+func copyFileVerified(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
 	}
+	defer in.Close()
 	
-	var idx Index
-	if err := readJSON(indexPath, &idx); err != nil {
-		return err
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
 	}
 	
-	fmt.Printf("📊 Estado del Repositorio (en %s)\n", root)
-	fmt.Println("══════════════════════════════════════════")
-	
-	if len(idx.Snapshots) == 0 {
-		fmt.Println("📭 No hay snapshots todavía")
-	} else {
-		last := idx.Snapshots[len(idx.Snapshots)-1]
-		t, _ := time.Parse(time.RFC3339, last.Timestamp)
-		fmt.Printf("🕒 Último snapshot: %s (%s)\n", last.ID, t.Format("02/01 15:04"))
-		fmt.Printf("📝 Mensaje: %s\n", last.Message)
+	h := sha256.New()
+	n, err := io.Copy(out, io.TeeReader(in, h))
+	if cerr := out.Close(); err == nil {
+		err = cerr
 	}
-	
-	ignores, err := loadIgnore(root)
 	if err != nil {
-		return err
+		return n, err
 	}
 	
-	currentFiles, err := collectFiles(root, ignores)
+	sum, err := fileSHA256(dst)
 	if err != nil {
-		return err
+		return n, err
 	}
-	
-	if len(idx.Snapshots) > 0 {
-		lastFiles := idx.Snapshots[len(idx.Snapshots)-1].Files
-		setLast := make(map[string]bool)
-		for _, f := range lastFiles {
-			setLast[f] = true
-		}
-		
-		newFiles := []string{}
-		
-		for _, f := range currentFiles {
-			if !setLast[f] {
-				newFiles = append(newFiles, f)
-			}
-		}
-		
-		if len(newFiles) > 0 {
-			fmt.Println("\n🆕 Archivos nuevos no versionados:")
-			for _, f := range newFiles {
-				fmt.Printf("   • %s\n", f)
-			}
-		} else {
-			fmt.Println("\n✅ No hay archivos nuevos")
-		}
-	} else {
-		fmt.Printf("\n🆕 Archivos listos para el primer snapshot: %d\n", len(currentFiles))
-		if len(currentFiles) > 0 && len(currentFiles) <= 10 {
-			for _, f := range currentFiles {
-				fmt.Printf("   • %s\n", f)
-			}
-		} else if len(currentFiles) > 10 {
-			fmt.Printf("   (mostrando 10 de %d)\n", len(currentFiles))
-			for i := 0; i < 10 && i < len(currentFiles); i++ {
-				fmt.Printf("   • %s\n", currentFiles[i])
-			}
-		}
+	if sum != hex.EncodeToString(h.Sum(nil)) {
+		return n, fmt.Errorf("checksum no coincide tras la copia")
 	}
-	
-	fmt.Println("\n💡 Usa 'snapgo snapshot -m \"mensaje\"' para guardar cambios")
-	return nil
+	return n, nil
 }
 
-// Nueva versión de historyCmd que acepta directorio raíz
-func historyCmdWithRoot(root string) error {
-	_, _, indexPath, _, _, _ := repoPaths(root)
+// Nueva versión de gitModeCmd que acepta directorio raíz
+func gitModeCmdWithRoot(cmd, root string) {
+	if _, err := lookupTool("git"); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		fmt.Println("   Instala Git o desactiva el modo Git en la configuración")
+		return
+	}
 	
-	var idx Index
-	if err := readJSON(indexPath, &idx); err != nil {
-		return err
+	config, err := loadConfig(root)
+	if err != nil {
+		fmt.Println("❌ No se pudo cargar la configuración")
+		return
 	}
 	
-	if len(idx.Snapshots) == 0 {
-		fmt.Println("📭 No hay historial de snapshots")
-		return nil
+	if !config.GitMode {
+		fmt.Println("❌ Modo Git no está activado")
+		fmt.Println("   Actívalo en .snapgo/config.json con \"git_mode\": true")
+		return
 	}
 	
-	fmt.Printf("📜 Historial de Snapshots (en %s)\n", root)
-	fmt.Println("══════════════════════════════════════════")
+	remote, branch := valueOr(config.GitRemote, "origin"), valueOr(config.GitBranch, "main")
 	
-	for i := len(idx.Snapshots) - 1; i >= 0; i-- {
-		s := idx.Snapshots[i]
-		t, _ := time.Parse(time.RFC3339, s.Timestamp)
-		
-		now := time.Now()
-		diff := now.Sub(t)
-		
-		var timeStr string
-		if diff < time.Hour {
-			timeStr = "hace unos minutos"
-		} else if diff < 24*time.Hour {
-			hours := int(diff.Hours())
-			timeStr = fmt.Sprintf("hace %d hora%s", hours, plural(hours))
-		} else if diff < 7*24*time.Hour {
-			days := int(diff.Hours() / 24)
-			timeStr = fmt.Sprintf("hace %d día%s", days, plural(days))
-		} else {
-			timeStr = t.Format("02 Jan 2006")
+	switch cmd {
+	case "git-sync":
+		runGitCommand("pull", remote, branch)
+	case "git-save":
+		if len(os.Args) < 3 {
+			fmt.Println("Uso: save \"mensaje\"")
+			return
 		}
-		
-		fmt.Printf("\n🆔 [%s]\n", s.ID)
-		fmt.Printf("   📅 %s | 📁 %d archivos\n", timeStr, s.FileCount)
-		fmt.Printf("   📝 %s\n", s.Message)
-		
-		if i > 0 {
-			fmt.Println("   ──────────────────────────────────────")
+		// Varios argumentos sin comillas forman un único mensaje
+		message := strings.Join(os.Args[2:], " ")
+		runGitCommand("commit", "-am", message)
+	case "git-back":
+		if len(os.Args) < 3 {
+			fmt.Println("Uso: back <id>")
+			return
+		}
+		runGitCommand("checkout", os.Args[2])
+	case "git-share":
+		runGitCommand("push", remote, branch)
+	}
+}
+
+func valueOr(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// Ejecuta git con los argumentos tal cual, sin pasar por un shell: un
+// mensaje con espacios llega a git como un solo argumento
+func runGitCommand(args ...string) {
+	shown := make([]string, len(args))
+	for i, a := range args {
+		shown[i] = a
+		if a == "" || strings.ContainsAny(a, " \t\"'") {
+			shown[i] = strconv.Quote(a)
 		}
 	}
+	logf("🐱 [GIT] Ejecutando: git %s\n", strings.Join(shown, " "))
 	
-	return nil
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("❌ Comando Git falló: %v\n", err)
+	}
 }
 
-// Nueva versión de cleanCmd que acepta directorio raíz
-func cleanCmdWithRoot(root string) error {
-	config, err := loadConfig(root)
+// Resuelve una herramienta externa en el PATH. Devuelve un error claro en
+// lugar del error crudo de exec cuando no está instalada.
+func lookupTool(name string) (string, error) {
+	path, err := exec.LookPath(name)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("herramienta '%s' no encontrada en PATH", name)
+	}
+	return path, nil
+}
+
+// Mensaje del último commit de git en root. Falla si no hay git, root no
+// está en un repositorio git o aún no tiene commits.
+func gitLastCommitMessage(root string) (string, error) {
+	if _, err := lookupTool("git"); err != nil {
+		return "", err
+	}
+	out, err := exec.Command("git", "-C", root, "log", "-1", "--pretty=%B").Output()
+	if err != nil {
+		return "", fmt.Errorf("no hay ningún commit de git en '%s'", root)
+	}
+	msg := strings.TrimSpace(string(out))
+	if msg == "" {
+		return "", fmt.Errorf("el último commit de git no tiene mensaje")
+	}
+	return msg, nil
+}
+
+// Abre $VISUAL o $EDITOR para escribir el mensaje de un snapshot.
+// Si no hay editor configurado o no existe, devuelve un error y el
+// llamador debe exigir -m. Como en git, el archivo temporal lleva una
+// plantilla con líneas '#' que se eliminan al guardar.
+func messageFromEditor(template string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	parts := strings.Fields(editor)
+	if len(parts) == 0 {
+		return "", fmt.Errorf("no hay editor configurado ($EDITOR)")
 	}
 	
-	_, _, indexPath, _, _, _ := repoPaths(root)
+	path, err := lookupTool(parts[0])
+	if err != nil {
+		return "", err
+	}
 	
-	var idx Index
-	if err := readJSON(indexPath, &idx); err != nil {
-		return err
+	tmp, err := os.CreateTemp("", "snapgo-msg-*.txt")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.WriteString(template)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
 	}
+	defer os.Remove(tmp.Name())
 	
-	if len(idx.Snapshots) <= config.MaxSnapshots {
-		fmt.Printf("✅ Ya tienes %d snapshots (límite: %d)\n", len(idx.Snapshots), config.MaxSnapshots)
-		return nil
+	cmd := exec.Command(path, append(parts[1:], tmp.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("el editor terminó con error: %v", err)
 	}
 	
-	toRemove := len(idx.Snapshots) - config.MaxSnapshots
-	fmt.Printf("🧹 Limpiando %d snapshot(s) antiguo(s)...\n", toRemove)
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return "", err
+	}
 	
-	removed := 0
-	_, snapsDir, _, _, _, _ := repoPaths(root)
+	message := stripCommentLines(string(data))
+	if message == "" {
+		return "", fmt.Errorf("mensaje vacío, snapshot cancelado")
+	}
+	return message, nil
+}
+
+// Máximo de archivos listados en la plantilla del editor
+const editorTemplateMaxFiles = 50
+
+// Plantilla comentada del mensaje: rama, fecha y archivos que se guardarán
+func editorTemplate(root string) string {
+	var sb strings.Builder
+	sb.WriteString("\n")
+	sb.WriteString("# Escribe el mensaje del snapshot. Las líneas que empiezan por '#'\n")
+	sb.WriteString("# se ignoran y un mensaje vacío cancela el snapshot.\n")
+	sb.WriteString("#\n")
 	
-	for i := 0; i < toRemove && i < len(idx.Snapshots); i++ {
-		s := idx.Snapshots[i]
-		archive := filepath.Join(snapsDir, s.ID+".tar.gz")
-		
-		if err := os.Remove(archive); err == nil {
-			fmt.Printf("   🗑️  Eliminado: %s\n", s.ID)
-			removed++
-		}
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	var idx Index
+	if readJSON(indexPath, &idx) == nil {
+		sb.WriteString(fmt.Sprintf("# Rama: %s\n", snapshotBranch(SnapshotMeta{Branch: idx.Current})))
 	}
+	sb.WriteString(fmt.Sprintf("# Fecha: %s\n", time.Now().Format("2006-01-02 15:04:05")))
 	
-	if removed > 0 {
-		idx.Snapshots = idx.Snapshots[removed:]
-		if err := writeJSON(indexPath, idx); err != nil {
-			return err
+	ignores, _ := loadIgnore(root)
+	files, err := collectFiles(root, ignores)
+	if err != nil {
+		return sb.String()
+	}
+	sb.WriteString("#\n")
+	sb.WriteString(fmt.Sprintf("# Archivos incluidos (%d):\n", len(files)))
+	for i, f := range files {
+		if i == editorTemplateMaxFiles {
+			sb.WriteString(fmt.Sprintf("#   ... y %d más\n", len(files)-i))
+			break
 		}
+		sb.WriteString("#   " + f + "\n")
 	}
-	
-	fmt.Printf("✅ Limpieza completada. %d snapshots eliminados.\n", removed)
-	return nil
+	return sb.String()
 }
 
-// Nueva versión de branchCmd que acepta directorio raíz
-func branchCmdWithRoot(rootDir string) {
-	if len(os.Args) < 3 {
-		listBranchesWithRoot(rootDir)
-		return
+// Elimina las líneas de comentario ('#') del mensaje y los espacios sobrantes
+func stripCommentLines(text string) string {
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		kept = append(kept, strings.TrimRight(line, " \t\r"))
 	}
-	
-	branchName := os.Args[2]
-	must(createBranch(rootDir, branchName))
+	return strings.TrimSpace(strings.Join(kept, "\n"))
 }
 
-func listBranchesWithRoot(root string) {
+// Resuelve cualquier referencia a un snapshot: ID completo, HEAD, PREV,
+// HEAD~N, etiquetas y prefijos únicos del ID o del hash. Todos los comandos
+// que reciben un ID deben pasar por aquí.
+func resolveSpecialID(root, id string) (string, error) {
 	_, _, indexPath, _, _, _ := repoPaths(root)
 	
 	var idx Index
 	if err := readJSON(indexPath, &idx); err != nil {
-		fmt.Println("Error cargando ramas:", err)
-		return
+		return id, nil
 	}
 	
-	fmt.Println("🌿 Ramas disponibles:")
-	fmt.Printf("   🟢 %s (actual)\n", idx.Current)
+	if len(idx.Snapshots) == 0 {
+		return id, nil
+	}
 	
-	fmt.Println("\n💡 Usa 'snapgo branch <nombre>' para crear una nueva rama")
-}
-
-func createBranch(root, name string) error {
-	if name == "" {
-		return fmt.Errorf("nombre de rama no puede estar vacío")
+	for _, s := range idx.Snapshots {
+		if s.ID == id {
+			return id, nil
+		}
 	}
 	
-	_, _, indexPath, _, _, _ := repoPaths(root)
+	if id == "HEAD" {
+		return idx.Snapshots[len(idx.Snapshots)-1].ID, nil
+	} else if id == "PREV" {
+		if len(idx.Snapshots) > 1 {
+			return idx.Snapshots[len(idx.Snapshots)-2].ID, nil
+		} else {
+			logln("ℹ️  Solo hay 1 snapshot, usando HEAD para PREV")
+			return idx.Snapshots[0].ID, nil
+		}
+	}
 	
-	var idx Index
-	if err := readJSON(indexPath, &idx); err != nil {
-		return err
+	// HEAD~N (y HEAD^ como HEAD~1)
+	if strings.HasPrefix(id, "HEAD~") || id == "HEAD^" {
+		n := 1
+		if id != "HEAD^" {
+			if _, err := fmt.Sscanf(id, "HEAD~%d", &n); err != nil || n < 0 {
+				return "", fmt.Errorf("referencia inválida '%s'", id)
+			}
+		}
+		pos := len(idx.Snapshots) - 1 - n
+		if pos < 0 {
+			return "", fmt.Errorf("'%s' va más allá del primer snapshot (hay %d)", id, len(idx.Snapshots))
+		}
+		return idx.Snapshots[pos].ID, nil
+	}
+	
+	if strings.HasPrefix(id, "@{") {
+		return resolveReflogRef(root, id)
+	}
+	
+	if target, ok := idx.Tags[id]; ok {
+		return target, nil
+	}
+	
+	// Prefijo único del ID o del hash
+	matches := []string{}
+	for _, s := range idx.Snapshots {
+		if strings.HasPrefix(s.ID, id) || strings.HasPrefix(s.Hash, id) {
+			matches = append(matches, s.ID)
+		}
 	}
-	
-	idx.Current = name
-	if err := writeJSON(indexPath, idx); err != nil {
-		return err
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("referencia ambigua '%s': coincide con %s", id, strings.Join(matches, ", "))
 	}
 	
-	fmt.Printf("✅ Rama '%s' creada y seleccionada\n", name)
-	return nil
+	return "", fmt.Errorf("snapshot '%s' no encontrado", id)
 }
 
-// Nueva versión de switchCmd que acepta directorio raíz
-func switchCmdWithRoot(rootDir string) {
-	if len(os.Args) < 3 {
-		fmt.Println("Uso: switch <nombre-de-rama>")
-		return
+// Nueva versión de tagCmd que acepta directorio raíz
+func tagCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	del := fs.Bool("d", false, "eliminar la etiqueta")
+	args := parseArgs(fs, os.Args[2:])
+	
+	switch {
+	case len(args) == 0:
+		must(listTags(rootDir))
+	case *del:
+		must(deleteTag(rootDir, args[0]))
+	default:
+		ref := "HEAD"
+		if len(args) > 1 {
+			ref = args[1]
+		}
+		must(createTag(rootDir, args[0], ref))
 	}
-	
-	branchName := os.Args[2]
-	must(switchBranch(rootDir, branchName))
 }
 
-func switchBranch(root, name string) error {
+func listTags(root string) error {
 	_, _, indexPath, _, _, _ := repoPaths(root)
 	
 	var idx Index
@@ -1294,283 +10451,199 @@ func switchBranch(root, name string) error {
 		return err
 	}
 	
-	oldBranch := idx.Current
-	idx.Current = name
-	
-	if err := writeJSON(indexPath, idx); err != nil {
-		return err
+	if len(idx.Tags) == 0 {
+		fmt.Println("🏷️  No hay etiquetas")
+		fmt.Println("💡 Usa 'snapgo tag <nombre> [id]' para crear una")
+		return nil
 	}
 	
-	fmt.Printf("✅ Cambiado de '%s' a '%s'\n", oldBranch, name)
-	return nil
-}
-
-// Nueva versión de configCmd que acepta directorio raíz
-func configCmdWithRoot(root string) {
-	config, err := loadConfig(root)
-	if err != nil {
-		fmt.Println("Error cargando configuración:", err)
-		return
+	names := make([]string, 0, len(idx.Tags))
+	for name := range idx.Tags {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 	
-	fmt.Printf("⚙️  Configuración de SnapGo (en %s)\n", root)
-	fmt.Println("══════════════════════════════════════════")
-	
-	fmt.Printf("📦 Versión:          %s\n", config.Version)
-	fmt.Printf("🗜️  Compresión:       nivel %d\n", config.Compression)
-	fmt.Printf("🎯 Límite snapshots: %d\n", config.MaxSnapshots)
-	fmt.Printf("📏 Tamaño chunk:     %d MB\n", config.ChunkSizeMB)
-	fmt.Printf("🌀 Delta storage:    %v\n", config.UseDelta)
-	fmt.Printf("🔤 Alias habilitados: %v\n", config.Aliases)
-	fmt.Printf("🗑️  Papelera habilitada: %v\n", config.EnableTrash)
-	fmt.Printf("🐱 Modo Git habilitado: %v\n", config.GitMode)
-	
-	fmt.Println("\n🚫 Auto-ignore:")
-	for _, pattern := range config.AutoIgnore {
-		fmt.Printf("   • %s\n", pattern)
+	fmt.Println("🏷️  Etiquetas:")
+	for _, name := range names {
+		fmt.Printf("   %s → %s\n", name, idx.Tags[name])
 	}
-	
-	fmt.Println("\n💡 Edita .snapgo/config.json para cambiar la configuración")
+	return nil
 }
 
-// Nueva versión de trashCmd que acepta directorio raíz
-func trashCmdWithRoot(rootDir string) {
-	if len(os.Args) < 3 {
-		listTrashWithRoot(rootDir)
+func annotateCmdWithRoot(rootDir string) {
+	fs := flag.NewFlagSet("annotate", flag.ExitOnError)
+	msg := fs.String("m", "", "texto de la nota")
+	file := fs.String("F", "", "leer la nota de un archivo (- = stdin)")
+	args := parseArgs(fs, os.Args[2:])
+	
+	if len(args) != 1 || (*msg != "" && *file != "") {
+		fmt.Println("Uso: annotate <id> [-m <texto> | -F <archivo>]")
 		return
 	}
 	
-	subcmd := os.Args[2]
-	switch subcmd {
-	case "list":
-		must(listTrashWithRoot(rootDir))
-	case "empty":
-		must(emptyTrash(rootDir))
-	case "restore":
-		if len(os.Args) < 4 {
-			fmt.Println("Uso: trash restore <timestamp>")
+	note := *msg
+	switch {
+	case *file == "-":
+		data, err := io.ReadAll(os.Stdin)
+		must(err)
+		note = string(data)
+	case *file != "":
+		data, err := os.ReadFile(*file)
+		must(err)
+		note = string(data)
+	case note == "":
+		template := fmt.Sprintf("\n# Escribe una nota para el snapshot %s.\n# Las líneas que empiezan por '#' se ignoran.\n", args[0])
+		m, err := messageFromEditor(template)
+		if err != nil {
+			fmt.Printf("⚠️  %v\n", err)
+			fmt.Println("Uso: annotate <id> -m \"nota\"")
 			return
 		}
-		timestamp := os.Args[3]
-		must(restoreFromTrash(rootDir, timestamp))
-	default:
-		fmt.Println("🗑️  Comandos de papelera:")
-		fmt.Println("  trash list         Listar contenido de la papelera")
-		fmt.Println("  trash empty        Vaciar la papelera")
-		fmt.Println("  trash restore <ts> Restaurar archivos de un timestamp")
+		note = m
 	}
+	
+	must(annotateSnapshot(rootDir, args[0], note))
 }
 
-func listTrashWithRoot(root string) error {
-	_, _, _, _, _, trashDir := repoPaths(root)
-	
-	if _, err := os.Stat(trashDir); os.IsNotExist(err) {
-		fmt.Println("🗑️  La papelera está vacía")
-		return nil
+// Añade una nota a los metadatos del snapshot. El contenido y el hash no
+// cambian: las notas solo viven en el índice.
+func annotateSnapshot(root, ref, note string) error {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return fmt.Errorf("nota vacía")
 	}
 	
-	entries, err := os.ReadDir(trashDir)
+	id, err := resolveSpecialID(root, ref)
 	if err != nil {
 		return err
 	}
 	
-	if len(entries) == 0 {
-		fmt.Println("🗑️  La papelera está vacía")
-		return nil
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
 	}
 	
-	fmt.Println("🗑️  Contenido de la Papelera")
-	fmt.Println("══════════════════════════════════════════")
-	for _, entry := range entries {
-		if entry.IsDir() {
-			info, err := entry.Info()
-			if err != nil {
-				continue
+	for i := range idx.Snapshots {
+		if idx.Snapshots[i].ID == id {
+			idx.Snapshots[i].Annotations = append(idx.Snapshots[i].Annotations, note)
+			if err := writeJSON(indexPath, idx); err != nil {
+				return err
 			}
-			
-			trashPath := filepath.Join(trashDir, entry.Name())
-			files, _ := countFilesInDir(trashPath)
-			
-			fmt.Printf("📦 [%s]\n", entry.Name())
-			fmt.Printf("   📁 Archivos: %d\n", files)
-			fmt.Printf("   📅 Fecha: %s\n", info.ModTime().Format("02/01/2006 15:04:05"))
-			fmt.Println()
+			logf("🗒️  Nota añadida a %s (%d en total)\n", id, len(idx.Snapshots[i].Annotations))
+			return nil
 		}
 	}
-	
-	fmt.Println("💡 Usa 'snapgo trash restore <timestamp>' para restaurar archivos")
-	return nil
-}
-
-func countFilesInDir(dir string) (int, error) {
-	count := 0
-	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			count++
-		}
-		return nil
-	})
-	return count, err
+	return fmt.Errorf("snapshot no encontrado: %s", ref)
 }
 
-func emptyTrash(root string) error {
-	_, _, _, _, _, trashDir := repoPaths(root)
-	
-	if _, err := os.Stat(trashDir); os.IsNotExist(err) {
-		fmt.Println("🗑️  La papelera ya está vacía")
-		return nil
-	}
-	
-	fmt.Print("¿Estás seguro de vaciar la papelera? (s/n): ")
-	var response string
-	fmt.Scanln(&response)
-	
-	if strings.ToLower(response) != "s" {
-		fmt.Println("❌ Operación cancelada")
-		return nil
-	}
-	
-	err := os.RemoveAll(trashDir)
+// Marca o desmarca un snapshot como protegido frente a 'clean', la poda
+// automática de max_snapshots y 'prune --unreachable'
+func setProtected(root, ref string, protected bool) error {
+	id, err := resolveSpecialID(root, ref)
 	if err != nil {
 		return err
 	}
 	
-	os.MkdirAll(trashDir, 0o755)
-	
-	fmt.Println("✅ Papelera vaciada correctamente")
-	return nil
-}
-
-func restoreFromTrash(root, timestamp string) error {
-	_, _, _, _, _, trashDir := repoPaths(root)
-	
-	trashPath := filepath.Join(trashDir, timestamp)
-	if _, err := os.Stat(trashPath); os.IsNotExist(err) {
-		return fmt.Errorf("no se encontró el timestamp '%s' en la papelera", timestamp)
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
 	}
 	
-	fmt.Printf("🔄 Restaurando archivos desde: %s\n", timestamp)
-	
-	restored := 0
-	err := filepath.WalkDir(trashPath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
+	for i := range idx.Snapshots {
+		if idx.Snapshots[i].ID != id {
+			continue
 		}
-		
-		if d.IsDir() {
+		if idx.Snapshots[i].Protected == protected {
+			if protected {
+				logf("ℹ️  %s ya estaba protegido\n", id)
+			} else {
+				logf("ℹ️  %s no estaba protegido\n", id)
+			}
 			return nil
 		}
-		
-		rel, _ := filepath.Rel(trashPath, path)
-		dst := filepath.Join(root, rel)
-		
-		dstDir := filepath.Dir(dst)
-		if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		idx.Snapshots[i].Protected = protected
+		if err := writeJSON(indexPath, idx); err != nil {
 			return err
 		}
-		
-		if err := os.Rename(path, dst); err == nil {
-			restored++
-			fmt.Printf("   ✅ Restaurado: %s\n", rel)
+		if protected {
+			logf("🔒 Snapshot %s protegido: la limpieza no lo borrará\n", id)
+		} else {
+			logf("🔓 Snapshot %s ya no está protegido\n", id)
 		}
-		
 		return nil
-	})
-	
-	if err != nil {
-		return err
 	}
-	
-	fmt.Printf("✅ %d archivos restaurados desde la papelera\n", restored)
-	
-	os.RemoveAll(trashPath)
-	
-	return nil
+	return fmt.Errorf("snapshot no encontrado: %s", ref)
 }
 
-// Nueva versión de gitModeCmd que acepta directorio raíz
-func gitModeCmdWithRoot(cmd, root string) {
-	if _, err := exec.LookPath("git"); err != nil {
-		fmt.Println("❌ Git no está instalado o no está en el PATH")
-		fmt.Println("   Instala Git o desactiva el modo Git en la configuración")
-		return
+func createTag(root, name, ref string) error {
+	if name == "" || name == "HEAD" || name == "PREV" || strings.ContainsAny(name, "~^ /") {
+		return fmt.Errorf("nombre de etiqueta inválido '%s'", name)
 	}
 	
-	config, err := loadConfig(root)
+	id, err := resolveSpecialID(root, ref)
 	if err != nil {
-		fmt.Println("❌ No se pudo cargar la configuración")
-		return
+		return err
 	}
-	
-	if !config.GitMode {
-		fmt.Println("❌ Modo Git no está activado")
-		fmt.Println("   Actívalo en .snapgo/config.json con \"git_mode\": true")
-		return
+	if _, err := findSnapshot(root, id); err != nil {
+		return err
 	}
 	
-	switch cmd {
-	case "git-sync":
-		runGitCommand("pull origin main")
-	case "git-save":
-		if len(os.Args) < 3 {
-			fmt.Println("Uso: save \"mensaje\"")
-			return
-		}
-		message := os.Args[2]
-		runGitCommand(fmt.Sprintf("commit -am \"%s\"", message))
-	case "git-back":
-		if len(os.Args) < 3 {
-			fmt.Println("Uso: back <id>")
-			return
-		}
-		id := os.Args[2]
-		runGitCommand(fmt.Sprintf("checkout %s", id))
-	case "git-share":
-		runGitCommand("push origin main")
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		return err
 	}
-}
-
-func runGitCommand(args string) {
-	fmt.Printf("🐱 [GIT] Ejecutando: git %s\n", args)
 	
-	cmdArgs := strings.Split(args, " ")
-	cmd := exec.Command("git", cmdArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+	if existing, ok := idx.Tags[name]; ok {
+		return fmt.Errorf("la etiqueta '%s' ya existe (apunta a %s)", name, existing)
+	}
+	if idx.Tags == nil {
+		idx.Tags = make(map[string]string)
+	}
+	idx.Tags[name] = id
 	
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("❌ Comando Git falló: %v\n", err)
+	if err := writeJSON(indexPath, idx); err != nil {
+		return err
 	}
+	
+	logf("🏷️  Etiqueta '%s' creada → %s\n", name, id)
+	return nil
 }
 
-func resolveSpecialID(root, id string) string {
+func deleteTag(root, name string) error {
 	_, _, indexPath, _, _, _ := repoPaths(root)
 	
 	var idx Index
 	if err := readJSON(indexPath, &idx); err != nil {
-		return id
+		return err
 	}
 	
-	if len(idx.Snapshots) == 0 {
-		return id
+	if _, ok := idx.Tags[name]; !ok {
+		return fmt.Errorf("la etiqueta '%s' no existe", name)
 	}
+	delete(idx.Tags, name)
 	
-	if id == "HEAD" {
-		return idx.Snapshots[len(idx.Snapshots)-1].ID
-	} else if id == "PREV" {
-		if len(idx.Snapshots) > 1 {
-			return idx.Snapshots[len(idx.Snapshots)-2].ID
-		} else {
-			fmt.Println("ℹ️  Solo hay 1 snapshot, usando HEAD para PREV")
-			return idx.Snapshots[0].ID
-		}
+	if err := writeJSON(indexPath, idx); err != nil {
+		return err
 	}
 	
-	return id
+	logf("🗑️  Etiqueta '%s' eliminada\n", name)
+	return nil
+}
+
+// Etiquetas que apuntan a un snapshot, ordenadas
+func tagsFor(idx Index, id string) []string {
+	tags := []string{}
+	for name, target := range idx.Tags {
+		if target == id {
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+	return tags
 }
 
 // Función de diagnóstico para debug
@@ -1600,14 +10673,7 @@ func debugRepo(root string) error {
 					fmt.Printf(" (directorio)")
 					
 					if entry.Name() == "snapshots" {
-						snapPath := filepath.Join(snapgoDir, "snapshots")
-						snapEntries, _ := os.ReadDir(snapPath)
-						tarCount := 0
-						for _, snap := range snapEntries {
-							if strings.HasSuffix(snap.Name(), ".tar.gz") {
-								tarCount++
-							}
-						}
+						tarCount := len(listArchives(filepath.Join(snapgoDir, "snapshots")))
 						fmt.Printf(" - %d archivos .tar.gz", tarCount)
 					}
 				}
@@ -1629,7 +10695,7 @@ func debugRepo(root string) error {
 				fmt.Println("\n   📋 Snapshots registrados:")
 				for i, s := range idx.Snapshots {
 					// Verificar si el archivo .tar.gz existe
-					archivePath := filepath.Join(snapsDir, s.ID+".tar.gz")
+					archivePath := snapshotArchive(root, s.ID)
 					exists := fileExists(archivePath)
 					status := "✅"
 					if !exists {
@@ -1645,12 +10711,10 @@ func debugRepo(root string) error {
 	if fileExists(snapsDir) {
 		fmt.Println("\n🗂️  Archivos en snapshots/:")
 		entries, _ := os.ReadDir(snapsDir)
-		tarFiles := []string{}
+		tarFiles := listArchives(snapsDir)
 		otherFiles := []string{}
 		for _, entry := range entries {
-			if strings.HasSuffix(entry.Name(), ".tar.gz") {
-				tarFiles = append(tarFiles, entry.Name())
-			} else {
+			if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".tar.gz") {
 				otherFiles = append(otherFiles, entry.Name())
 			}
 		}
@@ -1711,6 +10775,27 @@ func formatTime(timestamp string) string {
 	return t.Format("02/01 15:04")
 }
 
+func formatTimeLong(timestamp string) string {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.Format("02/01/2006 15:04:05")
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
 func plural(n int) string {
 	if n == 1 {
 		return ""
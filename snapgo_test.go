@@ -0,0 +1,2645 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Con SNAPGO_TEST_MAIN=1 el binario de los tests hace de snapgo: así se
+// prueban los caminos que terminan con os.Exit (plugins, must)
+func TestMain(m *testing.M) {
+	if os.Getenv("SNAPGO_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Ejecuta snapgo con args en dir y devuelve su salida y código de salida
+func runSnapgo(t *testing.T, dir string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	return runSnapgoInput(t, dir, "", args...)
+}
+
+// Como runSnapgo, con input como entrada estándar
+func runSnapgoInput(t *testing.T, dir, input string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Env = append(os.Environ(), "SNAPGO_TEST_MAIN=1")
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+// Repositorio vacío en un directorio temporal, aislado de la configuración
+// global y del autor del usuario que ejecuta los tests
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	t.Setenv("SNAPGO_GLOBAL_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv("SNAPGO_AUTHOR", "")
+	
+	root := t.TempDir()
+	quiet = true
+	t.Cleanup(func() { quiet = false })
+	if _, err := createRepo(root, false); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func writeTestFile(t *testing.T, root, name, content string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, root, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func mustSnapshot(t *testing.T, root, message string, opts SnapshotOptions) SnapshotMeta {
+	t.Helper()
+	meta, err := createSnapshot(root, message, opts)
+	if err != nil {
+		t.Fatalf("snapshot %q: %v", message, err)
+	}
+	return meta
+}
+
+// Entradas de la papelera con sus metadatos
+func trashEntries(t *testing.T, root string) map[string]TrashMeta {
+	t.Helper()
+	_, _, _, _, _, trashDir := repoPaths(root)
+	dirs, err := os.ReadDir(trashDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]TrashMeta{}
+	for _, d := range dirs {
+		var meta TrashMeta
+		if err := readJSON(filepath.Join(trashDir, d.Name(), trashMetaFile), &meta); err != nil {
+			t.Fatalf("%s: %v", d.Name(), err)
+		}
+		entries[d.Name()] = meta
+	}
+	return entries
+}
+
+// Sustituye os.Args mientras dure el test, para los comandos que leen sus flags
+func setArgs(t *testing.T, args ...string) {
+	t.Helper()
+	old := os.Args
+	os.Args = append([]string{"snapgo"}, args...)
+	t.Cleanup(func() { os.Args = old })
+}
+
+// Sustituye os.Stdin por input mientras dure el test (respuestas a confirm)
+func setStdin(t *testing.T, input string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(input)
+	w.Close()
+	old := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = old
+		r.Close()
+	})
+}
+
+// Lector que entrega data y después falla, como una tubería que se corta
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("lectura interrumpida")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Lo que fn escribe en *stream (os.Stdout u os.Stderr)
+func captureOutput(t *testing.T, stream **os.File, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := *stream
+	*stream = w
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	defer func() { *stream = old }()
+	
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestPreRestoreTrashRecordsSnapshot(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "uno")
+	snap := mustSnapshot(t, root, "uno", SnapshotOptions{})
+	writeTestFile(t, root, "a.txt", "dos")
+	
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Force: true, NoBackup: true}); err != nil {
+		t.Fatal(err)
+	}
+	
+	entries := trashEntries(t, root)
+	if len(entries) != 1 {
+		t.Fatalf("entradas en la papelera = %d, se esperaba 1", len(entries))
+	}
+	for name, meta := range entries {
+		if meta.Reason != "pre_restore" || meta.SnapshotID != snap.ID || meta.FileCount != 1 {
+			t.Errorf("%s: %+v", name, meta)
+		}
+	}
+	if got := readTestFile(t, root, "a.txt"); got != "uno" {
+		t.Errorf("a.txt = %q", got)
+	}
+}
+
+func TestMessageFromEditorMissing(t *testing.T) {
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "")
+	if _, err := messageFromEditor(""); err == nil || !strings.Contains(err.Error(), "no hay editor") {
+		t.Errorf("sin $EDITOR: %v", err)
+	}
+	
+	t.Setenv("EDITOR", "snapgo-editor-inexistente --wait")
+	_, err := messageFromEditor("")
+	if err == nil || err.Error() != "herramienta 'snapgo-editor-inexistente' no encontrada en PATH" {
+		t.Errorf("$EDITOR inválido: %v", err)
+	}
+}
+
+func TestRestoreResolvesTag(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "v1")
+	snap := mustSnapshot(t, root, "v1", SnapshotOptions{})
+	writeTestFile(t, root, "a.txt", "v2")
+	mustSnapshot(t, root, "v2", SnapshotOptions{})
+	if err := createTag(root, "release", snap.ID); err != nil {
+		t.Fatal(err)
+	}
+	
+	out := t.TempDir()
+	if err := restoreWithOptions(root, "release", RestoreOptions{OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, out, "a.txt"); got != "v1" {
+		t.Errorf("a.txt = %q, se esperaba el contenido etiquetado", got)
+	}
+}
+
+func TestMaxFileCountLimits(t *testing.T) {
+	root := newTestRepo(t)
+	if err := configSet(root, "max_file_count", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configSet(root, "warn_file_count", "2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		writeTestFile(t, root, name+".txt", name)
+	}
+	
+	_, err := createSnapshot(root, "demasiados", SnapshotOptions{})
+	if err == nil || !strings.Contains(err.Error(), "demasiados archivos (más de 3)") {
+		t.Fatalf("sin --force: %v", err)
+	}
+	
+	stderr := captureOutput(t, &os.Stderr, func() {
+		_, err = createSnapshot(root, "forzado", SnapshotOptions{Force: true})
+	})
+	if err != nil {
+		t.Fatalf("con --force: %v", err)
+	}
+	if !strings.Contains(stderr, "aviso a partir de 2") {
+		t.Errorf("falta el aviso del umbral blando: %q", stderr)
+	}
+}
+
+func TestRestoreMerge(t *testing.T) {
+	root := newTestRepo(t)
+	for _, name := range []string{"borrado", "viejo", "nuevo"} {
+		writeTestFile(t, root, name+".txt", "snapshot")
+	}
+	snap := mustSnapshot(t, root, "base", SnapshotOptions{})
+	
+	os.Remove(filepath.Join(root, "borrado.txt"))
+	writeTestFile(t, root, "viejo.txt", "editado antes")
+	writeTestFile(t, root, "nuevo.txt", "editado después")
+	past := time.Now().Add(-24 * time.Hour)
+	future := time.Now().Add(24 * time.Hour)
+	os.Chtimes(filepath.Join(root, "viejo.txt"), past, past)
+	os.Chtimes(filepath.Join(root, "nuevo.txt"), future, future)
+	
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Merge: "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"borrado.txt": "snapshot", "viejo.txt": "editado antes", "nuevo.txt": "editado después"}
+	for name, content := range want {
+		if got := readTestFile(t, root, name); got != content {
+			t.Errorf("merge missing: %s = %q, se esperaba %q", name, got, content)
+		}
+	}
+	
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Merge: "newer"}); err != nil {
+		t.Fatal(err)
+	}
+	want["viejo.txt"] = "snapshot"
+	for name, content := range want {
+		if got := readTestFile(t, root, name); got != content {
+			t.Errorf("merge newer: %s = %q, se esperaba %q", name, got, content)
+		}
+	}
+}
+
+// list con las listas de archivos dentro de index.json (formato antiguo)
+// frente al índice ligero con sidecars <id>.meta.json
+func BenchmarkListIndex(b *testing.B) {
+	b.Setenv("SNAPGO_GLOBAL_CONFIG", filepath.Join(b.TempDir(), "config.json"))
+	root := b.TempDir()
+	quiet = true
+	defer func() { quiet = false }()
+	if _, err := createRepo(root, false); err != nil {
+		b.Fatal(err)
+	}
+	
+	files := make([]string, 2000)
+	for i := range files {
+		files[i] = fmt.Sprintf("src/paquete%03d/archivo%04d.go", i%100, i)
+	}
+	idx := Index{Current: "main"}
+	for i := 0; i < 200; i++ {
+		idx.Snapshots = append(idx.Snapshots, SnapshotMeta{
+			ID:        fmt.Sprintf("20240101-%06d-000000000000", i),
+			Timestamp: time.Now().Format(time.RFC3339),
+			Message:   fmt.Sprintf("snapshot %d", i),
+			FileCount: len(files),
+			Branch:    "main",
+			Files:     files,
+		})
+	}
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	if err := writeJSON(indexPath, idx); err != nil {
+		b.Fatal(err)
+	}
+	
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	
+	list := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := listSnapshots(root, ListOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("inline", list)
+	if err := migrateIndex(root); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("lean", list)
+}
+
+// Muchos archivos pequeños en src/, para medir la lectura en paralelo
+func writeSmallFiles(tb testing.TB, root string, n int) []string {
+	tb.Helper()
+	files := make([]string, n)
+	for i := range files {
+		files[i] = fmt.Sprintf("src/d%02d/f%04d.txt", i%20, i)
+		full := filepath.Join(root, filepath.FromSlash(files[i]))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(strings.Repeat(files[i]+"\n", 1+i%50)), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return files
+}
+
+func TestParallelReadKeepsEntryOrder(t *testing.T) {
+	root := t.TempDir()
+	files := writeSmallFiles(t, root, 300)
+	dir := t.TempDir()
+	
+	archives := map[int]string{}
+	for _, n := range []int{1, 8} {
+		archives[n] = filepath.Join(dir, fmt.Sprintf("n%d.tar.gz", n))
+		if _, err := writeTarGz(context.Background(), root, archives[n], files, writeOptions{Compression: 6, ReadConcurrency: n}); err != nil {
+			t.Fatalf("N=%d: %v", n, err)
+		}
+	}
+	
+	if names := archiveEntryNames(t, archives[8]); !slices.Equal(names, files) {
+		t.Errorf("las entradas no siguen el orden de la lista (%d entradas)", len(names))
+	}
+	
+	seq, err := os.ReadFile(archives[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	par, err := os.ReadFile(archives[8])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(seq, par) {
+		t.Error("el archivo con 8 lectores difiere del secuencial")
+	}
+}
+
+// Escritura de un snapshot de muchos archivos pequeños con un lector
+// frente a ocho
+func BenchmarkSnapshotParallelRead(b *testing.B) {
+	root := b.TempDir()
+	files := writeSmallFiles(b, root, 2000)
+	out := filepath.Join(b.TempDir(), "bench.tar.gz")
+	
+	for _, n := range []int{1, 8} {
+		b.Run(fmt.Sprintf("N=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := writeTarGz(context.Background(), root, out, files, writeOptions{Compression: 1, ReadConcurrency: n}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBranchMessagePrefix(t *testing.T) {
+	root := newTestRepo(t)
+	if err := configSet(root, "branch_message_prefix", "true"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "main")
+	if s := mustSnapshot(t, root, "en main", SnapshotOptions{}); s.Message != "en main" {
+		t.Errorf("main lleva prefijo: %q", s.Message)
+	}
+	
+	if err := createBranch(root, "feature-x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := switchBranch(root, "feature-x"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "uno")
+	if s := mustSnapshot(t, root, "cambio", SnapshotOptions{}); s.Message != "[feature-x] cambio" {
+		t.Errorf("mensaje = %q", s.Message)
+	}
+	writeTestFile(t, root, "a.txt", "dos")
+	if s := mustSnapshot(t, root, "[feature-x] ya prefijado", SnapshotOptions{}); s.Message != "[feature-x] ya prefijado" {
+		t.Errorf("prefijo duplicado: %q", s.Message)
+	}
+}
+
+// Reescribe un archivo de snapshot cambiando el contenido de name, con un
+// gzip válido: solo una verificación del contenido puede notarlo
+func tamperArchive(t *testing.T, archive, name, content string) {
+	t.Helper()
+	entries := map[string][]byte{}
+	var headers []*tar.Header
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		headers = append(headers, hdr)
+		entries[hdr.Name] = data
+	}
+	f.Close()
+	
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, hdr := range headers {
+		data := entries[hdr.Name]
+		if hdr.Name == name {
+			data = []byte(content)
+		}
+		hdr.Size = int64(len(data))
+		tw.WriteHeader(hdr)
+		tw.Write(data)
+	}
+	tw.Close()
+	gw.Close()
+	if err := os.WriteFile(archive, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyDeepDetectsTampering(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "original")
+	snap := mustSnapshot(t, root, "base", SnapshotOptions{})
+	tamperArchive(t, snapshotArchive(root, snap.ID), "a.txt", "manipulado")
+	
+	var shallow, deep error
+	captureOutput(t, &os.Stdout, func() {
+		shallow = verifySnapshots(root, false, false)
+		deep = verifySnapshots(root, false, true)
+	})
+	if shallow != nil {
+		t.Errorf("verify sin --deep: %v", shallow)
+	}
+	if deep == nil {
+		t.Error("verify --deep no detectó el contenido manipulado")
+	}
+}
+
+func TestSizeIgnoreRuleWithScope(t *testing.T) {
+	root := newTestRepo(t)
+	_, _, _, _, ignorePath, _ := repoPaths(root)
+	f, err := os.OpenFile(ignorePath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "size:>1KB assets/")
+	f.Close()
+	
+	big := strings.Repeat("x", 2048)
+	writeTestFile(t, root, "assets/grande.dat", big)
+	writeTestFile(t, root, "assets/pequeño.dat", "x")
+	writeTestFile(t, root, "docs/grande.dat", big)
+	
+	ignores, err := loadIgnore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := collectFiles(root, ignores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(files, " ")
+	if strings.Contains(got, "assets/grande.dat") {
+		t.Errorf("assets/grande.dat no se ignoró: %s", got)
+	}
+	for _, want := range []string{"assets/pequeño.dat", "docs/grande.dat"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("falta %s: %s", want, got)
+		}
+	}
+}
+
+func TestQuietFlag(t *testing.T) {
+	root := newTestRepo(t)
+	quiet = false
+	args := stripGlobalFlags([]string{"snapgo", "-q", "snapshot", "-m", "-q"})
+	if !quiet || strings.Join(args, " ") != "snapgo snapshot -m -q" {
+		t.Fatalf("quiet = %v, args = %v", quiet, args)
+	}
+	
+	writeTestFile(t, root, "a.txt", "uno")
+	stdout := captureOutput(t, &os.Stdout, func() {
+		if err := snapshotWithOptions(root, "silencioso", SnapshotOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if stdout != "" {
+		t.Errorf("salida en modo silencioso: %q", stdout)
+	}
+	
+	writeTestFile(t, root, "a.txt", "dos")
+	stdout = captureOutput(t, &os.Stdout, func() {
+		if err := snapshotJSONOutput(root, "json", SnapshotOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var meta SnapshotMeta
+	if err := json.Unmarshal([]byte(stdout), &meta); err != nil || meta.Message != "json" {
+		t.Errorf("--quiet --json: %v, %q", err, stdout)
+	}
+}
+
+func TestRestoreOnlyGlob(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "src/main.go", "package main")
+	writeTestFile(t, root, "src/util/util.go", "package util")
+	writeTestFile(t, root, "src/util/README.md", "docs")
+	writeTestFile(t, root, "notas.txt", "notas")
+	snap := mustSnapshot(t, root, "base", SnapshotOptions{})
+	
+	out := t.TempDir()
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Only: "src/**/*.go", OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"src/main.go", "src/util/util.go"} {
+		if !fileExists(filepath.Join(out, name)) {
+			t.Errorf("falta %s", name)
+		}
+	}
+	for _, name := range []string{"src/util/README.md", "notas.txt"} {
+		if fileExists(filepath.Join(out, name)) {
+			t.Errorf("%s no casa con el patrón y se restauró", name)
+		}
+	}
+	
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Only: "*.rs", OutDir: t.TempDir()}); err == nil {
+		t.Error("un patrón sin coincidencias debería dar error")
+	}
+}
+
+func TestNestedSnapgoDirExcluded(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	sub := filepath.Join(root, "sub")
+	if _, err := createRepo(sub, false); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "sub/b.txt", "b")
+	
+	// Sin patrones: la exclusión no depende del ".snapgo/" del ignore
+	files, err := collectFiles(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f, ".snapgo/") || strings.HasPrefix(f, "sub/.snapgo/") {
+			t.Errorf("se incluyó el repositorio anidado: %s", f)
+		}
+	}
+	if !slices.Contains(files, "sub/b.txt") {
+		t.Errorf("falta sub/b.txt: %v", files)
+	}
+}
+
+func TestSnapshotLargeSparseFileStreams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("comprime 64 MB")
+	}
+	root := newTestRepo(t)
+	const size = 64 << 20
+	f, err := os.Create(filepath.Join(root, "disco.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	snap := mustSnapshot(t, root, "grande", SnapshotOptions{})
+	runtime.ReadMemStats(&after)
+	
+	// Leer el archivo entero en memoria asignaría al menos su tamaño
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > size/4 {
+		t.Errorf("el snapshot asignó %s para un archivo de %s", formatSize(int64(alloc)), formatSize(size))
+	}
+	sizes, err := snapshotEntrySizes(root, snap.ID)
+	if err != nil || sizes["disco.img"] != size {
+		t.Errorf("tamaño guardado = %d (%v)", sizes["disco.img"], err)
+	}
+}
+
+func TestPlanImportConflicts(t *testing.T) {
+	local := Index{Snapshots: []SnapshotMeta{{ID: "a", Hash: "h1"}, {ID: "b", Hash: "h2"}}}
+	same := Index{Snapshots: []SnapshotMeta{{ID: "a", Hash: "h1"}}}
+	clash := Index{Snapshots: []SnapshotMeta{{ID: "b", Hash: "otro"}, {ID: "c", Hash: "h3", Parent: "b"}}}
+	
+	items, _, conflicts := planImport(local, same, "")
+	if len(items) != 0 || len(conflicts) != 0 {
+		t.Errorf("mismo ID y hash: items %v, conflictos %v", items, conflicts)
+	}
+	
+	_, _, conflicts = planImport(local, clash, "")
+	if len(conflicts) != 1 || !strings.HasPrefix(conflicts[0], "b:") {
+		t.Errorf("mismo ID y distinto hash: conflictos %v", conflicts)
+	}
+	
+	items, _, conflicts = planImport(local, clash, "ours")
+	if len(conflicts) != 0 || len(items) != 1 || items[0].meta.ID != "c" {
+		t.Errorf("--ours: items %v, conflictos %v", items, conflicts)
+	}
+	items, _, _ = planImport(local, clash, "theirs")
+	if len(items) != 2 || !items[0].replace {
+		t.Errorf("--theirs: %v", items)
+	}
+	items, renamed, _ := planImport(local, clash, "rename")
+	if renamed["b"] != "b-2" || len(items) != 2 || items[1].meta.Parent != "b-2" {
+		t.Errorf("--rename: items %v, renombrados %v", items, renamed)
+	}
+	
+	// Repetir el --rename tras importar no duplica nada
+	local.Snapshots = append(local.Snapshots, SnapshotMeta{ID: "b-2", Hash: "otro"}, SnapshotMeta{ID: "c", Hash: "h3"})
+	if items, _, _ := planImport(local, clash, "rename"); len(items) != 0 {
+		t.Errorf("segundo --rename: %v", items)
+	}
+}
+
+func TestStripCommentLines(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Arreglo\n# comentario\n", "Arreglo"},
+		{"\n# solo comentarios\n#\n", ""},
+		{"Título  \n\nCuerpo # no es comentario\n# fin", "Título\n\nCuerpo # no es comentario"},
+		{"  #sangrado se conserva\n", "#sangrado se conserva"},
+	}
+	for _, tt := range tests {
+		if got := stripCommentLines(tt.in); got != tt.want {
+			t.Errorf("stripCommentLines(%q) = %q, se esperaba %q", tt.in, got, tt.want)
+		}
+	}
+	
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	template := editorTemplate(root)
+	if !strings.Contains(template, "#   a.txt\n") || !strings.Contains(template, "# Rama: main\n") {
+		t.Errorf("plantilla sin archivos o rama:\n%s", template)
+	}
+	if got := stripCommentLines(template); got != "" {
+		t.Errorf("la plantilla sin editar deja el mensaje %q", got)
+	}
+}
+
+// Índice del repositorio
+func readIndex(t *testing.T, root string) Index {
+	t.Helper()
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	var idx Index
+	if err := readJSON(indexPath, &idx); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+// Retrasa la fecha de un snapshot en el índice: los creados en el mismo
+// segundo no tienen un orden definido para diff
+func backdate(t *testing.T, root, id string, d time.Duration) {
+	t.Helper()
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	idx := readIndex(t, root)
+	for i, s := range idx.Snapshots {
+		if s.ID == id {
+			when, _ := time.Parse(time.RFC3339, s.Timestamp)
+			idx.Snapshots[i].Timestamp = when.Add(-d).Format(time.RFC3339)
+		}
+	}
+	if err := writeJSON(indexPath, idx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeSnapshot(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "uno")
+	first := mustSnapshot(t, root, "uno", SnapshotOptions{})
+	writeTestFile(t, root, "secreto.txt", "contraseña")
+	bad := mustSnapshot(t, root, "con secreto", SnapshotOptions{})
+	
+	var err error
+	captureOutput(t, &os.Stdout, func() { err = purgeSnapshot(root, "HEAD", true) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(snapshotArchive(root, bad.ID)) || fileExists(snapshotFilesPath(root, bad.ID)) {
+		t.Error("quedan archivos del snapshot purgado")
+	}
+	idx := readIndex(t, root)
+	if len(idx.Snapshots) != 1 || idx.Snapshots[0].ID != first.ID {
+		t.Errorf("índice tras purge: %+v", idx.Snapshots)
+	}
+	if idx.Branches["main"] != first.ID {
+		t.Errorf("main apunta a %s, se esperaba %s", idx.Branches["main"], first.ID)
+	}
+	if len(trashEntries(t, root)) != 0 {
+		t.Error("purge no debe usar la papelera")
+	}
+}
+
+func TestEmptySnapshotRoundTrip(t *testing.T) {
+	root := newTestRepo(t)
+	empty := mustSnapshot(t, root, "vacío", SnapshotOptions{Empty: true})
+	if empty.FileCount != 0 {
+		t.Fatalf("FileCount = %d", empty.FileCount)
+	}
+	if _, err := createSnapshot(root, "sin --empty", SnapshotOptions{FromList: writeListFile(t, "")}); err != errNoFiles {
+		t.Errorf("lista vacía sin --empty: %v", err)
+	}
+	
+	writeTestFile(t, root, "a.txt", "a")
+	writeTestFile(t, root, "dir/b.txt", "b")
+	full := mustSnapshot(t, root, "con archivos", SnapshotOptions{})
+	res, hashed := snapshotDiff(root, &empty, &full)
+	if !hashed || len(res.Removed) != 0 || len(res.Modified) != 0 || !slices.Contains(res.Added, "a.txt") || !slices.Contains(res.Added, "dir/b.txt") {
+		t.Errorf("diff vacío → lleno: %+v", res)
+	}
+	
+	if err := restoreWithOptions(root, empty.ID, RestoreOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	ignores, _ := loadIgnore(root)
+	if files, _ := collectFiles(root, ignores); len(files) != 0 {
+		t.Errorf("quedan archivos tras restaurar el snapshot vacío: %v", files)
+	}
+	
+	// El backup automático conserva lo que había
+	if err := restoreWithOptions(root, "PREV", RestoreOptions{Force: true, NoBackup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, root, "dir/b.txt"); got != "b" {
+		t.Errorf("dir/b.txt = %q", got)
+	}
+}
+
+// Lista de archivos para --from-list en un temporal
+func writeListFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "lista.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSameContentSnapshotIDsDiffer(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "igual")
+	first := mustSnapshot(t, root, "uno", SnapshotOptions{})
+	second := mustSnapshot(t, root, "dos", SnapshotOptions{})
+	if first.ID == second.ID {
+		t.Fatalf("ID repetido: %s", first.ID)
+	}
+	if got := uniqueSnapshotID(root, first.ID); got != first.ID+"-2" && got != first.ID+"-3" {
+		t.Errorf("uniqueSnapshotID(%s) = %s", first.ID, got)
+	}
+	if got := uniqueSnapshotID(root, "20000101-000000-libre"); got != "20000101-000000-libre" {
+		t.Errorf("un ID libre no debe cambiar: %s", got)
+	}
+}
+
+func TestGlobalUserNameAppliesToNewRepos(t *testing.T) {
+	newTestRepo(t)
+	if err := configSetGlobal("user.name", "Ada"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configSetGlobal("compression_level", "12"); err == nil {
+		t.Error("la configuración global debe validarse como la del repositorio")
+	}
+	
+	root := t.TempDir()
+	if _, err := createRepo(root, false); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "a")
+	if s := mustSnapshot(t, root, "con autor", SnapshotOptions{}); s.Author != "Ada" {
+		t.Errorf("autor = %q, se esperaba el user.name global", s.Author)
+	}
+	
+	t.Setenv("SNAPGO_AUTHOR", "Grace")
+	writeTestFile(t, root, "a.txt", "b")
+	if s := mustSnapshot(t, root, "env", SnapshotOptions{}); s.Author != "Grace" {
+		t.Errorf("SNAPGO_AUTHOR no tiene prioridad: %q", s.Author)
+	}
+}
+
+func TestAnnotateKeepsHash(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	snap := mustSnapshot(t, root, "base", SnapshotOptions{})
+	
+	for _, note := range []string{"relacionado con #12", "revisado"} {
+		if err := annotateSnapshot(root, "HEAD", note); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := findSnapshot(root, snap.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash != snap.Hash {
+		t.Errorf("el hash cambió: %s → %s", snap.Hash, got.Hash)
+	}
+	if strings.Join(got.Annotations, "|") != "relacionado con #12|revisado" {
+		t.Errorf("anotaciones = %q", got.Annotations)
+	}
+	
+	var verifyErr error
+	captureOutput(t, &os.Stdout, func() { verifyErr = verifySnapshots(root, false, true) })
+	if verifyErr != nil {
+		t.Errorf("verify --deep tras anotar: %v", verifyErr)
+	}
+}
+
+func TestInitFromTemplate(t *testing.T) {
+	t.Setenv("SNAPGO_GLOBAL_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	quiet = true
+	t.Cleanup(func() { quiet = false })
+	
+	template := t.TempDir()
+	writeTestFile(t, template, ".snapgoignore", "*.secreto\n")
+	writeTestFile(t, template, "config.json", `{"compression_level": 9}`)
+	root := t.TempDir()
+	if err := initFromTemplate(root, template); err != nil {
+		t.Fatal(err)
+	}
+	
+	config, err := loadConfig(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Compression != 9 || config.MaxSnapshots != defaultConfig().MaxSnapshots {
+		t.Errorf("compression_level = %d, max_snapshots = %d", config.Compression, config.MaxSnapshots)
+	}
+	writeTestFile(t, root, "a.txt", "a")
+	writeTestFile(t, root, "clave.secreto", "no")
+	ignores, _ := loadIgnore(root)
+	files, _ := collectFiles(root, ignores)
+	if strings.Join(files, " ") != ".snapgoignore a.txt" {
+		t.Errorf("archivos con el ignore de la plantilla: %v", files)
+	}
+	
+	bad := t.TempDir()
+	writeTestFile(t, bad, "config.json", `{"compression_level": 42}`)
+	other := t.TempDir()
+	if err := initFromTemplate(other, bad); err == nil {
+		t.Error("una plantilla con una configuración inválida debería rechazarse")
+	}
+	if fileExists(filepath.Join(other, ".snapgo")) {
+		t.Error("se creó el repositorio con una plantilla inválida")
+	}
+}
+
+func TestDiffIgnoreWhitespace(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "espacios.txt", "uno dos\ntres\n")
+	writeTestFile(t, root, "texto.txt", "hola\n")
+	older := mustSnapshot(t, root, "antes", SnapshotOptions{})
+	writeTestFile(t, root, "espacios.txt", "uno   dos  \n\ttres\t\n")
+	writeTestFile(t, root, "texto.txt", "adiós\n")
+	newer := mustSnapshot(t, root, "después", SnapshotOptions{})
+	
+	for _, tt := range []struct {
+		ignoreSpace bool
+		want        string
+	}{
+		{false, "espacios.txt texto.txt"},
+		{true, "texto.txt"},
+	} {
+		res, err := diffSnapshots(root, older.ID, newer.ID, DiffOptions{IgnoreSpace: tt.ignoreSpace, Out: io.Discard})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(res.Modified, " "); got != tt.want {
+			t.Errorf("-w=%v: modificados %q, se esperaba %q", tt.ignoreSpace, got, tt.want)
+		}
+	}
+}
+
+func TestRestoreStrip(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "proyecto/src/main.go", "package main")
+	writeTestFile(t, root, "proyecto/README", "léeme")
+	writeTestFile(t, root, "suelto.txt", "sin directorio")
+	snap := mustSnapshot(t, root, "base", SnapshotOptions{})
+	
+	out := t.TempDir()
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Strip: 1, OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, out, "src/main.go"); got != "package main" {
+		t.Errorf("src/main.go = %q", got)
+	}
+	if !fileExists(filepath.Join(out, "README")) {
+		t.Error("falta README")
+	}
+	for _, name := range []string{"proyecto", "suelto.txt"} {
+		if fileExists(filepath.Join(out, name)) {
+			t.Errorf("%s no debería restaurarse con --strip 1", name)
+		}
+	}
+	
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Strip: 1, VerifyAfter: true, OutDir: t.TempDir()}); err == nil {
+		t.Error("--strip con --verify-after debería rechazarse")
+	}
+}
+
+func TestRestoreSkipsUnchangedFiles(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "igual.txt", "mismo contenido")
+	writeTestFile(t, root, "distinto.txt", "snapshot")
+	snap := mustSnapshot(t, root, "base", SnapshotOptions{})
+	
+	out := t.TempDir()
+	writeTestFile(t, out, "igual.txt", "mismo contenido")
+	writeTestFile(t, out, "distinto.txt", "editado")
+	old := time.Date(2001, 2, 3, 4, 5, 6, 0, time.Local)
+	for _, name := range []string{"igual.txt", "distinto.txt"} {
+		os.Chtimes(filepath.Join(out, name), old, old)
+	}
+	mtime := func(name string) time.Time {
+		info, err := os.Stat(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime()
+	}
+	
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	if !mtime("igual.txt").Equal(old) {
+		t.Errorf("igual.txt se reescribió: mtime %v", mtime("igual.txt"))
+	}
+	if got := readTestFile(t, out, "distinto.txt"); got != "snapshot" {
+		t.Errorf("distinto.txt = %q", got)
+	}
+	
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{OutDir: out, ForceWrite: true}); err != nil {
+		t.Fatal(err)
+	}
+	if mtime("igual.txt").Equal(old) {
+		t.Error("--force-write no reescribió igual.txt")
+	}
+}
+
+func TestDiffOnlyGlob(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "src/a.go", "a")
+	writeTestFile(t, root, "docs/a.md", "a")
+	older := mustSnapshot(t, root, "antes", SnapshotOptions{})
+	writeTestFile(t, root, "src/a.go", "b")
+	writeTestFile(t, root, "src/sub/nuevo.go", "nuevo")
+	writeTestFile(t, root, "docs/a.md", "b")
+	writeTestFile(t, root, "docs/nuevo.md", "nuevo")
+	newer := mustSnapshot(t, root, "después", SnapshotOptions{})
+	
+	res, err := diffSnapshots(root, older.ID, newer.ID, DiffOptions{Only: []string{"src/**"}, Out: io.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := res.names()
+	slices.Sort(names)
+	if got := strings.Join(names, " "); got != "src/a.go src/sub/nuevo.go" {
+		t.Errorf("cambios con --only src/**: %q", got)
+	}
+}
+
+func TestRevertHead(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "uno")
+	first := mustSnapshot(t, root, "uno", SnapshotOptions{})
+	writeTestFile(t, root, "a.txt", "dos")
+	writeTestFile(t, root, "b.txt", "nuevo")
+	head := mustSnapshot(t, root, "dos", SnapshotOptions{})
+	
+	if err := revertHead(root); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, root, "a.txt"); got != "uno" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if fileExists(filepath.Join(root, "b.txt")) {
+		t.Error("b.txt no existía en el snapshot anterior")
+	}
+	idx := readIndex(t, root)
+	if len(idx.Snapshots) != 1 || idx.Branches["main"] != first.ID {
+		t.Errorf("índice tras revert: %+v", idx)
+	}
+	if fileExists(snapshotArchive(root, head.ID)) {
+		t.Error("el archivo del snapshot retirado sigue en snapshots/")
+	}
+	reasons := map[string]bool{}
+	for _, meta := range trashEntries(t, root) {
+		reasons[meta.Reason] = true
+	}
+	if !reasons["revert"] || !reasons["revert_snapshot"] {
+		t.Errorf("entradas de la papelera: %v", reasons)
+	}
+	
+	// El primer snapshot de una rama nueva no se deshace hacia main
+	if err := createBranch(root, "feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := switchBranch(root, "feature"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "feature")
+	mustSnapshot(t, root, "en feature", SnapshotOptions{})
+	if err := revertHead(root); err == nil || !strings.Contains(err.Error(), "no cruza ramas") {
+		t.Errorf("revert entre ramas: %v", err)
+	}
+}
+
+func TestCleanToTrashIsRecoverable(t *testing.T) {
+	root := newTestRepo(t)
+	var snaps []SnapshotMeta
+	for _, content := range []string{"uno", "dos", "tres"} {
+		writeTestFile(t, root, "a.txt", content)
+		snaps = append(snaps, mustSnapshot(t, root, content, SnapshotOptions{}))
+	}
+	
+	setArgs(t, "clean", "--keep", "1")
+	if err := cleanCmdWithRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(readIndex(t, root).Snapshots); n != 1 {
+		t.Fatalf("quedan %d snapshots tras clean --keep 1", n)
+	}
+	entries := trashEntries(t, root)
+	if len(entries) != 1 {
+		t.Fatalf("entradas en la papelera: %v", entries)
+	}
+	for name, meta := range entries {
+		if !strings.HasPrefix(name, "pruned_") || len(meta.Snapshots) != 2 {
+			t.Errorf("%s: %+v", name, meta)
+		}
+		captureOutput(t, &os.Stdout, func() {
+			if err := restoreFromTrash(root, name); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	
+	idx := readIndex(t, root)
+	if len(idx.Snapshots) != 3 {
+		t.Fatalf("tras trash restore hay %d snapshots", len(idx.Snapshots))
+	}
+	for _, s := range snaps[:2] {
+		if !fileExists(snapshotArchive(root, s.ID)) {
+			t.Errorf("falta el archivo de %s", s.ID)
+		}
+	}
+}
+
+func TestAutoRotationDeletesPermanently(t *testing.T) {
+	root := newTestRepo(t)
+	if err := configSet(root, "max_snapshots", "1"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "uno")
+	first := mustSnapshot(t, root, "uno", SnapshotOptions{})
+	writeTestFile(t, root, "a.txt", "dos")
+	mustSnapshot(t, root, "dos", SnapshotOptions{})
+	
+	if fileExists(snapshotArchive(root, first.ID)) {
+		t.Error("el snapshot rotado sigue en snapshots/")
+	}
+	if entries := trashEntries(t, root); len(entries) != 0 {
+		t.Errorf("la rotación automática no debe usar la papelera: %v", entries)
+	}
+}
+
+func TestConfigSetAutoIgnore(t *testing.T) {
+	root := newTestRepo(t)
+	autoIgnore := func() []string {
+		config, err := loadConfig(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config.AutoIgnore
+	}
+	before := len(autoIgnore())
+	
+	if err := configSetAutoIgnore(root, "add", "*.bak"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configSetAutoIgnore(root, "add", " *.bak "); err != nil {
+		t.Fatal(err)
+	}
+	if got := autoIgnore(); len(got) != before+1 || got[len(got)-1] != "*.bak" {
+		t.Errorf("tras add duplicado: %v", got)
+	}
+	
+	if err := configSetAutoIgnore(root, "remove", "*.bak"); err != nil {
+		t.Fatal(err)
+	}
+	if got := autoIgnore(); len(got) != before || slices.Contains(got, "*.bak") {
+		t.Errorf("tras remove: %v", got)
+	}
+	if err := configSetAutoIgnore(root, "add", "  "); err == nil {
+		t.Error("un patrón vacío debería rechazarse")
+	}
+}
+
+func TestRestoreBranchHead(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "main")
+	mustSnapshot(t, root, "main", SnapshotOptions{})
+	if err := createBranch(root, "feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := switchBranch(root, "feature"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "feature")
+	mustSnapshot(t, root, "feature", SnapshotOptions{})
+	if err := switchBranch(root, "main"); err != nil {
+		t.Fatal(err)
+	}
+	
+	out := t.TempDir()
+	if _, stderr, code := runSnapgo(t, root, "restore", "--branch", "feature", "--out-dir", out); code != 0 {
+		t.Fatalf("restore --branch: código %d: %s", code, stderr)
+	}
+	if got := readTestFile(t, out, "a.txt"); got != "feature" {
+		t.Errorf("a.txt = %q, se esperaba la cabeza de feature", got)
+	}
+	if cur := readIndex(t, root).Current; cur != "main" {
+		t.Errorf("la rama actual cambió a %s", cur)
+	}
+	
+	if _, err := branchHeadSnapshot(root, "vacía"); err == nil {
+		t.Error("una rama sin snapshots debería dar error")
+	}
+}
+
+func TestDiffAgainstWorkingTree(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "uno")
+	mustSnapshot(t, root, "uno", SnapshotOptions{})
+	writeTestFile(t, root, "b.txt", "b")
+	mustSnapshot(t, root, "dos", SnapshotOptions{})
+	writeTestFile(t, root, "a.txt", "editado")
+	
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"diff", "--summary-only"}, "0 añadidos, 0 eliminados, 1 modificados"},
+		{[]string{"diff", "--summary-only", "PREV"}, "1 añadidos, 0 eliminados, 1 modificados"},
+	}
+	for _, tt := range tests {
+		// --summary-only sale con 2 cuando hay diferencias
+		stdout, stderr, code := runSnapgo(t, root, tt.args...)
+		if code != 2 || strings.TrimSpace(stdout) != tt.want {
+			t.Errorf("%v: código %d, salida %q %s, se esperaba %q", tt.args, code, stdout, stderr, tt.want)
+		}
+	}
+}
+
+func TestMaxSnapshotsZeroNeverPrunes(t *testing.T) {
+	root := newTestRepo(t)
+	if err := configSet(root, "max_snapshots", "0"); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"uno", "dos", "tres"} {
+		writeTestFile(t, root, "a.txt", content)
+		mustSnapshot(t, root, content, SnapshotOptions{})
+	}
+	if n := len(readIndex(t, root).Snapshots); n != 3 {
+		t.Fatalf("la rotación al crear snapshots dejó %d", n)
+	}
+	
+	setArgs(t, "clean")
+	if err := cleanCmdWithRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(readIndex(t, root).Snapshots); n != 3 {
+		t.Errorf("clean con max_snapshots 0 dejó %d snapshots", n)
+	}
+}
+
+func TestHistoryAuthorFilter(t *testing.T) {
+	root := newTestRepo(t)
+	for _, author := range []string{"Ada Lovelace", "Grace Hopper", "ada"} {
+		t.Setenv("SNAPGO_AUTHOR", author)
+		writeTestFile(t, root, "a.txt", author)
+		mustSnapshot(t, root, "de "+author, SnapshotOptions{})
+	}
+	
+	setArgs(t, "history", "--author", "ADA")
+	stdout := captureOutput(t, &os.Stdout, func() {
+		if err := historyCmdWithRoot(root); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if strings.Contains(stdout, "Grace") {
+		t.Errorf("se listó un snapshot de otro autor:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "de Ada Lovelace") || !strings.Contains(stdout, "de ada") {
+		t.Errorf("faltan snapshots de Ada:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "2 de 3 snapshot(s) de 'ADA'") {
+		t.Errorf("falta el recuento:\n%s", stdout)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "cambia.txt", "uno")
+	writeTestFile(t, root, "mover.txt", "se mueve")
+	writeTestFile(t, root, "borrar.txt", "adiós")
+	older := mustSnapshot(t, root, "antes", SnapshotOptions{})
+	writeTestFile(t, root, "cambia.txt", "dos!")
+	os.Rename(filepath.Join(root, "mover.txt"), filepath.Join(root, "movido.txt"))
+	os.Remove(filepath.Join(root, "borrar.txt"))
+	writeTestFile(t, root, "nuevo.txt", "hola")
+	newer := mustSnapshot(t, root, "después", SnapshotOptions{})
+	backdate(t, root, older.ID, time.Hour)
+	
+	var buf bytes.Buffer
+	if _, err := diffSnapshots(root, older.ID, newer.ID, DiffOptions{JSON: true, Out: &buf}); err != nil {
+		t.Fatal(err)
+	}
+	var got diffJSON
+	dec := json.NewDecoder(&buf)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	want := diffJSON{
+		From:     older.ID,
+		To:       newer.ID,
+		Added:    []diffFileJSON{{Path: "nuevo.txt", Hash: hash("hola"), Size: 4}},
+		Removed:  []diffFileJSON{{Path: "borrar.txt", Hash: hash("adiós"), Size: 6}},
+		Modified: []diffModifiedJSON{{Path: "cambia.txt", Old: diffFileJSON{Hash: hash("uno"), Size: 3}, New: diffFileJSON{Hash: hash("dos!"), Size: 4}}},
+		Renamed:  []diffRenamedJSON{{From: "mover.txt", To: "movido.txt", Hash: hash("se mueve"), Size: 8}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diff --json:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestRestorePreserveTimes(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	old := time.Date(2001, 2, 3, 4, 5, 6, 0, time.Local)
+	os.Chtimes(filepath.Join(root, "a.txt"), old, old)
+	snap := mustSnapshot(t, root, "base", SnapshotOptions{})
+	
+	for _, noPreserve := range []bool{false, true} {
+		out := t.TempDir()
+		if err := restoreWithOptions(root, snap.ID, RestoreOptions{OutDir: out, NoPreserveTimes: noPreserve}); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filepath.Join(out, "a.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if preserved := info.ModTime().Equal(old); preserved == noPreserve {
+			t.Errorf("--no-preserve-times=%v: mtime %v", noPreserve, info.ModTime())
+		}
+	}
+}
+
+func TestDedupeCheck(t *testing.T) {
+	root := newTestRepo(t)
+	big := strings.Repeat("imagen", 100)
+	writeTestFile(t, root, "assets/logo.png", big)
+	writeTestFile(t, root, "web/logo-copia.png", big)
+	writeTestFile(t, root, "otro.png", strings.Repeat("distinto", 75))
+	writeTestFile(t, root, "pequeño1.txt", "x")
+	writeTestFile(t, root, "pequeño2.txt", "x")
+	
+	ignores, _ := loadIgnore(root)
+	files, _ := collectFiles(root, ignores)
+	groups := findDuplicates(root, files, false, 100)
+	if len(groups) != 1 {
+		t.Fatalf("grupos = %+v", groups)
+	}
+	if g := groups[0]; g.Size != int64(len(big)) || strings.Join(g.Files, " ") != "assets/logo.png web/logo-copia.png" {
+		t.Errorf("grupo = %+v", g)
+	}
+	
+	stderr := captureOutput(t, &os.Stderr, func() {
+		mustSnapshot(t, root, "con duplicados", SnapshotOptions{DedupeCheck: true})
+	})
+	if strings.Contains(stderr, "logo.png") {
+		t.Errorf("aviso por debajo del umbral de %s: %q", formatSize(dedupeMinSize), stderr)
+	}
+}
+
+func TestRestoreBackupLabel(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "uno")
+	snap := mustSnapshot(t, root, "uno", SnapshotOptions{})
+	writeTestFile(t, root, "a.txt", "dos")
+	
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Force: true, BackupLabel: "antes de la auditoría"}); err != nil {
+		t.Fatal(err)
+	}
+	idx := readIndex(t, root)
+	if len(idx.Snapshots) != 2 || idx.Snapshots[1].Message != "antes de la auditoría" {
+		t.Fatalf("snapshots tras restore --force: %+v", idx.Snapshots)
+	}
+	
+	writeTestFile(t, root, "a.txt", "tres")
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Force: true, NoBackup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(readIndex(t, root).Snapshots); n != 2 {
+		t.Errorf("--no-backup creó un snapshot: hay %d", n)
+	}
+}
+
+func TestVerifyRepair(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "uno")
+	lost := mustSnapshot(t, root, "se perderá", SnapshotOptions{})
+	writeTestFile(t, root, "a.txt", "dos")
+	orphan := mustSnapshot(t, root, "huérfano", SnapshotOptions{})
+	
+	// lost pierde su archivo y orphan su entrada en el índice
+	os.Remove(snapshotArchive(root, lost.ID))
+	_, snapsDir, indexPath, _, _, _ := repoPaths(root)
+	idx := readIndex(t, root)
+	idx.Snapshots = idx.Snapshots[:1]
+	idx.Branches["main"] = lost.ID
+	if err := writeJSON(indexPath, idx); err != nil {
+		t.Fatal(err)
+	}
+	// Archivos que no debe registrar: nombre ajeno a snapgo e incremental
+	writeTestFile(t, snapsDir, "copia-manual.tar.gz", "")
+	incID := "20240101-120000-0123456789ab"
+	copyFileVerified(snapshotArchive(root, orphan.ID), snapshotArchive(root, incID))
+	writeJSON(snapshotFilesPath(root, incID), SnapshotFiles{ID: incID, Files: []string{"a.txt"}, Inherited: []string{"b.txt"}})
+	
+	setStdin(t, "s\ns\ns\ns\n")
+	captureOutput(t, &os.Stdout, func() {
+		if err := repairIndex(root); err != nil {
+			t.Fatal(err)
+		}
+	})
+	
+	idx = readIndex(t, root)
+	if len(idx.Snapshots) != 1 || idx.Snapshots[0].ID != orphan.ID {
+		t.Fatalf("índice reparado: %+v", idx.Snapshots)
+	}
+	if got := idx.Snapshots[0]; got.Hash != orphan.Hash || got.FileCount != orphan.FileCount {
+		t.Errorf("entrada recuperada %+v, original %+v", got, orphan)
+	}
+	if _, err := recoverSnapshotMeta(root, "copia-manual"); err == nil {
+		t.Error("un archivo sin ID de snapgo no debe registrarse")
+	}
+	if _, err := recoverSnapshotMeta(root, incID); err == nil {
+		t.Error("un incremental sin base conocida no debe registrarse")
+	}
+}
+
+func TestStatusIgnoredReportsPattern(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	writeTestFile(t, root, "logs/debug.log", "log")
+	writeTestFile(t, root, "build/salida.o", "obj")
+	
+	ignores, err := loadIgnore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := collectIgnored(root, ignores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, p := range paths {
+		got[p.Path] = p.Pattern
+	}
+	if got["logs/debug.log"] != "*.log" {
+		t.Errorf("logs/debug.log ← %q", got["logs/debug.log"])
+	}
+	// Un directorio ignorado se lista una vez, sin su contenido
+	if got["build/"] != "build/" {
+		t.Errorf("build/ ← %q", got["build/"])
+	}
+	if _, ok := got["build/salida.o"]; ok {
+		t.Error("se listó el contenido de un directorio ignorado")
+	}
+	if _, ok := got["a.txt"]; ok {
+		t.Error("a.txt no está ignorado")
+	}
+}
+
+func TestMatchIgnorePattern(t *testing.T) {
+	patterns := []string{"", "size:>1MB", "node_modules/", "*.log", ".env", "backup~"}
+	tests := []struct {
+		path    string
+		matched bool
+		pattern string
+	}{
+		{"node_modules/pkg/index.js", true, "node_modules/"},
+		{"web/node_modules/x.js", true, "node_modules/"},
+		{"logs/app.log", true, "*.log"},
+		{"config/.env", true, ".env"},
+		{"notas.txt.backup~", true, "backup~"},
+		{"src/main.go", false, ""},
+	}
+	for _, tt := range tests {
+		matched, pattern := matchIgnore(tt.path, patterns)
+		if matched != tt.matched || pattern != tt.pattern {
+			t.Errorf("matchIgnore(%q) = %v, %q; se esperaba %v, %q", tt.path, matched, pattern, tt.matched, tt.pattern)
+		}
+		if isIgnored(tt.path, patterns) != tt.matched {
+			t.Errorf("isIgnored(%q) no coincide con matchIgnore", tt.path)
+		}
+	}
+}
+
+func TestCheckIgnoreCommand(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "app.log", "log")
+	writeTestFile(t, root, "main.go", "package main")
+	
+	stdout, _, code := runSnapgo(t, root, "check-ignore", "app.log", "main.go")
+	if code != 0 {
+		t.Errorf("con una ruta ignorada sale con %d", code)
+	}
+	if !strings.Contains(stdout, "🚫 app.log  ← *.log") || !strings.Contains(stdout, "✅ main.go  (no ignorado)") {
+		t.Errorf("salida:\n%s", stdout)
+	}
+	
+	if _, _, code := runSnapgo(t, root, "check-ignore", "main.go"); code != 1 {
+		t.Errorf("sin rutas ignoradas sale con %d, se esperaba 1", code)
+	}
+	
+	stdout, _, code = runSnapgoInput(t, root, "main.go\nnode_modules/x.js\n", "check-ignore", "--stdin")
+	if code != 0 || !strings.Contains(stdout, "🚫 node_modules/x.js  ← node_modules/") {
+		t.Errorf("--stdin: código %d, salida:\n%s", code, stdout)
+	}
+}
+
+func TestAddContentFromStdin(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	if _, stderr, code := runSnapgoInput(t, root, "generado\n", "add-content", "gen/datos.txt"); code != 0 {
+		t.Fatalf("add-content: %s", stderr)
+	}
+	if _, stderr, code := runSnapgo(t, root, "snapshot", "-m", "con contenido preparado"); code != 0 {
+		t.Fatalf("snapshot: %s", stderr)
+	}
+	
+	idx := readIndex(t, root)
+	id := idx.Snapshots[len(idx.Snapshots)-1].ID
+	out := t.TempDir()
+	if err := restoreWithOptions(root, id, RestoreOptions{OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, out, "gen/datos.txt"); got != "generado\n" {
+		t.Errorf("gen/datos.txt = %q", got)
+	}
+	if fileExists(filepath.Join(root, "gen", "datos.txt")) {
+		t.Error("add-content no debe escribir en el directorio de trabajo")
+	}
+	staged, err := loadStaged(root)
+	if err != nil || len(staged) != 0 {
+		t.Errorf("el área de preparación no se vació: %v %v", staged, err)
+	}
+	
+	// Una escritura interrumpida no deja nada preparado
+	if err := addContent(root, "gen/roto.txt", &failingReader{data: []byte("a medias")}); err == nil {
+		t.Fatal("add-content con la entrada cortada debería fallar")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(stagingDir(root), "gen")); len(entries) != 0 {
+		t.Errorf("quedan archivos en staging: %v", entries)
+	}
+	
+	for _, bad := range []string{"../fuera.txt", ".snapgo/index.json", "dir/" + stagingPartialPrefix + "x"} {
+		if err := addContent(root, bad, strings.NewReader("x")); err == nil {
+			t.Errorf("add-content aceptó %s", bad)
+		}
+	}
+}
+
+func TestGCCompactRecompresses(t *testing.T) {
+	root := newTestRepo(t)
+	if err := configSet(root, "compression_level", "0"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "texto.txt", strings.Repeat("snapgo comprime bien el texto repetido\n", 2000))
+	writeTestFile(t, root, "sub/b.txt", "b")
+	meta := mustSnapshot(t, root, "sin comprimir", SnapshotOptions{})
+	
+	archive := snapshotArchive(root, meta.ID)
+	before, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashesBefore, err := snapshotContentHashes(root, meta.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	
+	if err := compactArchives(root, 9); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Errorf("el archivo no se redujo: %d → %d bytes", before.Size(), after.Size())
+	}
+	if fileExists(archive + ".compact") {
+		t.Error("quedó el temporal .compact")
+	}
+	
+	hashesAfter, err := snapshotContentHashes(root, meta.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hashesBefore, hashesAfter) {
+		t.Errorf("el contenido cambió:\nantes %v\ndespués %v", hashesBefore, hashesAfter)
+	}
+	out := t.TempDir()
+	if err := restoreWithOptions(root, meta.ID, RestoreOptions{OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, out, "sub/b.txt"); got != "b" {
+		t.Errorf("sub/b.txt = %q", got)
+	}
+	
+	// Ya está al nivel 9: una segunda pasada no lo toca
+	if err := compactArchives(root, 9); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.Stat(archive); again.Size() != after.Size() {
+		t.Errorf("la segunda pasada cambió el tamaño: %d → %d", after.Size(), again.Size())
+	}
+	
+	if err := compactArchives(root, 12); err == nil {
+		t.Error("aceptó el nivel 12")
+	}
+}
+
+func TestListSize(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", strings.Repeat("a", 5000))
+	first := mustSnapshot(t, root, "primero", SnapshotOptions{})
+	writeTestFile(t, root, "b.txt", "b")
+	second := mustSnapshot(t, root, "segundo", SnapshotOptions{})
+	
+	// La firma cuenta como parte del snapshot
+	if err := os.WriteFile(snapshotArchive(root, first.ID)+".sig", []byte("firma"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	
+	fileSize := func(path string) int64 {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0
+		}
+		return info.Size()
+	}
+	var want int64
+	for _, id := range []string{first.ID, second.ID} {
+		archive := snapshotArchive(root, id)
+		expected := fileSize(archive) + fileSize(archive+".sig") + fileSize(snapshotFilesPath(root, id))
+		got, ok := snapshotDiskSize(root, id)
+		if !ok || got != expected {
+			t.Errorf("%s: tamaño %d (ok=%v), se esperaba %d", id, got, ok, expected)
+		}
+		want += expected
+	}
+	
+	out := captureOutput(t, &os.Stdout, func() {
+		if err := listSnapshots(root, ListOptions{Size: true}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "💾 Total: "+formatSize(want)+" en 2 snapshot(s)\n") {
+		t.Errorf("total incorrecto, se esperaba %s:\n%s", formatSize(want), out)
+	}
+	
+	if err := os.Remove(snapshotArchive(root, second.ID)); err != nil {
+		t.Fatal(err)
+	}
+	firstSize, _ := snapshotDiskSize(root, first.ID)
+	out = captureOutput(t, &os.Stdout, func() {
+		if err := listSnapshots(root, ListOptions{Size: true}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "(faltante)") || !strings.Contains(out, "💾 Total: "+formatSize(firstSize)+" en 1 snapshot(s) (1 sin archivo)") {
+		t.Errorf("archivo faltante:\n%s", out)
+	}
+}
+
+func TestDiffBinarySize(t *testing.T) {
+	root := newTestRepo(t)
+	png := func(size int) string {
+		return "\x89PNG\x00" + strings.Repeat("\x01", size-5)
+	}
+	writeTestFile(t, root, "assets/logo.png", png(12*1024))
+	writeTestFile(t, root, "notas.txt", "uno")
+	older := mustSnapshot(t, root, "antes", SnapshotOptions{})
+	writeTestFile(t, root, "assets/logo.png", png(18*1024))
+	writeTestFile(t, root, "notas.txt", "dos")
+	newer := mustSnapshot(t, root, "después", SnapshotOptions{})
+	backdate(t, root, older.ID, time.Hour)
+	
+	var buf bytes.Buffer
+	if _, err := diffSnapshots(root, older.ID, newer.ID, DiffOptions{BinarySize: true, Out: &buf}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "   assets/logo.png: 12.0 KB → 18.0 KB (+6.0 KB)\n") {
+		t.Errorf("falta el cambio de tamaño del binario:\n%s", out)
+	}
+	if strings.Contains(out, "notas.txt: ") {
+		t.Errorf("un archivo de texto aparece entre los binarios:\n%s", out)
+	}
+}
+
+func TestProtectedSurvivesClean(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "hito")
+	milestone := mustSnapshot(t, root, "versión 1.0", SnapshotOptions{Protect: true})
+	var snaps []SnapshotMeta
+	for _, content := range []string{"uno", "dos", "tres"} {
+		writeTestFile(t, root, "a.txt", content)
+		snaps = append(snaps, mustSnapshot(t, root, content, SnapshotOptions{}))
+	}
+	if err := setProtected(root, snaps[0].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	
+	setArgs(t, "clean", "--keep", "1", "--permanent")
+	if err := cleanCmdWithRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, s := range readIndex(t, root).Snapshots {
+		ids = append(ids, s.ID)
+	}
+	if want := []string{milestone.ID, snaps[0].ID, snaps[2].ID}; !slices.Equal(ids, want) {
+		t.Errorf("tras clean --keep 1 quedan %v, se esperaba %v", ids, want)
+	}
+	if !fileExists(snapshotArchive(root, milestone.ID)) {
+		t.Error("se borró el archivo del snapshot protegido")
+	}
+	
+	out := captureOutput(t, &os.Stdout, func() {
+		if err := listSnapshots(root, ListOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if n := strings.Count(out, "🔒"); n != 2 {
+		t.Errorf("list muestra %d candados, se esperaban 2:\n%s", n, out)
+	}
+	
+	// Sin la protección, la siguiente limpieza sí lo borra
+	if err := setProtected(root, snaps[0].ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanCmdWithRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(readIndex(t, root).Snapshots); n != 2 {
+		t.Errorf("tras unprotect quedan %d snapshots, se esperaban 2", n)
+	}
+}
+
+func TestBareRepoRefusesWorkingTreeCommands(t *testing.T) {
+	t.Setenv("SNAPGO_GLOBAL_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	root := filepath.Join(t.TempDir(), "copias")
+	if _, stderr, code := runSnapgo(t, filepath.Dir(root), "init", "--bare", root); code != 0 {
+		t.Fatalf("init --bare: %s", stderr)
+	}
+	config, err := loadConfig(root)
+	if err != nil || !config.Bare {
+		t.Fatalf("config.bare = %v (%v)", config.Bare, err)
+	}
+	_, _, _, _, ignorePath, _ := repoPaths(root)
+	if fileExists(ignorePath) {
+		t.Error("un repositorio bare no debe tener .snapgoignore")
+	}
+	
+	writeTestFile(t, root, "a.txt", "no debería guardarse")
+	for _, args := range [][]string{{"snapshot", "-m", "x"}, {"status"}, {"restore", "latest"}} {
+		_, stderr, code := runSnapgo(t, root, args...)
+		if code == 0 || !strings.Contains(stderr, "es un repositorio bare") {
+			t.Errorf("%v: código %d, stderr %q", args, code, stderr)
+		}
+	}
+	if n := len(readIndex(t, root).Snapshots); n != 0 {
+		t.Errorf("el repositorio bare tiene %d snapshots", n)
+	}
+	
+	if _, stderr, code := runSnapgo(t, root, "list"); code != 0 {
+		t.Errorf("list en un repositorio bare: %s", stderr)
+	}
+}
+
+func TestSnapshotIncludeGitDir(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	writeTestFile(t, root, ".git/HEAD", "ref: refs/heads/main\n")
+	writeTestFile(t, root, ".git/refs/stash", "1234\n")
+	
+	files := func(meta SnapshotMeta) []string {
+		hashes, err := snapshotContentHashes(root, meta.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return slices.Sorted(maps.Keys(hashes))
+	}
+	
+	plain := files(mustSnapshot(t, root, "sin .git", SnapshotOptions{}))
+	if slices.Contains(plain, ".git/HEAD") {
+		t.Errorf("sin --include-gitdir se guardó .git: %v", plain)
+	}
+	
+	var saved []string
+	stderr := captureOutput(t, &os.Stderr, func() {
+		saved = files(mustSnapshot(t, root, "con .git", SnapshotOptions{IncludeGitDir: true}))
+	})
+	for _, name := range []string{".git/HEAD", ".git/refs/stash", "a.txt"} {
+		if !slices.Contains(saved, name) {
+			t.Errorf("falta %s en %v", name, saved)
+		}
+	}
+	for _, name := range saved {
+		if strings.HasPrefix(name, ".snapgo/") {
+			t.Errorf("se guardó %s", name)
+		}
+	}
+	if !strings.Contains(stderr, "⚠️  --include-gitdir: .git/ añade 2 archivos") {
+		t.Errorf("falta el aviso de tamaño:\n%s", stderr)
+	}
+	
+	// Solo para ese snapshot: la configuración no cambia
+	ignores, err := loadIgnore(root)
+	if err != nil || !slices.Contains(ignores, ".git/") {
+		t.Errorf("el ignore efectivo perdió .git/: %v (%v)", ignores, err)
+	}
+	writeTestFile(t, root, "a.txt", "b")
+	if next := files(mustSnapshot(t, root, "otra vez sin .git", SnapshotOptions{})); slices.Contains(next, ".git/HEAD") {
+		t.Error("el siguiente snapshot también incluyó .git")
+	}
+}
+
+func TestAtomicExtractedFileSurvivesWriteError(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "uno.txt", "uno antiguo")
+	writeTestFile(t, dir, "dos.txt", "dos antiguo")
+	
+	if err := writeExtractedFile(filepath.Join(dir, "uno.txt"), strings.NewReader("uno nuevo y completo"), true, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := writeExtractedFile(filepath.Join(dir, "dos.txt"), &failingReader{data: []byte("dos a me")}, true, 0o644)
+	if err == nil {
+		t.Fatal("la escritura interrumpida no devolvió error")
+	}
+	
+	if got := readTestFile(t, dir, "uno.txt"); got != "uno nuevo y completo" {
+		t.Errorf("uno.txt = %q", got)
+	}
+	if got := readTestFile(t, dir, "dos.txt"); got != "dos antiguo" {
+		t.Errorf("dos.txt quedó a medias: %q", got)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".snapgo-") {
+			t.Errorf("quedó el temporal %s", e.Name())
+		}
+	}
+	
+	// Sin atomic el archivo se trunca: es lo que evita el modo por defecto
+	writeExtractedFile(filepath.Join(dir, "dos.txt"), &failingReader{data: []byte("dos a me")}, false, 0o644)
+	if got := readTestFile(t, dir, "dos.txt"); got != "dos a me" {
+		t.Errorf("sin atomic dos.txt = %q", got)
+	}
+}
+
+func TestStatsChurn(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "caliente.go", "v0")
+	writeTestFile(t, root, "tibio.go", "v0")
+	writeTestFile(t, root, "frio.txt", "siempre igual")
+	mustSnapshot(t, root, "inicial", SnapshotOptions{})
+	for i := 1; i <= 3; i++ {
+		writeTestFile(t, root, "caliente.go", fmt.Sprintf("v%d", i))
+		if i == 2 {
+			writeTestFile(t, root, "tibio.go", "v1")
+		}
+		mustSnapshot(t, root, fmt.Sprintf("cambio %d", i), SnapshotOptions{})
+	}
+	
+	entries, unreadable := fileChurn(root, readIndex(t, root))
+	want := []churnEntry{{Path: "caliente.go", Changes: 3}, {Path: "tibio.go", Changes: 1}}
+	if unreadable != 0 || !reflect.DeepEqual(entries, want) {
+		t.Errorf("churn = %v (%d ilegibles), se esperaba %v", entries, unreadable, want)
+	}
+	
+	out := captureOutput(t, &os.Stdout, func() {
+		if err := printChurn(root, readIndex(t, root), 1, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var got []churnEntry
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	if !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("--limit 1 --json = %v", got)
+	}
+}
+
+func TestAttributesNoCompressAndText(t *testing.T) {
+	root := newTestRepo(t)
+	saved := attributeWarnings
+	attributeWarnings = map[string]bool{}
+	t.Cleanup(func() { attributeWarnings = saved })
+	
+	writeTestFile(t, root, attributesFile, "# por patrón\n*.dat nocompress\n*.csv text\n*.log veloz\n")
+	var attrs fileAttrs
+	stderr := captureOutput(t, &os.Stderr, func() {
+		var err error
+		if attrs, err = loadAttributes(root); err != nil {
+			t.Fatal(err)
+		}
+		loadAttributes(root)
+	})
+	if stderr != "⚠️  .snapgoattributes:4: atributo desconocido 'veloz'\n" {
+		t.Errorf("aviso del atributo desconocido (una sola vez): %q", stderr)
+	}
+	
+	// nocompress: el .dat va con nivel 0 aunque se comprimiría muy bien
+	data := strings.Repeat("0123456789", 10000)
+	writeTestFile(t, root, "muestras.dat", data)
+	writeTestFile(t, root, "notas.txt", data)
+	out := filepath.Join(t.TempDir(), "a.tar.gz")
+	stats, err := writeTarGz(context.Background(), root, out, []string{"muestras.dat", "notas.txt"}, writeOptions{Compression: 6, Attrs: attrs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.StoredFiles != 1 || stats.StoredBytes != int64(len(data)) || stats.CompressedFiles != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if info, _ := os.Stat(out); info.Size() < int64(len(data)) {
+		t.Errorf("el archivo ocupa %d bytes: muestras.dat se comprimió", info.Size())
+	}
+	
+	// text: un CSV con bytes nulos se compara línea a línea
+	older := []byte("id,valor\n1,\x00a\n")
+	newer := []byte("id,valor\n1,\x00b\n")
+	var buf bytes.Buffer
+	printFileDiff(&buf, "datos.csv", older, newer, DiffOptions{Attrs: attrs})
+	if diff := buf.String(); strings.Contains(diff, "binarios") || !strings.Contains(diff, "+1,\x00b") {
+		t.Errorf("datos.csv con text:\n%s", diff)
+	}
+	buf.Reset()
+	printFileDiff(&buf, "datos.bin", older, newer, DiffOptions{Attrs: attrs})
+	if !strings.Contains(buf.String(), "Los archivos binarios son distintos") {
+		t.Errorf("sin atributo, los bytes nulos deberían marcarlo como binario:\n%s", buf.String())
+	}
+}
+
+func TestOrphanTrashFlaggedAndRemoved(t *testing.T) {
+	root := newTestRepo(t)
+	for _, content := range []string{"uno", "dos"} {
+		writeTestFile(t, root, "a.txt", content)
+		mustSnapshot(t, root, content, SnapshotOptions{})
+	}
+	setArgs(t, "clean", "--keep", "1", "--trash")
+	if err := cleanCmdWithRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	
+	// Un movimiento a la papelera interrumpido: archivos sin .meta.json
+	_, _, _, _, _, trashDir := repoPaths(root)
+	writeTestFile(t, trashDir, "20240101_120000/src/medio.go", "package a")
+	
+	incomplete, err := incompleteTrashEntries(root)
+	if err != nil || !slices.Equal(incomplete, []string{"20240101_120000"}) {
+		t.Fatalf("entradas incompletas: %v (%v)", incomplete, err)
+	}
+	
+	// trash restore pide confirmación antes de restaurar una entrada a medias
+	setStdin(t, "n\n")
+	captureOutput(t, &os.Stdout, func() {
+		if err := restoreFromTrash(root, "20240101_120000"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if fileExists(filepath.Join(root, "src", "medio.go")) {
+		t.Error("se restauró la entrada incompleta sin confirmar")
+	}
+	
+	setStdin(t, "s\n")
+	out := captureOutput(t, &os.Stdout, func() {
+		if err := removeIncompleteTrash(root); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "• 20240101_120000  (1 archivo)") {
+		t.Errorf("salida:\n%s", out)
+	}
+	entries := trashEntries(t, root)
+	if len(entries) != 1 {
+		t.Fatalf("quedan en la papelera: %v", entries)
+	}
+	for name := range entries {
+		if !strings.HasPrefix(name, "pruned_") {
+			t.Errorf("se conservó %s en vez de la entrada completa", name)
+		}
+	}
+}
+
+func TestSnapshotTimeout(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	mustSnapshot(t, root, "inicial", SnapshotOptions{})
+	_, snapsDir, _, _, _, _ := repoPaths(root)
+	before, _ := os.ReadDir(snapsDir)
+	
+	// Con 1 MB/s, 4 MB tardan unos 4 s: el plazo vence a mitad de escritura
+	writeTestFile(t, root, "lento.dat", strings.Repeat("x", 4<<20))
+	start := time.Now()
+	_, err := createSnapshot(root, "no termina", SnapshotOptions{ThrottleMBps: 1, Timeout: 200 * time.Millisecond})
+	if !errors.Is(err, errSnapshotTimeout) {
+		t.Fatalf("error = %v, se esperaba el del tiempo límite", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("tardó %v en abortar", elapsed)
+	}
+	if !strings.Contains(err.Error(), "escritos ") {
+		t.Errorf("el error no dice hasta dónde llegó: %v", err)
+	}
+	
+	after, _ := os.ReadDir(snapsDir)
+	if len(after) != len(before) {
+		t.Errorf("quedaron restos del snapshot abortado: %v", after)
+	}
+	if n := len(readIndex(t, root).Snapshots); n != 1 {
+		t.Errorf("el índice tiene %d snapshots", n)
+	}
+}
+
+func TestListBranchFilter(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "main")
+	onMain := mustSnapshot(t, root, "en main", SnapshotOptions{})
+	if err := createBranch(root, "feature-x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := switchBranch(root, "feature-x"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "feature")
+	onFeature := mustSnapshot(t, root, "en feature", SnapshotOptions{})
+	
+	list := func(opts ListOptions) string {
+		return captureOutput(t, &os.Stdout, func() {
+			if err := listSnapshots(root, opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	
+	out := list(ListOptions{Branch: "feature-x", Size: true})
+	if !strings.Contains(out, onFeature.ID) || strings.Contains(out, onMain.ID) {
+		t.Errorf("--branch feature-x:\n%s", out)
+	}
+	if !strings.Contains(out, "en 1 snapshot(s)") {
+		t.Errorf("--size no se limita a la rama:\n%s", out)
+	}
+	
+	out = list(ListOptions{All: true})
+	if !strings.Contains(out, onMain.ID+"  ") || !strings.Contains(out, "  [main]  ") || !strings.Contains(out, "  [feature-x]  ") {
+		t.Errorf("--all:\n%s", out)
+	}
+	
+	if err := listSnapshots(root, ListOptions{Branch: "no-existe"}); err == nil {
+		t.Error("--branch con una rama inexistente no falló")
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	root := newTestRepo(t)
+	if err := configSet(root, "warn_file_count", "1"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "a")
+	writeTestFile(t, root, "b.txt", "b")
+	
+	stdout, stderr, code := runSnapgo(t, root, "snapshot", "--json", "-m", "para scripts")
+	if code != 0 {
+		t.Fatalf("snapshot --json: %s", stderr)
+	}
+	var got snapshotJSON
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("stdout no es JSON válido: %v\n%s", err, stdout)
+	}
+	if dec.More() {
+		t.Errorf("hay algo más que el JSON en stdout:\n%s", stdout)
+	}
+	
+	idx := readIndex(t, root)
+	want := idx.Snapshots[len(idx.Snapshots)-1]
+	if got.ID != want.ID || got.Message != "para scripts" || got.FileCount != 3 {
+		t.Errorf("metadatos: %+v, se esperaba el snapshot %s", got.SnapshotMeta, want.ID)
+	}
+	info, err := os.Stat(snapshotArchive(root, got.ID))
+	if err != nil || got.ArchiveSize != info.Size() || got.CompressionLevel != 6 {
+		t.Errorf("archive_size %d, compression_level %d", got.ArchiveSize, got.CompressionLevel)
+	}
+	
+	// El aviso de warn_file_count va a stderr y no ensucia el JSON
+	if !strings.Contains(stderr, "⚠️") {
+		t.Errorf("falta el aviso en stderr: %q", stderr)
+	}
+}
+
+func TestDiffContextLines(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("línea %d", i))
+	}
+	older := strings.Join(lines, "\n") + "\n"
+	lines[9] = "línea 10 cambiada"
+	newer := strings.Join(lines, "\n") + "\n"
+	
+	tests := []struct {
+		context int
+		header  string
+	}{
+		{0, "@@ -10,1 +10,1 @@"},
+		{1, "@@ -9,3 +9,3 @@"},
+		{3, "@@ -7,7 +7,7 @@"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		printFileDiff(&buf, "a.txt", []byte(older), []byte(newer), DiffOptions{Context: tt.context})
+		unchanged := 0
+		for _, l := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(l, " ") {
+				unchanged++
+			}
+		}
+		if unchanged != 2*tt.context || !strings.Contains(buf.String(), tt.header+"\n") {
+			t.Errorf("--context %d: %d líneas de contexto\n%s", tt.context, unchanged, buf.String())
+		}
+	}
+	
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", older)
+	mustSnapshot(t, root, "antes", SnapshotOptions{})
+	writeTestFile(t, root, "a.txt", newer)
+	stdout, _, _ := runSnapgo(t, root, "diff", "--working", "--patch", "--no-color", "-U", "1")
+	if !strings.Contains(stdout, "@@ -9,3 +9,3 @@\n línea 9\n-línea 10\n+línea 10 cambiada\n línea 11\n") {
+		t.Errorf("diff -U 1:\n%s", stdout)
+	}
+}
+
+func TestReflogRecordsSwitch(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "main")
+	onMain := mustSnapshot(t, root, "en main", SnapshotOptions{})
+	if err := createBranch(root, "feature-x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := switchBranch(root, "feature-x"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "feature")
+	onFeature := mustSnapshot(t, root, "en feature", SnapshotOptions{})
+	if err := switchBranch(root, "main"); err != nil {
+		t.Fatal(err)
+	}
+	
+	out := captureOutput(t, &os.Stdout, func() {
+		if err := printReflog(root, 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "  @{0}  ") || !strings.Contains(out, "switch: de 'feature-x' a 'main'") ||
+		!strings.Contains(out, onFeature.ID+" → "+onMain.ID) {
+		t.Errorf("reflog:\n%s", out)
+	}
+	if strings.Contains(out, "@{1}") {
+		t.Errorf("-n 1 mostró más de una entrada:\n%s", out)
+	}
+	
+	// @{1}: la cabeza antes del último switch
+	if id, err := resolveSpecialID(root, "@{1}"); err != nil || id != onFeature.ID {
+		t.Errorf("@{1} = %s (%v), se esperaba %s", id, err, onFeature.ID)
+	}
+	if _, err := resolveSpecialID(root, "@{99}"); err == nil {
+		t.Error("@{99} más allá del reflog no falló")
+	}
+	
+	// El reflog tiene un tope y descarta las entradas más antiguas
+	for i := 0; i < reflogMaxEntries; i++ {
+		if err := appendReflog(root, ReflogEntry{Command: fmt.Sprintf("relleno %d", i), New: onMain.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := readReflog(root)
+	if err != nil || len(entries) != reflogMaxEntries || entries[len(entries)-1].Command != fmt.Sprintf("relleno %d", reflogMaxEntries-1) {
+		t.Errorf("tras rebasar el tope hay %d entradas (%v)", len(entries), err)
+	}
+}
+
+// Nombres de las entradas de un .tar.gz, en orden
+func archiveEntryNames(t *testing.T, archive string) []string {
+	t.Helper()
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestIncrementalSnapshotRoundTrip(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "igual.txt", "no cambia")
+	writeTestFile(t, root, "cambia.txt", "v1")
+	writeTestFile(t, root, "borrar.txt", "adiós")
+	base := mustSnapshot(t, root, "base", SnapshotOptions{})
+	
+	writeTestFile(t, root, "cambia.txt", "v2")
+	writeTestFile(t, root, "nuevo.txt", "hola")
+	os.Remove(filepath.Join(root, "borrar.txt"))
+	inc := mustSnapshot(t, root, "incremental", SnapshotOptions{SinceSnapshot: base.ID})
+	if inc.Base != base.ID {
+		t.Fatalf("Base = %q, se esperaba %s", inc.Base, base.ID)
+	}
+	
+	// El archivo solo guarda lo cambiado; el resto se lee de la base
+	stored := archiveEntryNames(t, snapshotArchive(root, inc.ID))
+	for _, name := range stored {
+		if name == "igual.txt" || name == "borrar.txt" {
+			t.Errorf("el incremental guarda %s", name)
+		}
+	}
+	want := map[string]string{".snapgoignore": "", "igual.txt": "no cambia", "cambia.txt": "v2", "nuevo.txt": "hola"}
+	hashes, err := snapshotContentHashes(root, inc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Sorted(maps.Keys(hashes)); !slices.Equal(got, slices.Sorted(maps.Keys(want))) {
+		t.Errorf("archivos del incremental: %v", got)
+	}
+	
+	out := t.TempDir()
+	if err := restoreWithOptions(root, inc.ID, RestoreOptions{OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range want {
+		if name != ".snapgoignore" && readTestFile(t, out, name) != content {
+			t.Errorf("%s = %q, se esperaba %q", name, readTestFile(t, out, name), content)
+		}
+	}
+	if fileExists(filepath.Join(out, "borrar.txt")) {
+		t.Error("se restauró borrar.txt, que no estaba en el incremental")
+	}
+	
+	// export escribe un archivo completo, que se lee sin la base
+	exported := filepath.Join(t.TempDir(), "inc.tar.gz")
+	captureOutput(t, &os.Stdout, func() {
+		if err := exportSnapshot(root, inc.ID, exported); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if got := archiveEntryNames(t, exported); !slices.Contains(got, "igual.txt") || slices.Contains(got, "borrar.txt") {
+		t.Errorf("export del incremental: %v", got)
+	}
+	
+	if err := purgeSnapshot(root, base.ID, true); err == nil || !strings.Contains(err.Error(), "es la base del incremental") {
+		t.Errorf("purge de la base: %v", err)
+	}
+	
+	// Sin el archivo de la base, restore falla antes de tocar el directorio
+	os.Rename(snapshotArchive(root, base.ID), snapshotArchive(root, base.ID)+".fuera")
+	writeTestFile(t, root, "cambia.txt", "trabajo sin guardar")
+	if err := restoreWithOptions(root, inc.ID, RestoreOptions{Force: true}); err == nil || !strings.Contains(err.Error(), "falta el archivo del snapshot base") {
+		t.Errorf("restore sin la base: %v", err)
+	}
+	if got := readTestFile(t, root, "cambia.txt"); got != "trabajo sin guardar" {
+		t.Errorf("restore tocó el directorio: cambia.txt = %q", got)
+	}
+	
+	if _, err := createSnapshot(root, "sin base", SnapshotOptions{SinceSnapshot: "20000101-000000-000000000000"}); err == nil {
+		t.Error("--since-snapshot con una base inexistente no falló")
+	}
+}
+
+func TestClampInvalidConfig(t *testing.T) {
+	root := newTestRepo(t)
+	saved := clampWarnings
+	clampWarnings = map[string]bool{}
+	t.Cleanup(func() { clampWarnings = saved })
+	
+	// Sin clamp_invalid_config el valor fuera de rango se rechaza
+	if err := configSet(root, "compression_level", "42"); err == nil || !strings.Contains(err.Error(), "entre 0 y 9") {
+		t.Errorf("clamp off: %v", err)
+	}
+	if config, _ := loadConfig(root); config.Compression != 6 {
+		t.Errorf("clamp off: compression_level = %d tras el error", config.Compression)
+	}
+	
+	if err := configSet(root, "clamp_invalid_config", "true"); err != nil {
+		t.Fatal(err)
+	}
+	stderr := captureOutput(t, &os.Stderr, func() {
+		if err := configSet(root, "compression_level", "42"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if stderr != "⚠️  compression_level ajustado de 42 a 9 (clamp_invalid_config)\n" {
+		t.Errorf("aviso: %q", stderr)
+	}
+	if config, _ := loadConfig(root); config.Compression != 9 {
+		t.Errorf("clamp on: compression_level = %d, se esperaba 9", config.Compression)
+	}
+	
+	// Valores editados a mano: se ajustan al cargar
+	_, _, _, configPath, _, _ := repoPaths(root)
+	fields, err := loadRepoConfig(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields["compression_level"] = -3
+	fields["max_snapshots"] = -1
+	if err := writeJSON(configPath, fields); err != nil {
+		t.Fatal(err)
+	}
+	var config Config
+	stderr = captureOutput(t, &os.Stderr, func() {
+		config, err = loadConfig(root)
+	})
+	if err != nil || config.Compression != 0 || config.MaxSnapshots != 0 {
+		t.Errorf("clamp on al cargar: compression_level %d, max_snapshots %d (%v)", config.Compression, config.MaxSnapshots, err)
+	}
+	if !strings.Contains(stderr, "compression_level ajustado de -3 a 0") || !strings.Contains(stderr, "max_snapshots ajustado de -1 a 0") {
+		t.Errorf("avisos al cargar: %q", stderr)
+	}
+}
+
+func TestExportZip(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "docs/léeme.txt", "hola desde snapgo")
+	writeTestFile(t, root, "run.sh", "#!/bin/sh\necho hola\n")
+	os.Chmod(filepath.Join(root, "run.sh"), 0o755)
+	first := mustSnapshot(t, root, "primero", SnapshotOptions{})
+	writeTestFile(t, root, "docs/léeme.txt", "segunda versión")
+	second := mustSnapshot(t, root, "segundo", SnapshotOptions{})
+	
+	readZip := func(path string) map[string]*zip.File {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatalf("%s no es un zip válido: %v", path, err)
+		}
+		t.Cleanup(func() { zr.Close() })
+		files := map[string]*zip.File{}
+		for _, f := range zr.File {
+			files[f.Name] = f
+		}
+		return files
+	}
+	content := func(f *zip.File) string {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	
+	out := filepath.Join(t.TempDir(), "primero.zip")
+	if err := exportSnapshotZip(root, first.ID, out); err != nil {
+		t.Fatal(err)
+	}
+	files := readZip(out)
+	if got := slices.Sorted(maps.Keys(files)); !slices.Equal(got, []string{".snapgoignore", "docs/léeme.txt", "run.sh"}) {
+		t.Errorf("entradas del zip: %v", got)
+	}
+	if got := content(files["docs/léeme.txt"]); got != "hola desde snapgo" {
+		t.Errorf("docs/léeme.txt = %q", got)
+	}
+	if runtime.GOOS != "windows" && files["run.sh"].Mode().Perm() != 0o755 {
+		t.Errorf("run.sh con permisos %v", files["run.sh"].Mode().Perm())
+	}
+	
+	all := filepath.Join(t.TempDir(), "todo.zip")
+	if err := exportAllZip(root, all); err != nil {
+		t.Fatal(err)
+	}
+	files = readZip(all)
+	if f := files[second.ID+"/docs/léeme.txt"]; f == nil || content(f) != "segunda versión" {
+		t.Errorf("export --all: falta %s/docs/léeme.txt", second.ID)
+	}
+	if files[first.ID+"/run.sh"] == nil {
+		t.Errorf("export --all: falta %s/run.sh", first.ID)
+	}
+	
+	if err := exportSnapshotZip(root, "20000101-000000-000000000000", filepath.Join(t.TempDir(), "x.zip")); err == nil {
+		t.Error("exportó un snapshot inexistente")
+	}
+}
+
+func TestImportIncrementalRestores(t *testing.T) {
+	src := newTestRepo(t)
+	writeTestFile(t, src, "a.txt", "sin cambios")
+	writeTestFile(t, src, "b.txt", "v1")
+	base := mustSnapshot(t, src, "base", SnapshotOptions{})
+	writeTestFile(t, src, "b.txt", "v2")
+	inc := mustSnapshot(t, src, "incremental", SnapshotOptions{SinceSnapshot: base.ID})
+	
+	dst := newTestRepo(t)
+	captureOutput(t, &os.Stdout, func() {
+		if err := importRepo(dst, src, ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+	out := t.TempDir()
+	if err := restoreWithOptions(dst, inc.ID, RestoreOptions{OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "sin cambios", "b.txt": "v2"} {
+		if got := readTestFile(t, out, name); got != want {
+			t.Errorf("%s = %q, se esperaba %q", name, got, want)
+		}
+	}
+	if !fileExists(filepath.Join(out, ".snapgoignore")) {
+		t.Error("falta .snapgoignore, heredado de la base")
+	}
+	
+	// Con --rename la base renombrada arrastra al incremental; con --ours
+	// el incremental se quedaría sin su base
+	local := Index{Snapshots: []SnapshotMeta{{ID: "b", Hash: "local"}}}
+	incoming := Index{Snapshots: []SnapshotMeta{{ID: "b", Hash: "otro"}, {ID: "i", Hash: "h", Parent: "b", Base: "b"}}}
+	items, renamed, _ := planImport(local, incoming, "rename")
+	if len(items) != 2 || items[1].meta.Base != "b-2" {
+		t.Errorf("--rename: %+v", items)
+	}
+	if err := checkImportBases(local, incoming, items, renamed); err != nil {
+		t.Errorf("--rename: %v", err)
+	}
+	items, renamed, _ = planImport(local, incoming, "ours")
+	if err := checkImportBases(local, incoming, items, renamed); err == nil {
+		t.Error("--ours importaría un incremental sobre una base local distinta")
+	}
+}
+
+func TestVerifySignaturesMissingSig(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	mustSnapshot(t, root, "sin firmar", SnapshotOptions{})
+	
+	var err error
+	out := captureOutput(t, &os.Stdout, func() { err = verifySnapshots(root, true, false) })
+	if err != nil || !strings.Contains(out, "sin firma") {
+		t.Errorf("un snapshot nunca firmado solo avisa: %v\n%s", err, out)
+	}
+	
+	// Firmado según el índice, pero sin .sig: alguien la borró
+	_, _, indexPath, _, _, _ := repoPaths(root)
+	idx := readIndex(t, root)
+	idx.Snapshots[0].SignatureKey = "ABCDEF0123456789"
+	if err := writeJSON(indexPath, idx); err != nil {
+		t.Fatal(err)
+	}
+	out = captureOutput(t, &os.Stdout, func() { err = verifySnapshots(root, true, false) })
+	if err == nil || !strings.Contains(out, "falta la firma") {
+		t.Errorf("verify --signatures sin el .sig de un snapshot firmado: %v\n%s", err, out)
+	}
+}
+
+func TestCleanGFSKeepsIndexWhenTrashFails(t *testing.T) {
+	root := newTestRepo(t)
+	var snaps []SnapshotMeta
+	for _, content := range []string{"uno", "dos", "tres"} {
+		writeTestFile(t, root, "a.txt", content)
+		snaps = append(snaps, mustSnapshot(t, root, content, SnapshotOptions{}))
+	}
+	// Los dos primeros, del mismo día de hace una semana: GFS conserva uno
+	backdate(t, root, snaps[0].ID, 7*24*time.Hour+time.Minute)
+	backdate(t, root, snaps[1].ID, 7*24*time.Hour)
+	policy := RetentionConfig{KeepAllHours: 1, DailyDays: 30}
+	
+	// Un archivo donde debería estar la papelera hace fallar el movimiento
+	_, _, _, _, _, trashDir := repoPaths(root)
+	if err := os.RemoveAll(trashDir); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Dir(trashDir), filepath.Base(trashDir), "no es un directorio")
+	if err := cleanGFS(root, policy, false, true); err == nil {
+		t.Fatal("clean --policy gfs --trash no falló sin papelera")
+	}
+	if n := len(readIndex(t, root).Snapshots); n != 3 {
+		t.Errorf("tras el fallo el índice tiene %d snapshots, se esperaban 3", n)
+	}
+	
+	if err := cleanGFS(root, policy, false, false); err != nil {
+		t.Fatal(err)
+	}
+	idx := readIndex(t, root)
+	if len(idx.Snapshots) != 2 {
+		t.Fatalf("tras la limpieza quedan %d snapshots", len(idx.Snapshots))
+	}
+	for _, s := range idx.Snapshots {
+		if !fileExists(snapshotArchive(root, s.ID)) {
+			t.Errorf("%s sigue en el índice sin su archivo", s.ID)
+		}
+	}
+}
+
+func TestRestoreForceStripKeepsOnlyStrippedFiles(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "top/a.txt", "igual")
+	writeTestFile(t, root, "top/b.txt", "b")
+	snap := mustSnapshot(t, root, "base", SnapshotOptions{})
+	
+	// top/a.txt coincide con el snapshot, pero con --strip 1 su sitio es a.txt
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{Force: true, Strip: 1, NoBackup: true}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "igual", "b.txt": "b"} {
+		if got := readTestFile(t, root, name); got != want {
+			t.Errorf("%s = %q, se esperaba %q", name, got, want)
+		}
+	}
+	if fileExists(filepath.Join(root, "top", "a.txt")) {
+		t.Error("top/a.txt quedó junto a la copia sin el prefijo")
+	}
+	
+	// Ya restaurado, una segunda vez no reescribe a.txt
+	if keep := unchangedFiles(root, snap.ID, 1); !keep["a.txt"] || !keep["b.txt"] || len(keep) != 2 {
+		t.Errorf("unchangedFiles con strip 1: %v", keep)
+	}
+}
+
+func TestQuietInitAndClone(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	mustSnapshot(t, root, "uno", SnapshotOptions{})
+	
+	// quiet ya está activo (newTestRepo), como con -q
+	stdout := captureOutput(t, &os.Stdout, func() {
+		if _, err := createRepo(root, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := cloneRepo(root, filepath.Join(t.TempDir(), "clon"), false, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if stdout != "" {
+		t.Errorf("salida en modo silencioso: %q", stdout)
+	}
+}
+
+func TestRestoreKeepNewerCounts(t *testing.T) {
+	root := newTestRepo(t)
+	for _, name := range []string{"falta", "viejo", "nuevo"} {
+		writeTestFile(t, root, name+".txt", "snapshot")
+	}
+	snap := mustSnapshot(t, root, "base", SnapshotOptions{})
+	
+	os.Remove(filepath.Join(root, "falta.txt"))
+	writeTestFile(t, root, "viejo.txt", "editado antes del snapshot")
+	writeTestFile(t, root, "nuevo.txt", "editado después del snapshot")
+	past := time.Now().Add(-24 * time.Hour)
+	future := time.Now().Add(24 * time.Hour)
+	os.Chtimes(filepath.Join(root, "viejo.txt"), past, past)
+	os.Chtimes(filepath.Join(root, "nuevo.txt"), future, future)
+	
+	stdout, stderr, code := runSnapgo(t, root, "restore", snap.ID, "--keep-newer")
+	if code != 0 {
+		t.Fatalf("restore --keep-newer: %s", stderr)
+	}
+	want := map[string]string{"falta.txt": "snapshot", "viejo.txt": "snapshot", "nuevo.txt": "editado después del snapshot"}
+	for name, content := range want {
+		if got := readTestFile(t, root, name); got != content {
+			t.Errorf("%s = %q, se esperaba %q", name, got, content)
+		}
+	}
+	// .snapgoignore no ha cambiado y también se conserva
+	for _, line := range []string{"Creados: 1\n", "Sobrescritos (el snapshot era más reciente): 1\n", "Conservados (la versión del disco es igual o más reciente): 2\n"} {
+		if !strings.Contains(stdout, line) {
+			t.Errorf("falta %q en:\n%s", line, stdout)
+		}
+	}
+}
+
+func TestSwitchDashReturnsToPreviousBranch(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "a")
+	mustSnapshot(t, root, "en main", SnapshotOptions{})
+	
+	if err := switchBranch(root, "-"); err == nil || !strings.Contains(err.Error(), "no hay rama anterior") {
+		t.Errorf("switch - sin rama anterior: %v", err)
+	}
+	
+	// branch crea la rama y cambia a ella
+	if err := createBranch(root, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if idx := readIndex(t, root); idx.Current != "b" || idx.LastBranch != "main" {
+		t.Fatalf("tras crear b: actual %q, anterior %q", idx.Current, idx.LastBranch)
+	}
+	
+	if err := switchBranch(root, "-"); err != nil {
+		t.Fatal(err)
+	}
+	if idx := readIndex(t, root); idx.Current != "main" || idx.LastBranch != "b" {
+		t.Errorf("tras switch -: actual %q, anterior %q", idx.Current, idx.LastBranch)
+	}
+	
+	// Un segundo "-" alterna otra vez
+	if err := switchBranch(root, "-"); err != nil {
+		t.Fatal(err)
+	}
+	if idx := readIndex(t, root); idx.Current != "b" {
+		t.Errorf("tras el segundo switch -: actual %q", idx.Current)
+	}
+	
+	// Con un switch explícito b → main, "-" vuelve a b
+	if err := switchBranch(root, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := switchBranch(root, "-"); err != nil {
+		t.Fatal(err)
+	}
+	if idx := readIndex(t, root); idx.Current != "b" || idx.LastBranch != "main" {
+		t.Errorf("tras switch main y switch -: actual %q, anterior %q", idx.Current, idx.LastBranch)
+	}
+}
@@ -0,0 +1,334 @@
+//go:build unix
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestPreserveOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("cambiar el propietario de un archivo necesita root")
+	}
+	root := newTestRepo(t)
+	if err := configSet(root, "preserve_ownership", "true"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, root, "a.txt", "a")
+	if err := os.Chown(filepath.Join(root, "a.txt"), 1234, 5678); err != nil {
+		t.Fatal(err)
+	}
+	snap := mustSnapshot(t, root, "propietario", SnapshotOptions{})
+	
+	out := t.TempDir()
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(out, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if st.Uid != 1234 || st.Gid != 5678 {
+		t.Errorf("uid/gid = %d/%d, se esperaba 1234/5678", st.Uid, st.Gid)
+	}
+	
+	if err := configSet(root, "preserve_ownership", "false"); err != nil {
+		t.Fatal(err)
+	}
+	out = t.TempDir()
+	if err := restoreWithOptions(root, snap.ID, RestoreOptions{OutDir: out}); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(filepath.Join(out, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := info.Sys().(*syscall.Stat_t); st.Uid != uint32(os.Getuid()) {
+		t.Errorf("sin preserve_ownership el uid es %d", st.Uid)
+	}
+}
+
+func TestFollowSymlinksLoop(t *testing.T) {
+	root := newTestRepo(t)
+	writeTestFile(t, root, "dir/a.txt", "a")
+	if err := os.Symlink("..", filepath.Join(root, "dir", "bucle")); err != nil {
+		t.Fatal(err)
+	}
+	
+	files, err := collectFilesWith(context.Background(), root, nil, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(files, "dir/bucle") {
+		t.Errorf("sin --follow-symlinks el enlace se guarda como enlace: %v", files)
+	}
+	
+	var followed []string
+	stderr := captureOutput(t, &os.Stderr, func() {
+		followed, err = collectFilesWith(context.Background(), root, nil, 0, true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr, "posible bucle") {
+		t.Errorf("falta el aviso del bucle: %q", stderr)
+	}
+	for _, f := range followed {
+		if strings.HasPrefix(f, "dir/bucle") {
+			t.Errorf("se recorrió el bucle: %s", f)
+		}
+	}
+}
+
+func TestExtractRefusesPathThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "malo.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "d", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0o777})
+	tw.WriteHeader(&tar.Header{Name: "d/x", Typeflag: tar.TypeReg, Size: 4, Mode: 0o644})
+	tw.Write([]byte("malo"))
+	tw.Close()
+	gw.Close()
+	f.Close()
+	
+	if _, _, err := extractTarGzFiltered(archive, t.TempDir(), extractOptions{}); err == nil {
+		t.Error("se extrajo una entrada a través de un enlace del propio archivo")
+	}
+	if fileExists(filepath.Join(outside, "x")) {
+		t.Error("se escribió fuera del destino")
+	}
+}
+
+// Sustituye git en el PATH por un script de shell con el cuerpo dado
+func stubGit(t *testing.T, body string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" + body
+	if err := os.WriteFile(filepath.Join(dir, "git"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRespectGitStatus(t *testing.T) {
+	stubGit(t, `case "$3" in
+rev-parse) exit 0 ;;
+check-ignore) cat >/dev/null; printf 'build.log\000' ;;
+ls-files) printf 'a.txt\000.snapgoignore\000' ;;
+esac
+`)
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "versionado")
+	writeTestFile(t, root, "nuevo.txt", "sin versionar")
+	writeTestFile(t, root, "build.log", "ignorado por git")
+	
+	collect := func() []string {
+		ignores, _ := loadIgnore(root)
+		files, err := collectFiles(root, ignores)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	
+	// Sin el *.log del .snapgoignore por defecto, que ya excluye build.log
+	_, _, _, _, ignorePath, _ := repoPaths(root)
+	os.WriteFile(ignorePath, nil, 0o644)
+	
+	if err := configSet(root, "respect_git_status", gitRespectIgnored); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(collect(), " "); got != ".snapgoignore a.txt nuevo.txt" {
+		t.Errorf("ignored: %s", got)
+	}
+	if err := configSet(root, "respect_git_status", gitRespectTracked); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(collect(), " "); got != ".snapgoignore a.txt" {
+		t.Errorf("tracked: %s", got)
+	}
+	
+	stubGit(t, "exit 128\n")
+	if got := strings.Join(collect(), " "); got != ".snapgoignore a.txt build.log nuevo.txt" {
+		t.Errorf("fuera de un repositorio git: %s", got)
+	}
+}
+
+func TestPluginDispatch(t *testing.T) {
+	bin := t.TempDir()
+	plugin := "#!/bin/sh\necho \"dir=$SNAPGO_DIR args=$*\"\nexit 3\n"
+	if err := os.WriteFile(filepath.Join(bin, "snapgo-foo"), []byte(plugin), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	root := newTestRepo(t)
+	
+	stdout, _, code := runSnapgo(t, root, "foo", "-x", "bar")
+	if code != 3 {
+		t.Errorf("código de salida %d, se esperaba el del plugin (3)", code)
+	}
+	if want := "dir=" + root + " args=-x bar\n"; stdout != want {
+		t.Errorf("salida %q, se esperaba %q", stdout, want)
+	}
+	
+	stdout, _, _ = runSnapgo(t, root, "noexiste")
+	if !strings.Contains(stdout, "Comando desconocido: noexiste") {
+		t.Errorf("sin plugin: %q", stdout)
+	}
+}
+
+// git falso que anota cada argumento en una línea y cada llamada con "--"
+func stubGitLog(t *testing.T) string {
+	t.Helper()
+	log := filepath.Join(t.TempDir(), "git.log")
+	stubGit(t, `printf '%s\n' "$@" -- >> '`+log+"'\n")
+	return log
+}
+
+func TestGitModeRemoteAndBranch(t *testing.T) {
+	log := stubGitLog(t)
+	root := newTestRepo(t)
+	if err := configSet(root, "git_mode", "true"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configSet(root, "git.remote", "upstream"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configSet(root, "git.branch", "desarrollo"); err != nil {
+		t.Fatal(err)
+	}
+	
+	for _, args := range [][]string{{"sync"}, {"share"}, {"save", "arreglo del parser"}} {
+		if _, stderr, code := runSnapgo(t, root, args...); code != 0 {
+			t.Fatalf("%v: %s", args, stderr)
+		}
+	}
+	
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "pull\nupstream\ndesarrollo\n--\npush\nupstream\ndesarrollo\n--\ncommit\n-am\narreglo del parser\n--\n"
+	if string(data) != want {
+		t.Errorf("llamadas a git:\n%s\nse esperaba:\n%s", data, want)
+	}
+}
+
+func TestRunGitCommandArgv(t *testing.T) {
+	log := stubGitLog(t)
+	runGitCommand("commit", "-am", `mi mensaje con "comillas" y 'apóstrofes'`)
+	runGitCommand("checkout", "")
+	
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	want := []string{"commit", "-am", `mi mensaje con "comillas" y 'apóstrofes'`, "--", "checkout", "", "--"}
+	if !slices.Equal(got, want) {
+		t.Errorf("argv = %q, se esperaba %q", got, want)
+	}
+}
+
+func TestSnapshotMessageFromGit(t *testing.T) {
+	stubGit(t, `[ "$3" = log ] && printf 'Arregla el parser de fechas\n\nDetalles del commit\n\n'
+`)
+	t.Setenv("EDITOR", "")
+	t.Setenv("VISUAL", "")
+	root := newTestRepo(t)
+	writeTestFile(t, root, "a.txt", "uno")
+	
+	lastMessage := func() string {
+		idx := readIndex(t, root)
+		return idx.Snapshots[len(idx.Snapshots)-1].Message
+	}
+	if _, stderr, code := runSnapgo(t, root, "snapshot", "--message-from-git"); code != 0 {
+		t.Fatalf("snapshot --message-from-git: %s", stderr)
+	}
+	if got := lastMessage(); got != "Arregla el parser de fechas\n\nDetalles del commit" {
+		t.Errorf("mensaje = %q", got)
+	}
+	
+	// -m tiene prioridad
+	writeTestFile(t, root, "a.txt", "dos")
+	runSnapgo(t, root, "snapshot", "--message-from-git", "-m", "explícito")
+	if got := lastMessage(); got != "explícito" {
+		t.Errorf("con -m, mensaje = %q", got)
+	}
+	
+	// Sin commits (o sin repositorio git) se exige -m y no se crea nada
+	stubGit(t, "exit 128\n")
+	writeTestFile(t, root, "a.txt", "tres")
+	stdout, stderr, code := runSnapgo(t, root, "snapshot", "--message-from-git", "--json")
+	if code == 0 || stdout != "" {
+		t.Errorf("sin commits: código %d, stdout %q", code, stdout)
+	}
+	if !strings.Contains(stderr, "--message-from-git: no hay ningún commit de git") || !strings.Contains(stderr, "snapshot -m") {
+		t.Errorf("sin commits, stderr:\n%s", stderr)
+	}
+	if n := len(readIndex(t, root).Snapshots); n != 2 {
+		t.Errorf("hay %d snapshots, se esperaban 2", n)
+	}
+}
+
+func TestFromListRejectsSymlinkOutsideRepo(t *testing.T) {
+	root := newTestRepo(t)
+	outside := t.TempDir()
+	writeTestFile(t, outside, "s.txt", "SECRET")
+	writeTestFile(t, root, "a.txt", "a")
+	writeTestFile(t, root, "docs/b.txt", "b")
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("docs", filepath.Join(root, "dentro")); err != nil {
+		t.Fatal(err)
+	}
+	
+	if _, err := readFileList(root, writeListFile(t, "a.txt\nlink/s.txt\n")); err == nil || !strings.Contains(err.Error(), "fuera del repositorio") {
+		t.Errorf("link/s.txt fuera del repositorio: %v", err)
+	}
+	files, err := readFileList(root, writeListFile(t, "a.txt\ndentro/b.txt\n"))
+	if err != nil || !slices.Equal(files, []string{"a.txt", "dentro/b.txt"}) {
+		t.Errorf("enlace dentro del repositorio: %v (%v)", files, err)
+	}
+}
+
+func TestAtomicExtractedFileKeepsMode(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	writeTestFile(t, dir, "run.sh", "#!/bin/sh\n")
+	if err := os.Chmod(script, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	
+	// Al sobrescribir se conservan los permisos del archivo existente
+	if err := writeExtractedFile(script, strings.NewReader("#!/bin/sh\necho nuevo\n"), true, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(script); info.Mode().Perm() != 0o755 {
+		t.Errorf("run.sh sobrescrito con permisos %v", info.Mode().Perm())
+	}
+	
+	// Uno nuevo recibe los del snapshot
+	fresh := filepath.Join(dir, "nuevo.sh")
+	if err := writeExtractedFile(fresh, strings.NewReader("#!/bin/sh\n"), true, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(fresh); info.Mode().Perm() != 0o755 {
+		t.Errorf("nuevo.sh creado con permisos %v", info.Mode().Perm())
+	}
+}